@@ -0,0 +1,177 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// addVisibilityPrefix is the prefix of the buildozer command adding entries to
+// the visibility attribute of the target being fixed.
+const addVisibilityPrefix = "add visibility "
+
+// riskOrder lists the risk tiers from the least to the most risky.
+var riskOrder = []string{riskPackage, riskTopLevelDir, riskCrossTree}
+
+// mergeFixes combines the fixes of the same target into a single fix granting
+// all their consumers, at the position of the first of them, so that the user
+// is asked once and the rule is edited by one buildozer command, or one edit
+// of each file the fixes edit, e.g. an allowlist file.
+func mergeFixes(fixes []*visibilityFix) ([]*visibilityFix, error) {
+	var merged []*visibilityFix
+	byTarget := make(map[string]*visibilityFix)
+	for _, fix := range fixes {
+		combined, ok := byTarget[fix.target]
+		if !ok {
+			byTarget[fix.target] = fix
+			merged = append(merged, fix)
+			continue
+		}
+		if len(combined.merged) == 0 {
+			first := *combined
+			combined.merged = []*visibilityFix{&first}
+		}
+		combined.merged = append(combined.merged, fix)
+		edits, err := mergeEdits(combined.fileEdits, fix.fileEdits)
+		if err != nil {
+			return nil, err
+		}
+		combined.fileEdits = edits
+		if fix.severity > combined.severity {
+			combined.severity = fix.severity
+		}
+		if riskRank(fix.tier) > riskRank(combined.tier) {
+			combined.tier = fix.tier
+		}
+		combined.referencingAttrs = nil
+	}
+	for _, fix := range merged {
+		if len(fix.merged) > 0 {
+			fix.commands = mergeCommands(fix.target, fix.merged, func(f *visibilityFix) []buildozerCommand { return f.commands })
+			fix.cleanupCommands = mergeCommands(fix.target, fix.merged, func(f *visibilityFix) []buildozerCommand { return f.cleanupCommands })
+		}
+	}
+	return merged, nil
+}
+
+// mergeEdits returns the given file edits combined with the others, those of
+// the same attribute or allowlist being merged into one. The given edits are
+// left as is, being those of the fixes combined.
+func mergeEdits(edits, others []fileEdit) ([]fileEdit, error) {
+	merged := append([]fileEdit(nil), edits...)
+	for _, other := range others {
+		combined := false
+		for i, e := range merged {
+			m, err := e.merge(other)
+			if err != nil {
+				return nil, err
+			}
+			if m != nil {
+				merged[i], combined = m, true
+				break
+			}
+		}
+		if !combined {
+			merged = append(merged, other)
+		}
+	}
+	return merged, nil
+}
+
+// mergeCommands combines the commands of the given fixes of the target: the
+// entries added to its visibility attribute are added by a single command,
+// likewise for the entries removed, and the other commands are deduplicated.
+func mergeCommands(target string, fixes []*visibilityFix, commandsOf func(*visibilityFix) []buildozerCommand) []buildozerCommand {
+	var commands []buildozerCommand
+	var added, removed []string
+	seen := make(map[string]bool)
+	for _, fix := range fixes {
+		for _, c := range commandsOf(fix) {
+			switch {
+			case c.target == target && strings.HasPrefix(c.command, addVisibilityPrefix):
+				added = appendNew(added, seen, strings.Fields(strings.TrimPrefix(c.command, addVisibilityPrefix))...)
+			case c.target == target && strings.HasPrefix(c.command, "remove visibility ") && c.command != removePrivateVisibilityBuildozerCommand:
+				removed = appendNew(removed, seen, strings.Fields(strings.TrimPrefix(c.command, "remove visibility "))...)
+			default:
+				key := c.command + "|" + c.target
+				if !seen[key] {
+					seen[key] = true
+					commands = append(commands, c)
+				}
+			}
+		}
+	}
+	var combined []buildozerCommand
+	if len(added) > 0 {
+		combined = append(combined, buildozerCommand{command: addVisibilityPrefix + strings.Join(added, " "), target: target})
+	}
+	if len(removed) > 0 {
+		combined = append(combined, buildozerCommand{command: "remove visibility " + strings.Join(removed, " "), target: target})
+	}
+	return append(combined, commands...)
+}
+
+// appendNew appends the given entries not seen yet.
+func appendNew(entries []string, seen map[string]bool, values ...string) []string {
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			entries = append(entries, v)
+		}
+	}
+	return entries
+}
+
+// riskRank returns the rank of the given risk tier in riskOrder.
+func riskRank(tier string) int {
+	for i, t := range riskOrder {
+		if t == tier {
+			return i
+		}
+	}
+	return len(riskOrder)
+}
+
+// constituents returns the fixes combined into the given one, or the fix
+// itself when it wasn't combined.
+func (fix *visibilityFix) constituents() []*visibilityFix {
+	if len(fix.merged) > 0 {
+		return fix.merged
+	}
+	return []*visibilityFix{fix}
+}
+
+// grantsString returns the entries granted by the fix, or by the fixes
+// combined into it.
+func (fix *visibilityFix) grantsString() string {
+	var grants []string
+	for _, f := range fix.constituents() {
+		grants = append(grants, f.grant.String())
+	}
+	return strings.Join(grants, ", ")
+}
+
+// consumersString returns the consumers of the fix, or of the fixes combined
+// into it.
+func (fix *visibilityFix) consumersString() string {
+	if len(fix.merged) == 0 {
+		if len(fix.node.folded) > 0 {
+			return fmt.Sprintf("%s (along with %s, in the same package)", fix.node.from, strings.Join(fix.node.folded, ", "))
+		}
+		return fix.node.from
+	}
+	var consumers []string
+	for _, f := range fix.merged {
+		consumers = append(consumers, f.node.from)
+		consumers = append(consumers, f.node.folded...)
+	}
+	return fmt.Sprintf("%d consumers: %s", len(consumers), strings.Join(consumers, ", "))
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeFixes(t *testing.T) {
+	fixOf := func(target, grant string, severity int, tier string) *visibilityFix {
+		return &visibilityFix{
+			node:     &fixNode{toFix: target},
+			target:   target,
+			severity: severity,
+			tier:     tier,
+			commands: []buildozerCommand{
+				{command: addVisibilityPrefix + grant, target: target},
+				{command: removePrivateVisibilityBuildozerCommand, target: target},
+			},
+			cleanupCommands: []buildozerCommand{{command: "remove visibility " + strings.Replace(grant, ":", "/sub:", 1), target: target}},
+		}
+	}
+	a := fixOf("//lib:a", "//app:__pkg__", severitySameTeam, riskTopLevelDir)
+	b := fixOf("//lib:b", "//app:__pkg__", severitySameTeam, riskPackage)
+	a2 := fixOf("//lib:a", "//tool:__pkg__", severityCrossTeam, riskCrossTree)
+	a3 := fixOf("//lib:a", "//app:__pkg__", severitySameTeam, riskPackage)
+
+	merged, err := mergeFixes([]*visibilityFix{a, b, a2, a3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(merged) != 2 || merged[0] != a || merged[1] != b {
+		t.Fatalf("mergeFixes() = %v, want the fixes of //lib:a and //lib:b", merged)
+	}
+	if len(a.merged) != 3 || a.merged[1] != a2 || a.merged[2] != a3 || len(b.merged) != 0 {
+		t.Errorf("mergeFixes() combined %d fixes of //lib:a and %d of //lib:b, want 3 and none", len(a.merged), len(b.merged))
+	}
+	if a.severity != severityCrossTeam || a.tier != riskCrossTree {
+		t.Errorf("mergeFixes() = a %s fix of severity %d, want the most risky and severe of the combined ones", a.tier, a.severity)
+	}
+	wantCommands := []buildozerCommand{
+		{command: "add visibility //app:__pkg__ //tool:__pkg__", target: "//lib:a"},
+		{command: removePrivateVisibilityBuildozerCommand, target: "//lib:a"},
+	}
+	if !reflect.DeepEqual(a.commands, wantCommands) {
+		t.Errorf("mergeFixes() commands = %v, want %v", a.commands, wantCommands)
+	}
+	wantCleanup := []buildozerCommand{{command: "remove visibility //app/sub:__pkg__ //tool/sub:__pkg__", target: "//lib:a"}}
+	if !reflect.DeepEqual(a.cleanupCommands, wantCleanup) {
+		t.Errorf("mergeFixes() cleanup commands = %v, want %v", a.cleanupCommands, wantCleanup)
+	}
+	// The first fix is kept as it was prepared among the combined ones.
+	if first := a.merged[0]; len(first.merged) != 0 || len(first.commands) != 2 || first.commands[0].command != addVisibilityPrefix+"//app:__pkg__" {
+		t.Errorf("mergeFixes() changed the first of the combined fixes: %+v", first)
+	}
+}
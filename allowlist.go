@@ -0,0 +1,223 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/buildtools/edit"
+)
+
+// allowlistHeader is the docstring of the allowlist files created by the
+// plugin.
+const allowlistHeader = `"""Visibility allowlists of the targets in this package.
+
+Maintained by the fix-visibility plugin of the aspect CLI.
+"""`
+
+// allowlistVariable returns the name of the variable holding the visibility
+// allowlist of the target with the given name, e.g. CORE_VISIBILITY for core.
+func allowlistVariable(name string) string {
+	variable := strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, name)
+	if variable == "" || unicode.IsDigit(rune(variable[0])) {
+		variable = "_" + variable
+	}
+	return variable + "_VISIBILITY"
+}
+
+// prepareAllowlistFix computes the edits granting visibility through the
+// allowlist file of the package of the target being fixed, instead of its
+// BUILD rule. On the first fix of a target, its literal visibility list is
+// migrated to the allowlist, which the BUILD file then loads.
+func (plugin *FixVisibilityPlugin) prepareAllowlistFix(
+	fix *visibilityFix,
+	visibility *visibilityAttr,
+	variable string,
+) error {
+	buildFile, _, _ := edit.InterpretLabelForWorkspaceLocation("", fix.target)
+	path := filepath.Join(filepath.Dir(buildFile), plugin.properties.AllowlistFile)
+	var initial []string
+	for _, entry := range visibility.entries {
+		if entry != privateVisibility {
+			initial = append(initial, entry)
+		}
+	}
+	allowlist, err := loadAllowlistEdit(path, variable, initial)
+	if err != nil {
+		return err
+	}
+	allowlist.add(plugin.formatLabel(fix.grant))
+	fix.fileEdits = append(fix.fileEdits, allowlist)
+
+	if visibility.literal {
+		rewrite, err := loadVisibilityRewrite(fix.node.toFix, fix.target, func(build.Expr) build.Expr {
+			return &build.Ident{Name: variable}
+		})
+		if err != nil {
+			return err
+		}
+		fix.fileEdits = append(fix.fileEdits, rewrite)
+		fix.commands = append(fix.commands, buildozerCommand{
+			command: fmt.Sprintf("new_load :%s %s", plugin.properties.AllowlistFile, variable),
+			target:  packageTarget(fix.target),
+		})
+	}
+	return nil
+}
+
+// packageTarget returns the buildozer target addressing the package of the
+// given buildozer target.
+func packageTarget(target string) string {
+	if i := strings.LastIndex(target, ":"); i >= 0 {
+		target = target[:i]
+	}
+	return target + ":__pkg__"
+}
+
+// allowlistEdit adds entries to the visibility allowlist of a target in the
+// allowlist file of its package. The file is loaded again when the edit is
+// applied, so that the edits of the other targets sharing it are kept.
+type allowlistEdit struct {
+	path     string
+	file     *build.File
+	variable string
+	list     *build.ListExpr
+	initial  []string
+	entries  []string
+	added    []string
+}
+
+// loadAllowlistEdit loads the allowlist held by the given variable from the
+// allowlist file at the given path. The file and the variable are created
+// with the initial entries when they don't exist yet.
+func loadAllowlistEdit(path, variable string, initial []string) (*allowlistEdit, error) {
+	var file *build.File
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if file, err = build.ParseBzl(path, []byte(allowlistHeader+"\n")); err != nil {
+			return nil, fmt.Errorf("failed to create allowlist file: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read allowlist file: %w", err)
+	default:
+		if file, err = build.ParseBzl(path, data); err != nil {
+			return nil, fmt.Errorf("failed to parse allowlist file: %w", err)
+		}
+	}
+
+	allowlist := &allowlistEdit{
+		path:     path,
+		file:     file,
+		variable: variable,
+		initial:  initial,
+	}
+	for _, stmt := range file.Stmt {
+		if assign, ok := stmt.(*build.AssignExpr); ok {
+			if ident, ok := assign.LHS.(*build.Ident); ok && ident.Name == variable {
+				list, ok := assign.RHS.(*build.ListExpr)
+				if !ok {
+					return nil, fmt.Errorf("%s in %s is not a literal list", variable, path)
+				}
+				allowlist.list = list
+				return allowlist, nil
+			}
+		}
+	}
+
+	allowlist.list = &build.ListExpr{ForceMultiLine: true}
+	for _, entry := range initial {
+		allowlist.list.List = append(allowlist.list.List, &build.StringExpr{Value: entry})
+	}
+	file.Stmt = append(file.Stmt, &build.AssignExpr{
+		LHS: &build.Ident{Name: variable},
+		Op:  "=",
+		RHS: allowlist.list,
+	})
+	return allowlist, nil
+}
+
+// add adds the entry to the allowlist, unless it's already there.
+func (a *allowlistEdit) add(entry string) {
+	a.entries = append(a.entries, entry)
+	for _, e := range a.list.List {
+		if str, ok := e.(*build.StringExpr); ok && str.Value == entry {
+			return
+		}
+	}
+	a.list.List = append(a.list.List, &build.StringExpr{Value: entry})
+	a.added = append(a.added, entry)
+}
+
+// String satisfies the fileEdit interface.
+func (a *allowlistEdit) String() string {
+	return fmt.Sprintf("add %s to %s in %s", strings.Join(a.added, ", "), a.variable, a.path)
+}
+
+// resultingEntries satisfies the fileEdit interface.
+func (a *allowlistEdit) resultingEntries() []string {
+	return stringValues(a.list)
+}
+
+// merge satisfies the fileEdit interface. The entries added to the same
+// allowlist are added at once.
+func (a *allowlistEdit) merge(other fileEdit) (fileEdit, error) {
+	o, ok := other.(*allowlistEdit)
+	if !ok || o.path != a.path || o.variable != a.variable {
+		return nil, nil
+	}
+	merged, err := loadAllowlistEdit(a.path, a.variable, a.initial)
+	if err != nil {
+		return nil, err
+	}
+	for _, entries := range [][]string{a.entries, o.entries} {
+		for _, entry := range entries {
+			merged.add(entry)
+		}
+	}
+	return merged, nil
+}
+
+// apply satisfies the fileEdit interface. It adds the entries to the current
+// content of the allowlist file, which the previous fixes may have edited
+// since it was loaded.
+func (a *allowlistEdit) apply() error {
+	data, err := a.updated()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(a.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write allowlist file: %w", err)
+	}
+	return nil
+}
+
+// updated returns the current content of the allowlist file with the entries
+// added.
+func (a *allowlistEdit) updated() ([]byte, error) {
+	current, err := loadAllowlistEdit(a.path, a.variable, a.initial)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range a.entries {
+		current.add(entry)
+	}
+	return build.Format(current.file), nil
+}
@@ -0,0 +1,157 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	aspectbazel "aspect.build/cli/pkg/bazel"
+	"aspect.build/cli/pkg/ioutils"
+	aspectplugin "aspect.build/cli/pkg/plugin/sdk/v1alpha3/plugin"
+)
+
+// analysisCommandName is the name of the custom command running the
+// analysis-only Bazel commands, which have no post hook, and fixing their
+// visibility issues. A build --nobuild needs no such command, as it runs the
+// post-build hook.
+const analysisCommandName = "fix-visibility"
+
+// analysisCommandHelp is the description of the custom command.
+const analysisCommandHelp = `Runs the given cquery or aquery command, e.g.
+"aspect fix-visibility cquery 'deps(//app)'", then fixes the visibility issues
+reported by its analysis as after a build. The behavior after the command is
+configured by the hooks property, under the name of the Bazel command, and
+overridden by the --fix-visibility:<key>[=<value>] flags, which are not passed
+to Bazel. The fixes are printed instead of prompted for with --noninteractive,
+or when the input or the output is not a terminal.`
+
+// CustomCommands satisfies the Plugin interface. It adds the command fixing
+// the visibility issues of the analysis-only Bazel commands, which don't run
+// the post hooks, and the command reporting the trends of the history file.
+func (plugin *FixVisibilityPlugin) CustomCommands() ([]*aspectplugin.Command, error) {
+	return []*aspectplugin.Command{
+		aspectplugin.NewCommand(
+			analysisCommandName,
+			"Fix the visibility issues reported by cquery and aquery",
+			analysisCommandHelp,
+			plugin.runAnalysisCommand,
+		),
+		aspectplugin.NewCommand(
+			trendsCommandName,
+			"Report the visibility issues most often left unfixed",
+			trendsCommandHelp,
+			plugin.runTrendsCommand,
+		),
+	}, nil
+}
+
+// runAnalysisCommand runs the Bazel command given as arguments, collecting
+// the visibility issues from its error output, then fixes them.
+func (plugin *FixVisibilityPlugin) runAnalysisCommand(ctx context.Context, args []string, bzl aspectbazel.Bazel) error {
+	// The override flags are the plugin's, not Bazel's, and so is
+	// --noninteractive.
+	args, overrides := splitOverrideFlags(args)
+	args, nonInteractive := splitNonInteractiveFlag(args)
+	plugin.mu.Lock()
+	plugin.startInvocation()
+	plugin.overrides = append(plugin.overrides, overrides...)
+	plugin.mu.Unlock()
+
+	if len(args) == 0 || (args[0] != hookCquery && args[0] != hookAquery) {
+		return fmt.Errorf("usage: aspect %s (%s|%s) <args>", analysisCommandName, hookCquery, hookAquery)
+	}
+
+	// The analysis keeps going after the first error, so that all the issues
+	// are collected at once.
+	command := append([]string{args[0], "--keep_going"}, args[1:]...)
+	scanner := &issueScanner{plugin: plugin}
+	streams := ioutils.Streams{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Stderr: io.MultiWriter(os.Stderr, scanner),
+	}
+	exitCode, err := bzl.RunCommand(streams, command...)
+	if err != nil {
+		return fmt.Errorf("failed to run bazel %s: %w", args[0], err)
+	}
+	if err := scanner.Close(); err != nil {
+		return err
+	}
+
+	// The custom commands are not handed a prompt runner, so the prompts are
+	// plain lines, read from the standard input shared with the CLI. As for
+	// the hooks, the prompts property and CI environments may still suppress
+	// them.
+	isInteractiveMode := !nonInteractive && inputIsTerminal() && outputIsTerminal()
+	if err := plugin.postHook(args[0], isInteractiveMode, newLinePromptRunner(os.Stdin, os.Stdout)); err != nil {
+		return err
+	}
+	if exitCode != 0 {
+		return fmt.Errorf("bazel %s exited with code %d", args[0], exitCode)
+	}
+	return nil
+}
+
+// nonInteractiveFlag disables the prompts of the custom command, the fixes
+// being printed as in non-interactive mode.
+const nonInteractiveFlag = "--noninteractive"
+
+// splitNonInteractiveFlag removes the nonInteractiveFlag from the given
+// arguments of a custom command, returning whether it was there.
+func splitNonInteractiveFlag(args []string) (rest []string, nonInteractive bool) {
+	for _, arg := range args {
+		if arg == nonInteractiveFlag {
+			nonInteractive = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, nonInteractive
+}
+
+// issueScanner collects the visibility issues from the error output of
+// Bazel written to it, line by line.
+type issueScanner struct {
+	plugin *FixVisibilityPlugin
+	line   bytes.Buffer
+}
+
+func (s *issueScanner) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\n' {
+			s.line.WriteByte(b)
+			continue
+		}
+		if err := s.scanLine(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close scans the last line, when not terminated by a newline.
+func (s *issueScanner) Close() error {
+	return s.scanLine()
+}
+
+// scanLine collects the visibility issue of the current line, if any.
+func (s *issueScanner) scanLine() error {
+	line := s.line.String()
+	s.line.Reset()
+	if !strings.Contains(line, visibilityIssueSubstring) {
+		return nil
+	}
+	return s.plugin.collectIssue(line, "")
+}
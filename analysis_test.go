@@ -0,0 +1,33 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitAnalysisFlags(t *testing.T) {
+	args := strings.Fields("--noninteractive cquery --fix-visibility:dry-run deps(//app) --output=label")
+	args, overrides := splitOverrideFlags(args)
+	rest, nonInteractive := splitNonInteractiveFlag(args)
+	if got, want := strings.Join(rest, " "), "cquery deps(//app) --output=label"; got != want {
+		t.Errorf("the arguments passed to Bazel are %q, want %q", got, want)
+	}
+	if len(overrides) != 1 || overrides[0] != overrideDryRun {
+		t.Errorf("the overrides are %q, want %q", overrides, overrideDryRun)
+	}
+	if !nonInteractive {
+		t.Errorf("splitNonInteractiveFlag(%q) is interactive, want non-interactive", args)
+	}
+	if _, nonInteractive := splitNonInteractiveFlag(rest); nonInteractive {
+		t.Errorf("splitNonInteractiveFlag(%q) is non-interactive, want interactive", rest)
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// auditLogFilename is the name of the audit trail file in pluginDir.
+const auditLogFilename = "audit.jsonl"
+
+// auditRecord is the record of an applied fix in the audit trail, written as
+// a JSON line when the audit_log property is set.
+type auditRecord struct {
+	Timestamp    string `json:"timestamp"`
+	User         string `json:"user"`
+	InvocationID string `json:"invocation_id"`
+	ToFix        string `json:"to_fix"`
+	// Consumers and Grants are those of the fixes combined into the applied
+	// one, a single one unless several consumers were granted at once.
+	Consumers []string     `json:"consumers"`
+	Grants    []string     `json:"grants"`
+	FileEdits []string     `json:"file_edits,omitempty"`
+	Commands  []commandRun `json:"commands"`
+	// Failure is the error the fix failed with partway, the file edits and
+	// commands recorded being those applied before it.
+	Failure string `json:"failure,omitempty"`
+}
+
+// commandRun is a buildozer command run by the plugin, with its output.
+type commandRun struct {
+	Command string `json:"command"`
+	Target  string `json:"target"`
+	Output  string `json:"output"`
+}
+
+// auditFix records the given applied fix, with the file edits applied and
+// the commands run, including those of its cleanup, in the audit trail when
+// the audit_log property is set. A fix failing partway is recorded with the
+// failure, along with what was applied before it. The fix being applied
+// already, failing to record it only warns.
+func (plugin *FixVisibilityPlugin) auditFix(fix *visibilityFix, edits []fileEdit, runs []commandRun, failure error) {
+	if !plugin.properties.AuditLog {
+		return
+	}
+	if err := plugin.recordAudit(fix, edits, runs, failure); err != nil {
+		plugin.warnings.warnf("Could not record the fix of %s in the audit trail: %v\n", fix.node.toFix, err)
+	}
+}
+
+// recordAudit appends the record of the given applied fix, with the file
+// edits applied, the commands run and the failure, if any, to the audit trail.
+func (plugin *FixVisibilityPlugin) recordAudit(fix *visibilityFix, edits []fileEdit, runs []commandRun, failure error) error {
+	record := auditRecord{
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		User:         currentUser(),
+		InvocationID: plugin.invocationID,
+		ToFix:        fix.node.toFix,
+		Commands:     runs,
+	}
+	for _, f := range fix.constituents() {
+		record.Consumers = append(record.Consumers, f.node.from)
+		record.Grants = append(record.Grants, f.grant.String())
+	}
+	for _, e := range edits {
+		record.FileEdits = append(record.FileEdits, e.String())
+	}
+	if failure != nil {
+		record.Failure = failure.Error()
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to record the audit trail: %w", err)
+	}
+
+	workspaceRoot, err := plugin.workspaceRoot()
+	if err != nil {
+		return fmt.Errorf("failed to record the audit trail: %w", err)
+	}
+	path := pluginFilePath(workspaceRoot, auditLogFilename)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to record the audit trail: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to record the audit trail: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to record the audit trail: %w", err)
+	}
+	return nil
+}
+
+// currentUser returns the name of the user running the plugin.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	aspectplugin "aspect.build/cli/pkg/plugin/sdk/v1alpha3/plugin"
+)
+
+// repeatedEntry is the visibility of //lib:lib repeating its //tools:__pkg__
+// entry, whose removal is offered along with the fix.
+const repeatedEntry = "[\n        \"//tools:__pkg__\",\n        \"//tools:__pkg__\",\n    ]"
+
+// runAuditedFix applies the fix of //lib:lib, of the given visibility, for
+// //app:app in a workspace prepared by the given function, with the audit_log
+// property set and the buildozer commands run by the given runner.
+func runAuditedFix(t *testing.T, visibility string, buildozer runner, prepare func(workspaceDir string)) (workspaceDir string, err error) {
+	workspaceDir = t.TempDir()
+	files := map[string]string{
+		"WORKSPACE":       "",
+		"app/BUILD.bazel": "filegroup(\n    name = \"app\",\n    srcs = [\"//lib\"],\n)\n",
+		"lib/BUILD.bazel": "filegroup(\n    name = \"lib\",\n    srcs = [],\n    visibility = " + visibility + ",\n)\n",
+	}
+	for path, content := range files {
+		path = filepath.Join(workspaceDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prepare(workspaceDir)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Buildozer resolves the labels against the current directory.
+	if err := os.Chdir(workspaceDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	plugin := &FixVisibilityPlugin{
+		buildozer:    buildozer,
+		bazel:        &scriptedRunner{name: "bazel"},
+		targetsToFix: &fixOrderedSet{nodes: make(map[fixKey]*fixNode)},
+	}
+	if err := plugin.Setup(&aspectplugin.SetupConfig{Properties: []byte("audit_log: true\n")}); err != nil {
+		t.Fatal(err)
+	}
+	plugin.properties.Output = outputStyled
+	plugin.properties.Prompts = promptsCLI
+	events := []e2eEvent{
+		{Started: "build"},
+		{
+			Target: "//app:app",
+			Aborted: "ERROR: /workspace/app/BUILD.bazel:1:10: in filegroup rule //app:app: target '//lib:lib' is not visible from target " +
+				"'//app:app'. Check the visibility declaration of the former target if you think the dependency is legitimate",
+		},
+	}
+	for _, e := range events {
+		if err := plugin.BEPEventCallback(e.buildEvent(workspaceDir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = plugin.PostBuildHook(true, &scriptedPromptRunner{answers: []string{"y", "y"}})
+	return workspaceDir, err
+}
+
+// auditRecordOf returns the only record of the audit trail of the given
+// workspace.
+func auditRecordOf(t *testing.T, workspaceDir string) auditRecord {
+	data, err := os.ReadFile(pluginFilePath(workspaceDir, auditLogFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record auditRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	return record
+}
+
+// failingRunner fails the runs of the given buildozer command.
+type failingRunner struct {
+	runner
+	command string
+}
+
+func (r *failingRunner) run(args ...string) ([]byte, error) {
+	if args[0] == r.command {
+		return nil, fmt.Errorf("failed to run buildozer %q", args)
+	}
+	return r.runner.run(args...)
+}
+
+func TestAuditRecordsTheCleanup(t *testing.T) {
+	workspaceDir, err := runAuditedFix(t, repeatedEntry, &buildozer{}, func(string) {})
+	if err != nil {
+		t.Fatalf("PostBuildHook() = %v", err)
+	}
+	record := auditRecordOf(t, workspaceDir)
+	var commands []string
+	for _, run := range record.Commands {
+		commands = append(commands, run.Command)
+	}
+	want := []string{"add visibility //app:__pkg__", "remove visibility //tools:__pkg__"}
+	if strings.Join(commands, "\n") != strings.Join(want, "\n") {
+		t.Errorf("the audit trail recorded the commands %q, want %q", commands, want)
+	}
+}
+
+func TestAuditFailureKeepsTheFix(t *testing.T) {
+	workspaceDir, err := runAuditedFix(t, repeatedEntry, &buildozer{}, func(workspaceDir string) {
+		// The plugin directory can't be created over a file.
+		if err := os.MkdirAll(filepath.Join(workspaceDir, ".aspect"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(workspaceDir, pluginDir), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err != nil {
+		t.Fatalf("PostBuildHook() = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(workspaceDir, "lib/BUILD.bazel"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"//app:__pkg__"`) {
+		t.Errorf("lib/BUILD.bazel = %q, want the fix applied", data)
+	}
+}
+
+func TestAuditRecordsTheFailedFix(t *testing.T) {
+	// The grant is added before the private visibility is removed.
+	failing := &failingRunner{runner: &buildozer{}, command: removePrivateVisibilityBuildozerCommand}
+	workspaceDir, err := runAuditedFix(t, `["//visibility:private"]`, failing, func(string) {})
+	if err == nil {
+		t.Fatal("PostBuildHook() = nil, want the failure of buildozer")
+	}
+	record := auditRecordOf(t, workspaceDir)
+	if len(record.Commands) != 1 || record.Commands[0].Command != "add visibility //app:__pkg__" {
+		t.Errorf("the audit trail recorded the commands %+v, want the grant applied", record.Commands)
+	}
+	if !strings.Contains(record.Failure, removePrivateVisibilityBuildozerCommand) {
+		t.Errorf("the audit trail recorded the failure %q, want that of buildozer", record.Failure)
+	}
+}
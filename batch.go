@@ -0,0 +1,120 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"aspect.build/cli/pkg/ioutils"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/manifoldco/promptui"
+)
+
+// The possible values for the batch_by property.
+const (
+	// batchByDirectory batches the fixes by the top-level directory of the
+	// target being fixed, each batch holding at most batch_size fixes. This
+	// is the default.
+	batchByDirectory = "directory"
+	// batchByCount batches the fixes in their order, batch_size at a time.
+	batchByCount = "count"
+)
+
+// fixBatch is a chunk of the fixes, handled one after the other when the
+// batch_size property is set.
+type fixBatch struct {
+	// name describes the fixes of the batch, e.g. their directory.
+	name     string
+	fixes    []*visibilityFix
+	statuses map[fixStatus]int
+}
+
+// batchFixes splits the fixes in batches as set by the batch_size and
+// batch_by properties, keeping their order within each batch. The batches
+// come in the order of their first fix, so the most severe first. Without a
+// batch_size, all the fixes are a single batch.
+func (plugin *FixVisibilityPlugin) batchFixes(fixes []*visibilityFix) []*fixBatch {
+	size := plugin.properties.BatchSize
+	if size == 0 {
+		return []*fixBatch{{fixes: fixes, statuses: map[fixStatus]int{}}}
+	}
+	var batches []*fixBatch
+	open := map[string]*fixBatch{}
+	for _, fix := range fixes {
+		key := ""
+		if plugin.properties.BatchBy == batchByDirectory {
+			key = topLevelDirectory(fix.node.toFix)
+		}
+		batch, ok := open[key]
+		if !ok || len(batch.fixes) == size {
+			batch = &fixBatch{name: key, statuses: map[fixStatus]int{}}
+			open[key] = batch
+			batches = append(batches, batch)
+		}
+		batch.fixes = append(batch.fixes, fix)
+	}
+	return batches
+}
+
+// topLevelDirectory returns the top-level directory of the package of the
+// given target, e.g. //src for //src/lib:core.
+func topLevelDirectory(target string) string {
+	l, err := label.Parse(target)
+	if err != nil {
+		return target
+	}
+	dir, _, _ := strings.Cut(l.Pkg, "/")
+	if l.Repo != "" {
+		return fmt.Sprintf("@%s//%s", l.Repo, dir)
+	}
+	return "//" + dir
+}
+
+// count records the status of the given number of fixes of the batch.
+func (b *fixBatch) count(status fixStatus, n int) {
+	b.statuses[status] += n
+}
+
+// printHeader prints the header of the batch, the given one of the given
+// number of batches.
+func (b *fixBatch) printHeader(index, total int) {
+	fmt.Fprintf(os.Stdout, "Batch %d of %d", index+1, total)
+	if b.name != "" {
+		fmt.Fprintf(os.Stdout, ", under %s", b.name)
+	}
+	fmt.Fprintf(os.Stdout, ": %d fix(es)\n", len(b.fixes))
+}
+
+// printSummary prints how the fixes of the batch were handled.
+func (b *fixBatch) printSummary(index int) {
+	var counts []string
+	for _, status := range []fixStatus{fixApplied, fixPrinted, fixDeclined, fixObsolete} {
+		if n := b.statuses[status]; n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, fixStatusNames[status]))
+		}
+	}
+	fmt.Fprintf(os.Stdout, "Batch %d done: %s\n", index+1, strings.Join(counts, ", "))
+}
+
+// continueBatches asks the user whether to go on with the next batch, the
+// given one of the given number of batches, e.g. after committing the
+// previous one.
+func (plugin *FixVisibilityPlugin) continueBatches(next, total int, promptRunner ioutils.PromptRunner) bool {
+	label := strings.ReplaceAll(plugin.messages.BatchPrompt, "{batch}", strconv.Itoa(next+1))
+	prompt := plugin.prompt(promptui.Prompt{
+		Label:     strings.ReplaceAll(label, "{count}", strconv.Itoa(total)),
+		IsConfirm: true,
+	})
+	_, err := promptRunner.Run(prompt)
+	return err == nil
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatchFixes(t *testing.T) {
+	var fixes []*visibilityFix
+	for _, toFix := range []string{"//src/a:a", "//lib:a", "//src/b:b", "//src:c", "@dep//src:d", "//lib/x:x"} {
+		fixes = append(fixes, &visibilityFix{node: &fixNode{toFix: toFix, from: "//app:app"}})
+	}
+	for _, test := range []struct {
+		size  int
+		by    string
+		names []string
+	}{
+		{0, batchByDirectory, []string{""}},
+		{4, batchByCount, []string{"", ""}},
+		{2, batchByDirectory, []string{"//src", "//lib", "//src", "@dep//src"}},
+	} {
+		plugin := &FixVisibilityPlugin{properties: &pluginProperties{BatchSize: test.size, BatchBy: test.by}}
+		batches := plugin.batchFixes(fixes)
+		var names []string
+		var batched []*visibilityFix
+		for _, batch := range batches {
+			names = append(names, batch.name)
+			batched = append(batched, batch.fixes...)
+			if test.size > 0 && len(batch.fixes) > test.size {
+				t.Errorf("a batch of size %d holds %d fixes", test.size, len(batch.fixes))
+			}
+		}
+		if !reflect.DeepEqual(names, test.names) || len(batched) != len(fixes) {
+			t.Errorf("batchFixes() with a size of %d by %s made batches %q of %d fixes, want %q of %d", test.size, test.by, names, len(batched), test.names, len(fixes))
+		}
+	}
+
+	plugin := &FixVisibilityPlugin{properties: &pluginProperties{BatchSize: 2, BatchBy: batchByDirectory}}
+	var src []string
+	for _, fix := range plugin.batchFixes(fixes)[2].fixes {
+		src = append(src, fix.node.toFix)
+	}
+	if want := []string{"//src:c"}; !reflect.DeepEqual(src, want) {
+		t.Errorf("the second batch under //src holds %q, want %q", src, want)
+	}
+}
+
+func TestTopLevelDirectory(t *testing.T) {
+	for _, test := range []struct {
+		target, dir string
+	}{
+		{"//src/lib:core", "//src"},
+		{"//src:core", "//src"},
+		{"//:root", "//"},
+		{"@dep//src/lib:core", "@dep//src"},
+	} {
+		if dir := topLevelDirectory(test.target); dir != test.dir {
+			t.Errorf("topLevelDirectory(%q) = %q, want %q", test.target, dir, test.dir)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// visibilityBreadth returns the entries the visibility of the target being
+// fixed would have after the fix, deduplicated and without
+// //visibility:private, whether the fix edits the visibility list itself, or
+// the files holding it, e.g. an allowlist or the branches of a select().
+func visibilityBreadth(fix *visibilityFix) ([]label.Label, error) {
+	toFixLabel, err := label.Parse(fix.node.toFix)
+	if err != nil {
+		return nil, err
+	}
+	var entries []label.Label
+	seen := make(map[string]bool)
+	add := func(l label.Label) {
+		if key := l.String(); !seen[key] && key != privateVisibility {
+			seen[key] = true
+			entries = append(entries, l)
+		}
+	}
+	existing := fix.existing
+	for _, f := range fix.constituents() {
+		for _, e := range f.fileEdits {
+			existing = append(existing, e.resultingEntries()...)
+		}
+	}
+	for _, entry := range existing {
+		if l, err := label.Parse(entry); err == nil {
+			add(l.Abs(toFixLabel.Repo, toFixLabel.Pkg))
+		}
+	}
+	for _, f := range fix.constituents() {
+		add(f.grant)
+	}
+	return entries, nil
+}
+
+// visibilityPackages returns the number of distinct packages among the given
+// visibility entries, e.g. 1 for //pkg:__pkg__ and //pkg:tests.
+func visibilityPackages(entries []label.Label) int {
+	packages := make(map[label.Label]bool)
+	for _, e := range entries {
+		packages[label.New(e.Repo, e.Pkg, "")] = true
+	}
+	return len(packages)
+}
+
+// exceedsBreadth returns whether the fix would grow the visibility of the
+// target being fixed past the max_visibility_entries property, counted in
+// distinct packages, in which case it prints the alternatives to appending
+// the entries: converting the visibility to a package_group, or escalating to
+// the owners of the target.
+func (plugin *FixVisibilityPlugin) exceedsBreadth(fix *visibilityFix) bool {
+	limit := plugin.properties.MaxVisibilityEntries
+	if limit == 0 {
+		return false
+	}
+	entries, err := visibilityBreadth(fix)
+	if err != nil {
+		return false
+	}
+	breadth := visibilityPackages(entries)
+	if breadth <= limit {
+		return false
+	}
+
+	fmt.Fprintf(os.Stdout, "The fix would grow the visibility of %s to %d packages, past the maximum of %d: it must be applied manually\n",
+		fix.node.toFix, breadth, limit)
+	plugin.quarantine(fix, fmt.Sprintf("max_visibility_entries: %d", limit),
+		fmt.Sprintf("convert the visibility to a package_group as suggested, or raise max_visibility_entries to %d", breadth))
+	toFixLabel, _ := label.Parse(fix.node.toFix)
+	if plugin.codeowners != nil {
+		if owners := plugin.codeowners.teamOwners(toFixLabel.Pkg); len(owners) > 0 {
+			fmt.Fprintf(os.Stdout, "Consider asking its owners, %s, whether it should be visible this widely.\n", strings.Join(owners, " "))
+		}
+	}
+
+	group := toFixLabel
+	group.Name = toFixLabel.Name + "_visibility"
+	pkg := toFixLabel
+	pkg.Name = "__pkg__"
+	var packages, includes []string
+	for _, e := range entries {
+		switch e.Name {
+		case "__pkg__", "__subpackages__":
+			packages = append(packages, packageSpec(e))
+		default:
+			includes = append(includes, plugin.formatLabel(e))
+		}
+	}
+	commands := []buildozerCommand{{command: fmt.Sprintf("new package_group %s", group.Name), target: plugin.formatLabel(pkg)}}
+	if len(packages) > 0 {
+		commands = append(commands, buildozerCommand{command: "add packages " + strings.Join(packages, " "), target: plugin.formatLabel(group)})
+	}
+	if len(includes) > 0 {
+		commands = append(commands, buildozerCommand{command: "add includes " + strings.Join(includes, " "), target: plugin.formatLabel(group)})
+	}
+	commands = append(commands, buildozerCommand{command: fmt.Sprintf("set visibility :%s", group.Name), target: fix.target})
+	fmt.Fprintf(os.Stdout, "Consider converting its visibility to a package_group instead, by running:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stdout, "buildozer '%s' %s\n", c.command, c.target)
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// isBzlFile returns whether the given label is the one of a .bzl file.
+func isBzlFile(l label.Label) bool {
+	return strings.HasSuffix(l.Name, ".bzl")
+}
+
+// owningBzlLibrary returns the label of the bzl_library target having the given
+// .bzl file in its srcs, in a package other than the one of the file, e.g. as
+// reached by the stardoc and bzl_library chains of the documentation. The
+// visibility of that target is then the one to fix, rather than the one of the
+// file. It returns the empty string if there's no such target, or if it's the
+// consumer itself, which then needs the file to be visible.
+func (plugin *FixVisibilityPlugin) owningBzlLibrary(bzlFile, consumer label.Label) (string, error) {
+	query := fmt.Sprintf("kind(bzl_library, rdeps(//..., %s, 1))", bzlFile)
+	output, err := plugin.bazel.run("query", "--output=label", query)
+	if err != nil {
+		// The query fails e.g. when no bzl_library rule is loaded in the
+		// workspace, in which case the file is fixed.
+		plugin.debug.printf("finding the bzl_library of %s failed: %v", bzlFile, err)
+		return "", nil
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		owner, err := label.Parse(strings.TrimSpace(line))
+		if err != nil {
+			continue
+		}
+		owner = owner.Abs(bzlFile.Repo, bzlFile.Pkg)
+		if owner.Pkg == bzlFile.Pkg || owner.Equal(consumer) {
+			continue
+		}
+		return plugin.formatLabel(owner), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// changelist accumulates the description of the applied fixes written to the
+// changelist_file property, in Markdown, e.g. for the description of a pull
+// request.
+type changelist struct {
+	// targets are the targets fixed, in the order they were first fixed.
+	targets []string
+	entries map[string][]string
+}
+
+// add adds the description of the given applied fix. The BUILD file is
+// linked relative to the directory of the changelist file at the given path.
+func (c *changelist) add(workspaceRoot, path string, fix *visibilityFix) {
+	if c.entries == nil {
+		c.entries = make(map[string][]string)
+	}
+	toFix := fix.node.toFix
+	if _, ok := c.entries[toFix]; !ok {
+		c.targets = append(c.targets, toFix)
+	}
+
+	var why strings.Builder
+	fmt.Fprintf(&why, "- Granted `%s` to `%s`, which depends on it", fix.grant, fix.node.from)
+	if len(fix.referencingAttrs) > 0 {
+		fmt.Fprintf(&why, " through its `%s` attribute(s)", strings.Join(fix.referencingAttrs, "`, `"))
+	}
+	if len(fix.node.topLevelTargets) > 0 {
+		fmt.Fprintf(&why, ", in the build of `%s`", strings.Join(fix.node.topLevelTargets, "`, `"))
+	}
+	fmt.Fprintf(&why, ".\n  The grant follows %s. Severity: %s, risk tier: %s.",
+		fix.policy, severityNames[fix.severity], fix.tier)
+	if fix.deprecation != "" {
+		fmt.Fprintf(&why, " The target is deprecated: %s", fix.deprecation)
+	}
+	buildFile := relativeBuildFile(workspaceRoot, fix.target)
+	link := filepath.Join(workspaceRoot, buildFile)
+	if rel, err := filepath.Rel(filepath.Dir(path), link); err == nil {
+		link = rel
+	}
+	fmt.Fprintf(&why, "\n  BUILD file: [%s](%s)", buildFile, filepath.ToSlash(link))
+	c.entries[toFix] = append(c.entries[toFix], why.String())
+}
+
+// write writes the changelist to the given path.
+func (c *changelist) write(path string) error {
+	var out strings.Builder
+	fmt.Fprintf(&out, "# Visibility fixes\n\n")
+	fmt.Fprintf(&out, "The visibility of the following targets was widened to fix the visibility errors of the build.\n")
+	for _, target := range c.targets {
+		fmt.Fprintf(&out, "\n## `%s`\n\n%s\n", target, strings.Join(c.entries[target], "\n"))
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to write the changelist file: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write the changelist file: %w", err)
+	}
+	return nil
+}
+
+// changelistPath returns the path of the changelist file, relative to the
+// workspace root unless absolute.
+func (plugin *FixVisibilityPlugin) changelistPath(workspaceRoot string) string {
+	path := plugin.properties.ChangelistFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceRoot, path)
+	}
+	return path
+}
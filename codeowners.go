@@ -0,0 +1,163 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// codeownersPaths are the locations of the CODEOWNERS file relative to the
+// workspace root, in the order they are looked up.
+var codeownersPaths = []string{"CODEOWNERS", ".github/CODEOWNERS", "docs/CODEOWNERS"}
+
+// The possible values for the codeowners_grant property.
+const (
+	// codeownersGrantSubpackages grants the root directory of the owning team
+	// and all its subpackages.
+	codeownersGrantSubpackages = "subpackages"
+	// codeownersGrantPackage grants only the root directory of the owning team.
+	codeownersGrantPackage = "package"
+)
+
+// codeownersRule is a rule of the CODEOWNERS file assigning a directory to
+// its owners.
+type codeownersRule struct {
+	// dir is the directory the rule applies to, relative to the workspace root.
+	dir string
+	// anchored is false when the rule applies to the directories with that name
+	// at any depth.
+	anchored bool
+	// owners are the users and teams owning the directory.
+	owners []string
+}
+
+// codeowners holds the directory rules of a CODEOWNERS file. The rules
+// matching files by name or glob are not team boundaries and are left out.
+type codeowners struct {
+	rules []codeownersRule
+}
+
+// loadCodeowners parses the CODEOWNERS file of the workspace. It returns an
+// empty set of rules if there's none.
+func loadCodeowners(workspaceRoot string) (*codeowners, error) {
+	owners := &codeowners{}
+	for _, path := range codeownersPaths {
+		data, err := os.ReadFile(filepath.Join(workspaceRoot, path))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CODEOWNERS: %w", err)
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+			if rule, ok := parseCodeownersPattern(fields[0]); ok {
+				rule.owners = fields[1:]
+				owners.rules = append(owners.rules, rule)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read CODEOWNERS: %w", err)
+		}
+		break
+	}
+	return owners, nil
+}
+
+// parseCodeownersPattern returns the directory rule of the given pattern, or
+// false if the pattern doesn't denote a directory, e.g. *.go or /docs/*.md.
+func parseCodeownersPattern(pattern string) (codeownersRule, bool) {
+	isDir := strings.HasSuffix(pattern, "/") ||
+		strings.HasSuffix(pattern, "/*") ||
+		strings.HasSuffix(pattern, "/**")
+	dir := strings.TrimSuffix(strings.TrimSuffix(pattern, "*"), "*")
+	dir = strings.TrimSuffix(dir, "/")
+	anchored := strings.Contains(dir, "/")
+	dir = strings.TrimPrefix(dir, "/")
+	if !isDir || dir == "" || strings.ContainsAny(dir, "*?[") {
+		return codeownersRule{}, false
+	}
+	return codeownersRule{dir: dir, anchored: anchored}, true
+}
+
+// teamRoot returns the root directory of the team owning the given package,
+// i.e. the directory of the last rule matching it, as the last matching rule
+// takes precedence in CODEOWNERS.
+func (owners *codeowners) teamRoot(pkg string) (string, bool) {
+	root, _, found := owners.match(pkg)
+	return root, found
+}
+
+// teamOwners returns the owners of the team owning the given package.
+func (owners *codeowners) teamOwners(pkg string) []string {
+	_, rule, _ := owners.match(pkg)
+	return rule.owners
+}
+
+// match returns the root directory of the last rule matching the given
+// package, along with the rule.
+func (owners *codeowners) match(pkg string) (string, codeownersRule, bool) {
+	root, matched, found := "", codeownersRule{}, false
+	for _, rule := range owners.rules {
+		if rule.anchored {
+			if pkg == rule.dir || strings.HasPrefix(pkg, rule.dir+"/") {
+				root, matched, found = rule.dir, rule, true
+			}
+			continue
+		}
+		components := strings.Split(pkg, "/")
+		for i, component := range components {
+			if component == rule.dir {
+				root, matched, found = strings.Join(components[:i+1], "/"), rule, true
+				break
+			}
+		}
+	}
+	return root, matched, found
+}
+
+// codeownersGrant returns the grant of the root directory of the team owning
+// the package of the given consumer, according to the codeowners_grant
+// property. It returns false if the consumer has no owning team.
+func (plugin *FixVisibilityPlugin) codeownersGrant(consumer label.Label) (label.Label, bool, error) {
+	// The CODEOWNERS file only describes the main repository.
+	if isExternal(consumer) {
+		return label.NoLabel, false, nil
+	}
+	if plugin.codeowners == nil {
+		workspaceRoot, err := plugin.workspaceRoot()
+		if err != nil {
+			return label.NoLabel, false, err
+		}
+		if plugin.codeowners, err = loadCodeowners(workspaceRoot); err != nil {
+			return label.NoLabel, false, err
+		}
+	}
+	root, ok := plugin.codeowners.teamRoot(consumer.Pkg)
+	if !ok {
+		return label.NoLabel, false, nil
+	}
+	grant := label.Label{Repo: consumer.Repo, Pkg: root, Name: "__subpackages__"}
+	if plugin.properties.CodeownersGrant == codeownersGrantPackage {
+		grant.Name = "__pkg__"
+	}
+	return grant, true, nil
+}
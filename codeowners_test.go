@@ -0,0 +1,82 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseCodeownersPattern(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		rule    codeownersRule
+		isDir   bool
+	}{
+		{"/apps/", codeownersRule{dir: "apps", anchored: true}, true},
+		{"/apps/web/*", codeownersRule{dir: "apps/web", anchored: true}, true},
+		{"apps/web/**", codeownersRule{dir: "apps/web", anchored: true}, true},
+		{"docs/", codeownersRule{dir: "docs", anchored: false}, true},
+		{"*.go", codeownersRule{}, false},
+		{"/docs/*.md", codeownersRule{}, false},
+		{"/apps", codeownersRule{}, false},
+		{"/", codeownersRule{}, false},
+		{"/apps/*/web/", codeownersRule{}, false},
+	} {
+		rule, ok := parseCodeownersPattern(test.pattern)
+		if ok != test.isDir || rule.dir != test.rule.dir || rule.anchored != test.rule.anchored {
+			t.Errorf("parseCodeownersPattern(%q) = %+v, %t, want %+v, %t", test.pattern, rule, ok, test.rule, test.isDir)
+		}
+	}
+}
+
+func TestCodeownersTeamRoot(t *testing.T) {
+	workspaceRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(workspaceRoot, ".github"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content := `# The teams of the repository.
+*.md @docs
+/apps/ @apps
+/apps/web/ @web
+proto/ @api
+/apps/web/legacy/** @legacy
+`
+	if err := os.WriteFile(filepath.Join(workspaceRoot, ".github/CODEOWNERS"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	owners, err := loadCodeowners(workspaceRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		pkg    string
+		root   string
+		owners []string
+	}{
+		{"apps", "apps", []string{"@apps"}},
+		{"apps/mobile/ui", "apps", []string{"@apps"}},
+		{"apps/web", "apps/web", []string{"@web"}},
+		{"apps/web/legacy/ui", "apps/web/legacy", []string{"@legacy"}},
+		{"apps/webkit", "apps", []string{"@apps"}},
+		{"services/proto/v1", "services/proto", []string{"@api"}},
+		{"libs/util", "", nil},
+	} {
+		root, _ := owners.teamRoot(test.pkg)
+		if root != test.root {
+			t.Errorf("teamRoot(%q) = %q, want %q", test.pkg, root, test.root)
+		}
+		if got := owners.teamOwners(test.pkg); !reflect.DeepEqual(got, test.owners) {
+			t.Errorf("teamOwners(%q) = %q, want %q", test.pkg, got, test.owners)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+)
+
+// The possible values for the color property.
+const (
+	// colorAuto disables colors when NO_COLOR is set, Bazel was passed
+	// --color=no or one of its other spellings, or the output is plain. This
+	// is the default.
+	colorAuto = "auto"
+	// colorAlways always uses colors.
+	colorAlways = "always"
+	// colorNever never uses colors.
+	colorNever = "never"
+)
+
+// plainPromptTemplates render the prompts without colors or icons.
+var plainPromptTemplates = &promptui.PromptTemplates{
+	Prompt:  "{{ . }}: ",
+	Confirm: "{{ . }} [y/N]: ",
+	Valid:   "{{ . }}: ",
+	Invalid: "{{ . }}: ",
+	Success: "{{ . }}: ",
+}
+
+// bazelColors are the spellings of the values of the --color flag of Bazel, a
+// tri-state option, by the value they stand for.
+var bazelColors = map[string]string{
+	"yes":   "yes",
+	"true":  "yes",
+	"1":     "yes",
+	"no":    "no",
+	"false": "no",
+	"0":     "no",
+	"auto":  "auto",
+}
+
+// bazelColor returns the value of the --color flag in the given Bazel command
+// line, among yes, no and auto, if any. The flag is spelled --color=<value>,
+// --color or --nocolor, and the last occurrence wins, like for Bazel.
+func bazelColor(cmdLine []string) string {
+	color := ""
+	for _, arg := range cmdLine {
+		switch {
+		case arg == "--nocolor":
+			color = "no"
+		case arg == "--color":
+			// Like the other tri-state options, --color never takes the
+			// next argument as its value.
+			color = "yes"
+		case strings.HasPrefix(arg, "--color="):
+			if value, ok := bazelColors[strings.TrimPrefix(arg, "--color=")]; ok {
+				color = value
+			}
+		}
+	}
+	return color
+}
+
+// colorEnabled returns whether the plugin output may be styled with colors,
+// according to the color property.
+func (plugin *FixVisibilityPlugin) colorEnabled() bool {
+	switch plugin.properties.Color {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	}
+	// NO_COLOR is also honored by the CLI itself, see https://no-color.org.
+	if os.Getenv("NO_COLOR") != "" || plugin.plainOutput() {
+		return false
+	}
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+	return plugin.bazelColor != "no"
+}
+
+// prompt returns the given prompt, rendered without colors when they are
+// disabled.
+func (plugin *FixVisibilityPlugin) prompt(prompt promptui.Prompt) promptui.Prompt {
+	if !plugin.colorEnabled() {
+		prompt.Templates = plainPromptTemplates
+	}
+	return prompt
+}
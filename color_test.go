@@ -0,0 +1,43 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBazelColor(t *testing.T) {
+	for _, test := range []struct {
+		cmdLine string
+		color   string
+	}{
+		{"build //...", ""},
+		{"build --color=no //...", "no"},
+		{"build --color=false //...", "no"},
+		{"build --color=0 //...", "no"},
+		{"build --color=yes //...", "yes"},
+		{"build --color=true //...", "yes"},
+		{"build --color=1 //...", "yes"},
+		{"build --color=auto //...", "auto"},
+		{"build --color no //...", "yes"},
+		{"build --color false //...", "yes"},
+		{"build --color //...", "yes"},
+		{"build //... --color", "yes"},
+		{"build --nocolor //...", "no"},
+		{"build --color=no --color //...", "yes"},
+		{"build --color=yes --nocolor //...", "no"},
+		{"build --color=yes --color=unknown //...", "yes"},
+	} {
+		if color := bazelColor(strings.Fields(test.cmdLine)); color != test.color {
+			t.Errorf("bazelColor(%q) = %q, want %q", test.cmdLine, color, test.color)
+		}
+	}
+}
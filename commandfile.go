@@ -0,0 +1,58 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// commandFile accumulates the commands printed for the user to run manually in
+// the batch format read by `buildozer -f`: one command per line, followed by
+// its targets, separated by '|'. Lines starting with '#' are ignored by
+// buildozer.
+type commandFile struct {
+	lines []string
+}
+
+// addCommands adds the given buildozer commands to the file.
+func (f *commandFile) addCommands(commands []buildozerCommand) {
+	for _, c := range commands {
+		f.lines = append(f.lines, fmt.Sprintf("%s|%s", c.command, c.target))
+	}
+}
+
+// addComment adds the given text as comment lines to the file.
+func (f *commandFile) addComment(text string) {
+	f.lines = append(f.lines, strings.Split(commentLines(text), "\n")...)
+}
+
+// write writes the file to the given path, relative to the workspace root
+// unless absolute, returning the path written.
+func (f *commandFile) write(workspaceRoot, path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceRoot, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to write the command file: %w", err)
+	}
+	data := strings.Join(f.lines, "\n") + "\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		return "", fmt.Errorf("failed to write the command file: %w", err)
+	}
+	return path, nil
+}
+
+// commentLines prefixes every line of the given text with '#'.
+func commentLines(text string) string {
+	return "# " + strings.ReplaceAll(text, "\n", "\n# ")
+}
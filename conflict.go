@@ -0,0 +1,130 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/buildtools/edit"
+)
+
+// buildFileHash returns the hash of the content of the given BUILD file, or
+// the empty string if it can't be read.
+func buildFileHash(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// detectionHash returns the hash of the BUILD file of the given target of the
+// main repository when the issue is detected, for the fix to find whether it
+// changed since. It returns the empty string for the targets of other
+// repositories, or when the workspace isn't known yet.
+func detectionHash(workspaceDir, toFix string) string {
+	l, err := label.Parse(toFix)
+	if err != nil || workspaceDir == "" || isExternal(l) {
+		return ""
+	}
+	path, _, _ := edit.InterpretLabelForWorkspaceLocation(workspaceDir, toFix)
+	return buildFileHash(path)
+}
+
+// recordWrites records the hash of the files written by the given commands
+// and edits of the plugin, so that the fixes of the same files don't mistake
+// them for outside changes.
+func (plugin *FixVisibilityPlugin) recordWrites(commands []buildozerCommand, edits []fileEdit) {
+	if plugin.written == nil {
+		plugin.written = map[string]string{}
+	}
+	for _, c := range commands {
+		path, _, _ := edit.InterpretLabelForWorkspaceLocation("", c.target)
+		plugin.written[path] = buildFileHash(path)
+	}
+	for _, e := range edits {
+		switch e := e.(type) {
+		case *visibilityRewrite:
+			plugin.written[e.path] = buildFileHash(e.path)
+		case *allowlistEdit:
+			plugin.written[e.path] = buildFileHash(e.path)
+		}
+	}
+}
+
+// reverifyFix checks, before applying the fix, whether the BUILD file of the
+// target being fixed changed since the issue was detected, e.g. because it was
+// edited or regenerated in the meantime, other than by the previous fixes of
+// the plugin. If so, it verifies that the rule still exists and still lacks
+// the grants, and computes again the edits of the file. It returns false if
+// the fix is obsolete.
+func (plugin *FixVisibilityPlugin) reverifyFix(fix *visibilityFix) (bool, error) {
+	path, _, _ := edit.InterpretLabelForWorkspaceLocation("", fix.node.toFix)
+	current := buildFileHash(path)
+	if fix.node.buildFileHash == "" || current == fix.node.buildFileHash {
+		return true, nil
+	}
+	if written, ok := plugin.written[path]; ok && current == written {
+		return true, nil
+	}
+	plugin.warnings.warnf("%s changed since the visibility issue of %s was detected, verifying the fix again\n", path, fix.node.toFix)
+
+	err := plugin.verifyTarget(fix.target)
+	var unfixable *unfixableError
+	if errors.As(err, &unfixable) {
+		plugin.warnings.warnf("Cannot fix the visibility of %s: %s\n", fix.node.toFix, unfixable)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	// The edits of the file were computed from its previous content, which
+	// they would overwrite. Those of the fixes combined are combined again.
+	if len(fix.fileEdits) > 0 {
+		var fixes []*visibilityFix
+		for _, f := range fix.constituents() {
+			refreshed, err := plugin.prepareFix(f.node)
+			if err != nil || refreshed == nil {
+				return false, err
+			}
+			fixes = append(fixes, refreshed)
+		}
+		merged, err := mergeFixes(fixes)
+		if err != nil {
+			return false, err
+		}
+		refreshed := merged[0]
+		fix.fileEdits, fix.commands, fix.cleanupCommands = refreshed.fileEdits, refreshed.commands, refreshed.cleanupCommands
+		return true, nil
+	}
+
+	visibility, err := plugin.currentVisibility(fix.target)
+	if err != nil {
+		return false, err
+	}
+	toFixLabel, err := label.Parse(fix.node.toFix)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range fix.constituents() {
+		if !alreadyGranted(visibility.entries, f.grant, toFixLabel) {
+			return true, nil
+		}
+	}
+	fmt.Fprintf(os.Stdout, "%s is already visible from %s, skipping the fix\n", fix.node.toFix, fix.consumersString())
+	return false, nil
+}
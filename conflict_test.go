@@ -0,0 +1,100 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+func TestReverifyFix(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{"WORKSPACE", "lib/BUILD.bazel"} {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("filegroup(name = \"a\")\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	buildFile := filepath.Join(root, "lib", "BUILD.bazel")
+	detected := buildFileHash(buildFile)
+	if detected == "" || detectionHash(root, "//lib:a") != detected {
+		t.Fatalf("detectionHash(//lib:a) = %q, want %q", detectionHash(root, "//lib:a"), detected)
+	}
+	if hash := detectionHash(root, "@other//lib:a"); hash != "" {
+		t.Errorf("detectionHash(@other//lib:a) = %q, want none", hash)
+	}
+	if err := os.WriteFile(buildFile, []byte("filegroup(name = \"a\", srcs = [\"a.txt\"])\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	grant := label.New("", "app", "__pkg__")
+	for _, test := range []struct {
+		name       string
+		hash       string
+		written    bool
+		buildozer  map[string]string
+		applicable bool
+	}{
+		{name: "unchanged", hash: buildFileHash(buildFile), applicable: true},
+		{name: "unknown", hash: "", applicable: true},
+		{name: "written by a previous fix", hash: detected, written: true, applicable: true},
+		{name: "deleted rule", hash: detected, applicable: false},
+		{
+			name: "still lacking the grant",
+			hash: detected,
+			buildozer: map[string]string{
+				"print label //lib:a":      "//lib:a\n",
+				"print visibility //lib:a": "[//visibility:private]\n",
+			},
+			applicable: true,
+		},
+		{
+			name: "already granted",
+			hash: detected,
+			buildozer: map[string]string{
+				"print label //lib:a":      "//lib:a\n",
+				"print visibility //lib:a": "[//app:__pkg__]\n",
+			},
+			applicable: false,
+		},
+	} {
+		plugin := &FixVisibilityPlugin{buildozer: &scriptedRunner{name: "buildozer", outputs: test.buildozer}}
+		if test.written {
+			plugin.written = map[string]string{buildFile: buildFileHash(buildFile)}
+		}
+		fix := &visibilityFix{
+			node:   &fixNode{toFix: "//lib:a", from: "//app:app", buildFileHash: test.hash},
+			target: "//lib:a",
+			grant:  grant,
+		}
+		var applicable bool
+		captureStdout(t, func() {
+			applicable, err = plugin.reverifyFix(fix)
+		})
+		if err != nil || applicable != test.applicable {
+			t.Errorf("%s: reverifyFix() = %t, %v, want %t", test.name, applicable, err, test.applicable)
+		}
+	}
+}
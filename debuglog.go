@@ -0,0 +1,118 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// debugLogFilename is the name of the debug log file in pluginDir.
+const debugLogFilename = "debug.log"
+
+// maxDebugLogSize is the size past which the debug log is rotated, keeping a
+// single previous log next to it.
+const maxDebugLogSize = 1 << 20
+
+// debugLog writes the diagnostics of the plugin to the debug log file when
+// the debug_log property is set. The file is opened on the first write, once
+// the workspace root is known. The methods of a nil debugLog do nothing. It
+// is safe for concurrent use.
+type debugLog struct {
+	mu            sync.Mutex
+	workspaceRoot func() (string, error)
+	logger        *log.Logger
+	file          *os.File
+	failed        bool
+}
+
+// printf writes a line to the debug log. Failing to open the log disables it,
+// since diagnostics must not get in the way of the fixes.
+func (l *debugLog) printf(format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.logger == nil && !l.failed {
+		if err := l.open(); err != nil {
+			l.failed = true
+			fmt.Fprintf(os.Stdout, "Could not open the debug log: %v\n", err)
+		}
+	}
+	if l.logger != nil {
+		l.logger.Printf(format, args...)
+	}
+}
+
+// open opens the debug log file for appending, rotating it first when it has
+// grown past maxDebugLogSize. It must be called with l.mu held.
+func (l *debugLog) open() error {
+	workspaceRoot, err := l.workspaceRoot()
+	if err != nil {
+		return err
+	}
+	path := pluginFilePath(workspaceRoot, debugLogFilename)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if info, err := os.Stat(path); err == nil && info.Size() >= maxDebugLogSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	l.file = file
+	l.logger = log.New(file, "", log.LstdFlags|log.Lmicroseconds)
+	return nil
+}
+
+// close closes the debug log file, if it was opened.
+func (l *debugLog) close() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+		l.logger = nil
+	}
+}
+
+// loggedRunner is a runner writing its invocations, their outputs and their
+// durations to the debug log.
+type loggedRunner struct {
+	name   string
+	runner runner
+	log    *debugLog
+}
+
+func (r *loggedRunner) run(args ...string) ([]byte, error) {
+	start := time.Now()
+	output, err := r.runner.run(args...)
+	r.log.printf("%s %s (%s)", r.name, strings.Join(args, " "), time.Since(start))
+	if len(output) > 0 {
+		r.log.printf("%s output:\n%s", r.name, output)
+	}
+	if err != nil {
+		r.log.printf("%s error: %v", r.name, err)
+	}
+	return output, err
+}
@@ -0,0 +1,61 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugLog(t *testing.T) {
+	workspaceRoot := t.TempDir()
+	path := pluginFilePath(workspaceRoot, debugLogFilename)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	// The log grown past its limit is rotated when opened.
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), maxDebugLogSize), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := &debugLog{workspaceRoot: func() (string, error) { return workspaceRoot, nil }}
+	r := &loggedRunner{name: "bazel", runner: &scriptedRunner{name: "bazel", outputs: map[string]string{"info": "release 6.0.0"}}, log: l}
+	if _, err := r.run("info"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.run("query"); err == nil {
+		t.Fatal("run() succeeded, want the failure of the unscripted invocation")
+	}
+	l.close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"bazel info (", "bazel output:\nrelease 6.0.0\n", "bazel query (", "bazel error: failed to run bazel"} {
+		if !strings.Contains(string(data), line) {
+			t.Errorf("the debug log doesn't contain %q:\n%s", line, data)
+		}
+	}
+	if bytes.Contains(data, []byte("xxx")) {
+		t.Errorf("the debug log was not rotated")
+	}
+	if info, err := os.Stat(path + ".1"); err != nil || info.Size() != maxDebugLogSize {
+		t.Errorf("the previous debug log was not kept: %v", err)
+	}
+
+	// A nil log does nothing.
+	var disabled *debugLog
+	disabled.printf("ignored")
+	disabled.close()
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// defaultVisibilityAttr is the attribute of package() holding the default
+// visibility of the targets of the package.
+const defaultVisibilityAttr = "default_visibility"
+
+// defaultVisibilityStrategy grants the consumer access through the default
+// visibility of the package of the target being fixed, declared by package()
+// or by a macro wrapping it, e.g. my_package(default_visibility = ...), as
+// configured by the package_macro and package_macro_attribute of the rule.
+type defaultVisibilityStrategy struct {
+	plugin *FixVisibilityPlugin
+	prefix string
+	// macro is the name of the macro wrapping package(), empty for
+	// package() itself, and attr the attribute of the macro holding the
+	// default visibility.
+	macro string
+	attr  string
+}
+
+func (s *defaultVisibilityStrategy) name() string {
+	return strategyDefaultVisibility
+}
+
+func (s *defaultVisibilityStrategy) computeEdits(v issue) (*strategyEdits, error) {
+	grant, policy, err := s.plugin.grantLabel(v.consumer)
+	if err != nil {
+		return nil, err
+	}
+	declaration := v.toFix
+	declaration.Name = "__pkg__"
+	if s.macro != "" {
+		// Buildozer selects the calls of the package by kind.
+		declaration.Name = "%" + s.macro
+	}
+	target, err := s.plugin.buildozerTarget(declaration)
+	if err != nil {
+		return nil, err
+	}
+	return &strategyEdits{
+		grant:         grant,
+		policy:        fmt.Sprintf("%s, in the default visibility of the package (the %s strategy of %s)", policy, strategyDefaultVisibility, s.prefix),
+		defaultTarget: target,
+		defaultAttr:   s.attr,
+		defaultPrefix: s.prefix,
+	}, nil
+}
+
+// prepareDefaultVisibilityFix sets the commands of the fix adding its grant to
+// the default visibility of the package, as computed by the
+// default_visibility strategy. It returns false when the package doesn't
+// declare its default visibility, i.e. has no package() call or no call of
+// the configured macro, in which case the default is private and the grant is
+// added to the target being fixed instead. A default visibility which isn't a
+// literal list, e.g. a constant loaded from a .bzl file, can't be edited, in
+// which case an unfixableError is returned.
+func (plugin *FixVisibilityPlugin) prepareDefaultVisibilityFix(fix *visibilityFix, edits *strategyEdits, toFix label.Label) (bool, error) {
+	output, err := plugin.buildozer.run("print "+edits.defaultAttr, edits.defaultTarget)
+	if err != nil {
+		plugin.debug.printf("no %s declared by %s: %v", edits.defaultAttr, edits.defaultTarget, err)
+		return false, nil
+	}
+	entries, literal := parseVisibilityList(output)
+	if !literal {
+		return false, &unfixableError{
+			reason: fmt.Sprintf("the %s of %s is not a literal list, which the %s strategy can't edit",
+				edits.defaultAttr, edits.defaultTarget, strategyDefaultVisibility),
+			rule: fmt.Sprintf("strategies: %s: %s", edits.defaultPrefix, strategyDefaultVisibility),
+			override: fmt.Sprintf("make the %s of %s a literal list, or apply another strategy to %s",
+				edits.defaultAttr, edits.defaultTarget, edits.defaultPrefix),
+		}
+	}
+	fix.target = edits.defaultTarget
+	fix.existing = entries
+	fix.commands = []buildozerCommand{{
+		command: fmt.Sprintf("add %s %s", edits.defaultAttr, plugin.formatLabel(fix.grant)),
+		target:  edits.defaultTarget,
+	}}
+	for _, entry := range entries {
+		if entry == privateVisibility {
+			fix.commands = append(fix.commands, buildozerCommand{
+				command: fmt.Sprintf("remove %s %s", edits.defaultAttr, privateVisibility),
+				target:  edits.defaultTarget,
+			})
+		}
+	}
+	if redundant := redundantVisibility(entries, fix.grant, toFix); len(redundant) > 0 {
+		fix.cleanupCommands = append(fix.cleanupCommands, buildozerCommand{
+			command: fmt.Sprintf("remove %s %s", edits.defaultAttr, strings.Join(redundant, " ")),
+			target:  edits.defaultTarget,
+		})
+	}
+	return true, nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"aspect.build/cli/pkg/ioutils"
+	"github.com/manifoldco/promptui"
+)
+
+// The possible values for the deprecated_targets property.
+const (
+	// deprecatedSuggest suggests migrating off the deprecated targets instead
+	// of offering the grant, which the user can still accept in interactive
+	// mode. This is the default.
+	deprecatedSuggest = "suggest"
+	// deprecatedWarn offers the grant as for any other target, along with the
+	// deprecation message.
+	deprecatedWarn = "warn"
+)
+
+// deprecation returns the deprecation message of the given target, empty
+// unless it has a deprecation attribute.
+func (plugin *FixVisibilityPlugin) deprecation(target string) (string, error) {
+	output, err := plugin.buildozer.run("print kind deprecation", target)
+	if err != nil {
+		return "", fmt.Errorf("failed to check if target is deprecated: %w", err)
+	}
+	_, message, _ := strings.Cut(strings.TrimSpace(string(output)), " ")
+	if message == "(missing)" {
+		return "", nil
+	}
+	if unquoted, err := strconv.Unquote(message); err == nil {
+		message = unquoted
+	}
+	return message, nil
+}
+
+// notifyDeprecation prints the deprecation message of the target being fixed,
+// suggesting to migrate off it unless the grant is offered regardless.
+func (plugin *FixVisibilityPlugin) notifyDeprecation(fix *visibilityFix) {
+	fmt.Fprintf(os.Stdout, "%s is deprecated: %s\n", fix.node.toFix, fix.deprecation)
+	if plugin.properties.DeprecatedTargets == deprecatedSuggest {
+		fmt.Fprintf(os.Stdout, "Consider migrating %s off %s rather than widening its visibility.\n",
+			fix.consumersString(), fix.node.toFix)
+	}
+}
+
+// overrideDeprecation asks the user whether to grant access to the deprecated
+// target being fixed regardless, still requiring the confirmation of its risk
+// tier.
+func (plugin *FixVisibilityPlugin) overrideDeprecation(fix *visibilityFix, promptRunner ioutils.PromptRunner) bool {
+	overridePrompt := plugin.prompt(promptui.Prompt{
+		Label:     strings.ReplaceAll(plugin.messages.DeprecatedPrompt, "{target}", fix.node.toFix),
+		IsConfirm: true,
+	})
+	if _, err := promptRunner.Run(overridePrompt); err != nil {
+		return false
+	}
+	switch plugin.confirmation(fix.tier) {
+	case confirmStrong:
+		return plugin.confirmStrongly(fix, promptRunner)
+	case confirmManual:
+		fmt.Fprintf(os.Stdout, "The %s fix of %s must be applied manually\n", fix.tier, fix.node.toFix)
+		return false
+	default:
+		return true
+	}
+}
+
+// sortDeprecatedLast moves the fixes of deprecated targets after the others,
+// keeping their order otherwise.
+func sortDeprecatedLast(fixes []*visibilityFix) {
+	sort.SliceStable(fixes, func(i, j int) bool {
+		return fixes[i].deprecation == "" && fixes[j].deprecation != ""
+	})
+}
@@ -0,0 +1,261 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"aspect.build/cli/bazel/buildeventstream"
+	"aspect.build/cli/pkg/plugin/sdk/v1alpha3/config"
+	aspectplugin "aspect.build/cli/pkg/plugin/sdk/v1alpha3/plugin"
+	goplugin "github.com/hashicorp/go-plugin"
+	"github.com/manifoldco/promptui"
+)
+
+// e2eScripts is the pattern of the end-to-end test scripts, each run by
+// TestE2E as a subtest named after its directory:
+//
+//	go test -run TestE2E/alias .
+const e2eScripts = "testdata/e2e/*/script.json"
+
+// e2eScript is a scripted invocation of the plugin: the build events the CLI
+// delivers, the answers the user gives to the prompts and the BUILD files
+// expected afterwards.
+type e2eScript struct {
+	// Workspace is the directory, relative to the script, copied to a
+	// temporary directory the build runs in.
+	Workspace string `json:"workspace"`
+	// Properties are the plugin properties, as YAML.
+	Properties  string `json:"properties"`
+	Interactive bool   `json:"interactive"`
+	// Events are delivered in order, including out-of-order and duplicate
+	// events when scripted so.
+	Events []e2eEvent `json:"events"`
+	// Answers are given to the prompts in order. Running out of answers
+	// aborts the prompt.
+	Answers []string `json:"answers"`
+	// Bazel maps the arguments of the bazel invocations, joined by spaces, to
+	// their output. The other invocations fail.
+	Bazel map[string]string `json:"bazel"`
+	// Expect maps the paths of files, relative to the workspace, to their
+	// expected content.
+	Expect map[string]string `json:"expect"`
+}
+
+// e2eEvent is a scripted build event.
+type e2eEvent struct {
+	// Started delivers a BuildStarted event with the given command. The
+	// workspace directory is that of the temporary workspace.
+	Started string `json:"started"`
+	// Target is the label of the top-level target the Aborted event refers to.
+	Target string `json:"target"`
+	// Aborted delivers an Aborted event of an analysis failure with the given
+	// description.
+	Aborted string `json:"aborted"`
+	// CmdLine delivers an OptionsParsed event with the given command line.
+	CmdLine []string `json:"cmd_line"`
+}
+
+// buildEvent returns the build event delivered for the scripted event.
+func (e e2eEvent) buildEvent(workspaceDir string) *buildeventstream.BuildEvent {
+	event := &buildeventstream.BuildEvent{Id: &buildeventstream.BuildEventId{}}
+	if e.Target != "" {
+		event.Id.Id = &buildeventstream.BuildEventId_TargetConfigured{
+			TargetConfigured: &buildeventstream.BuildEventId_TargetConfiguredId{Label: e.Target},
+		}
+	}
+	switch {
+	case e.Started != "":
+		event.Payload = &buildeventstream.BuildEvent_Started{Started: &buildeventstream.BuildStarted{
+			Uuid:               "e2e",
+			Command:            e.Started,
+			WorkspaceDirectory: workspaceDir,
+			WorkingDirectory:   workspaceDir,
+		}}
+	case e.Aborted != "":
+		event.Payload = &buildeventstream.BuildEvent_Aborted{Aborted: &buildeventstream.Aborted{
+			Reason:      buildeventstream.Aborted_ANALYSIS_FAILURE,
+			Description: e.Aborted,
+		}}
+	case len(e.CmdLine) > 0:
+		event.Payload = &buildeventstream.BuildEvent_OptionsParsed{OptionsParsed: &buildeventstream.OptionsParsed{
+			CmdLine: e.CmdLine,
+		}}
+	}
+	return event
+}
+
+// scriptedPromptRunner is a PromptRunner giving the scripted answers.
+type scriptedPromptRunner struct {
+	answers []string
+}
+
+func (r *scriptedPromptRunner) Run(prompt promptui.Prompt) (string, error) {
+	if len(r.answers) == 0 {
+		return "", promptui.ErrAbort
+	}
+	answer := r.answers[0]
+	r.answers = r.answers[1:]
+	fmt.Fprintf(os.Stdout, "%s: %s\n", prompt.Label, answer)
+	if prompt.IsConfirm && answer != "y" && answer != "yes" {
+		return answer, promptui.ErrAbort
+	}
+	if prompt.Validate != nil {
+		if err := prompt.Validate(answer); err != nil {
+			return "", err
+		}
+	}
+	return answer, nil
+}
+
+// scriptedRunner is a runner giving the scripted outputs.
+type scriptedRunner struct {
+	name    string
+	outputs map[string]string
+}
+
+func (r *scriptedRunner) run(args ...string) ([]byte, error) {
+	output, ok := r.outputs[strings.Join(args, " ")]
+	if !ok {
+		return nil, fmt.Errorf("failed to run %s: unscripted invocation %q", r.name, strings.Join(args, " "))
+	}
+	return []byte(output), nil
+}
+
+// TestE2E runs the end-to-end test scripts.
+func TestE2E(t *testing.T) {
+	scripts, err := filepath.Glob(e2eScripts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatalf("no script matches %s", e2eScripts)
+	}
+	for _, script := range scripts {
+		path, err := filepath.Abs(script)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Run(filepath.Base(filepath.Dir(script)), func(t *testing.T) {
+			if err := runE2EScript(t, path); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// runE2EScript runs the plugin as scripted by the given file, in a temporary
+// copy of the scripted workspace, and checks the resulting files. The plugin
+// is served over go-plugin, the way the CLI drives it. It returns an error
+// listing the mismatching files.
+func runE2EScript(t *testing.T, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the script: %w", err)
+	}
+	var script e2eScript
+	if err := json.Unmarshal(data, &script); err != nil {
+		return fmt.Errorf("failed to parse the script: %w", err)
+	}
+
+	workspaceDir, err := os.MkdirTemp("", "fix-visibility-e2e")
+	if err != nil {
+		return fmt.Errorf("failed to create the workspace: %w", err)
+	}
+	defer os.RemoveAll(workspaceDir)
+	if err := copyDir(filepath.Join(filepath.Dir(path), script.Workspace), workspaceDir); err != nil {
+		return fmt.Errorf("failed to create the workspace: %w", err)
+	}
+	// Buildozer resolves the labels against the current directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to enter the workspace: %w", err)
+	}
+	if err := os.Chdir(workspaceDir); err != nil {
+		return fmt.Errorf("failed to enter the workspace: %w", err)
+	}
+	defer os.Chdir(wd)
+
+	plugin := &FixVisibilityPlugin{
+		buildozer:    &buildozer{},
+		bazel:        &scriptedRunner{name: "bazel", outputs: script.Bazel},
+		targetsToFix: &fixOrderedSet{nodes: make(map[fixKey]*fixNode)},
+	}
+	client, server := goplugin.TestPluginGRPCConn(t, config.NewConfigFor(plugin).Plugins)
+	defer server.Stop()
+	defer client.Close()
+	raw, err := client.Dispense(config.DefaultPluginName)
+	if err != nil {
+		return fmt.Errorf("failed to serve the plugin: %w", err)
+	}
+	served := raw.(aspectplugin.Plugin)
+
+	if err := served.Setup(&aspectplugin.SetupConfig{Properties: []byte(script.Properties)}); err != nil {
+		return err
+	}
+	// The scripted prompt runner stands in for the one of the CLI, whatever
+	// the output is, and even when the scripts run in CI.
+	plugin.properties.Output = outputStyled
+	plugin.properties.Prompts = promptsCLI
+	for _, e := range script.Events {
+		if err := served.BEPEventCallback(e.buildEvent(workspaceDir)); err != nil {
+			return err
+		}
+	}
+	if err := served.PostBuildHook(script.Interactive, &scriptedPromptRunner{answers: script.Answers}); err != nil {
+		return err
+	}
+
+	var mismatches []string
+	for file, expected := range script.Expect {
+		actual, err := os.ReadFile(filepath.Join(workspaceDir, file))
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s: %v", file, err))
+			continue
+		}
+		if string(actual) != expected {
+			mismatches = append(mismatches, fmt.Sprintf("%s: expected:\n%s\nactual:\n%s", file, expected, actual))
+		}
+	}
+	sort.Strings(mismatches)
+	if len(mismatches) > 0 {
+		return fmt.Errorf("unexpected workspace files:\n%s", strings.Join(mismatches, "\n"))
+	}
+	return nil
+}
+
+// copyDir copies the files of the src directory to the dst directory.
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, 0644)
+	})
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"aspect.build/cli/pkg/ioutils"
+	"github.com/manifoldco/promptui"
+)
+
+// editorCommand returns the editor configured by the user, from VISUAL or
+// EDITOR, if any.
+func editorCommand() string {
+	if editor := os.Getenv("VISUAL"); editor != "" {
+		return editor
+	}
+	return os.Getenv("EDITOR")
+}
+
+// offerEditor offers to open the files edited by the applied fixes in the
+// editor of the user, for a review before committing them, when the
+// open_editor property is set. It only does so in interactive sessions on a
+// terminal, with an editor configured.
+func (plugin *FixVisibilityPlugin) offerEditor(workspaceRoot string, isInteractiveMode bool, promptRunner ioutils.PromptRunner) {
+	editor := editorCommand()
+	if !plugin.properties.OpenEditor || !isInteractiveMode || !outputIsTerminal() || editor == "" ||
+		len(plugin.edited.paths) == 0 {
+		return
+	}
+	label := strings.ReplaceAll(plugin.messages.EditorPrompt, "{count}", strconv.Itoa(len(plugin.edited.paths)))
+	prompt := plugin.prompt(promptui.Prompt{
+		Label:     strings.ReplaceAll(label, "{editor}", editor),
+		IsConfirm: true,
+	})
+	if _, err := promptRunner.Run(prompt); err != nil {
+		return
+	}
+
+	var files []string
+	for _, path := range plugin.edited.paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workspaceRoot, path)
+		}
+		files = append(files, path)
+	}
+	// The editor may come with arguments, e.g. "code --wait", so it's run by
+	// the shell.
+	cmd := exec.Command("sh", append([]string{"-c", editor + ` "$@"`, "sh"}, files...)...)
+	cmd.Dir = workspaceRoot
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		plugin.warnings.warnf("Could not open the edited files in %s: %v\n", editor, err)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import "testing"
+
+func TestEditorCommand(t *testing.T) {
+	for _, test := range []struct {
+		visual, editor string
+		command        string
+	}{
+		{"", "", ""},
+		{"", "vi", "vi"},
+		{"code --wait", "vi", "code --wait"},
+	} {
+		t.Setenv("VISUAL", test.visual)
+		t.Setenv("EDITOR", test.editor)
+		if command := editorCommand(); command != test.command {
+			t.Errorf("editorCommand() = %q with VISUAL=%q and EDITOR=%q, want %q", command, test.visual, test.editor, test.command)
+		}
+	}
+}
+
+func TestOfferEditor(t *testing.T) {
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", "true")
+	for _, test := range []struct {
+		name        string
+		openEditor  bool
+		interactive bool
+		term        string
+		edited      []string
+	}{
+		{"open_editor unset", false, true, "xterm", []string{"lib/BUILD.bazel"}},
+		{"non-interactive", true, false, "xterm", []string{"lib/BUILD.bazel"}},
+		{"not a terminal", true, true, "dumb", []string{"lib/BUILD.bazel"}},
+		{"nothing edited", true, true, "xterm", nil},
+	} {
+		t.Setenv("TERM", test.term)
+		plugin := &FixVisibilityPlugin{properties: &pluginProperties{OpenEditor: test.openEditor}}
+		plugin.edited.add(test.edited)
+		prompts := &scriptedPromptRunner{answers: []string{"y"}}
+		plugin.offerEditor(t.TempDir(), test.interactive, prompts)
+		if len(prompts.answers) == 0 {
+			t.Errorf("%s: offerEditor() prompted, want no prompt", test.name)
+		}
+	}
+}
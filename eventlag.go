@@ -0,0 +1,94 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// eventLag measures the time BEPEventCallback holds the delivery of the build
+// events, which the SDK delivers one at a time, waiting for each callback to
+// return.
+type eventLag struct {
+	mu     sync.Mutex
+	events int
+	// busy is the total time spent in the callback, and slowest the longest
+	// single callback.
+	busy    time.Duration
+	slowest time.Duration
+	// stalled is the time spent waiting on the full violations queue, and
+	// queuePeak the most violations queued at once.
+	stalled   time.Duration
+	queuePeak int
+}
+
+// eventLagStats is a snapshot of the eventLag.
+type eventLagStats struct {
+	events    int
+	busy      time.Duration
+	slowest   time.Duration
+	stalled   time.Duration
+	queuePeak int
+}
+
+// observe records a callback taking the given time.
+func (l *eventLag) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events++
+	l.busy += d
+	if d > l.slowest {
+		l.slowest = d
+	}
+}
+
+// observeQueue records the violations queued, and the time waited for the
+// queue to have room.
+func (l *eventLag) observeQueue(queued int, stalled time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stalled += stalled
+	if queued > l.queuePeak {
+		l.queuePeak = queued
+	}
+}
+
+// reset clears the lag measured so far.
+func (l *eventLag) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events, l.busy, l.slowest, l.stalled, l.queuePeak = 0, 0, 0, 0, 0
+}
+
+// stats returns a snapshot of the lag.
+func (l *eventLag) stats() eventLagStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return eventLagStats{
+		events:    l.events,
+		busy:      l.busy,
+		slowest:   l.slowest,
+		stalled:   l.stalled,
+		queuePeak: l.queuePeak,
+	}
+}
+
+// String describes the lag, as printed in the summary of the run.
+func (s eventLagStats) String() string {
+	summary := fmt.Sprintf("%d build event(s) held for %s, the slowest for %s",
+		s.events, s.busy.Round(time.Microsecond), s.slowest.Round(time.Microsecond))
+	if s.queuePeak > 0 {
+		summary += fmt.Sprintf(", %s waiting on the violations file, with up to %d violation(s) queued",
+			s.stalled.Round(time.Millisecond), s.queuePeak)
+	}
+	return summary
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventLag(t *testing.T) {
+	var lag eventLag
+	lag.observe(2 * time.Millisecond)
+	lag.observe(5 * time.Millisecond)
+	lag.observeQueue(3, 0)
+	lag.observeQueue(1, 1500*time.Microsecond)
+
+	want := eventLagStats{events: 2, busy: 7 * time.Millisecond, slowest: 5 * time.Millisecond, stalled: 1500 * time.Microsecond, queuePeak: 3}
+	stats := lag.stats()
+	if stats != want {
+		t.Errorf("stats() = %+v, want %+v", stats, want)
+	}
+	if summary, want := stats.String(), "2 build event(s) held for 7ms, the slowest for 5ms, 2ms waiting on the violations file, with up to 3 violation(s) queued"; summary != want {
+		t.Errorf("String() = %q, want %q", summary, want)
+	}
+
+	lag.reset()
+	if stats := lag.stats(); stats != (eventLagStats{}) {
+		t.Errorf("stats() after reset() = %+v, want none", stats)
+	}
+	if summary, want := lag.stats().String(), "0 build event(s) held for 0s, the slowest for 0s"; summary != want {
+		t.Errorf("String() without violations = %q, want %q", summary, want)
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/buildtools/build"
+)
+
+// visibilityDocsURL is the documentation of Bazel visibility.
+const visibilityDocsURL = "https://bazel.build/concepts/visibility"
+
+// explain prints why the visibility issue occurred and the dependency path
+// from the top-level targets being built to the broken edge, so that the user
+// understands what they are approving.
+func (plugin *FixVisibilityPlugin) explain(fix *visibilityFix) {
+	node := fix.node
+	if fix.definition != "" {
+		fmt.Fprintf(os.Stdout, "%s depends on %s through the implicit %s attribute of its %s rule, defined in %s.\n",
+			node.from, node.toFix, node.implicitAttr, node.ruleKind, fix.definition)
+		fmt.Fprintf(os.Stdout, "Implicit dependencies must be visible from the package of the rule definition, but the visibility attribute of %s doesn't include it.\n",
+			node.toFix)
+	} else {
+		fmt.Fprintf(os.Stdout, "%s depends on %s, but the visibility attribute of %s doesn't include the package of %s.\n",
+			node.from, node.toFix, node.toFix, node.from)
+	}
+	fmt.Fprintf(os.Stdout, "The fix grants %s access to %s.\n", fix.grant, node.toFix)
+	fmt.Fprintf(os.Stdout, "See %s for how visibility works.\n", visibilityDocsURL)
+
+	roots := node.topLevelTargets
+	if len(roots) == 0 {
+		roots = []string{node.from}
+	}
+	for _, root := range roots {
+		path, err := plugin.dependencyPath(root, node.toFix)
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "Could not find the dependency path from %s: %v\n", root, err)
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "Dependency path from %s:\n", root)
+		for i, l := range path {
+			fmt.Fprintf(os.Stdout, "  %s%s\n", strings.Repeat("  ", i), l)
+		}
+	}
+}
+
+// dependencyPath returns a dependency path from one target to another, as
+// found by `bazel query 'somepath(from, to)'`.
+func (plugin *FixVisibilityPlugin) dependencyPath(from, to string) ([]string, error) {
+	output, err := plugin.bazel.run("query", "--output=label", fmt.Sprintf("somepath(%s, %s)", from, to))
+	if err != nil {
+		return nil, err
+	}
+	var path []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			path = append(path, line)
+		}
+	}
+	// The order of the query output is the topological order of the graph,
+	// which is not the same direction across Bazel versions.
+	if len(path) > 0 && path[0] == to {
+		for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+			path[i], path[j] = path[j], path[i]
+		}
+	}
+	return path, nil
+}
+
+// referencingAttrs returns the attributes of the consumer rule referencing the
+// restricted target, as found in the rule definition from
+// `bazel query --output=build`.
+func (plugin *FixVisibilityPlugin) referencingAttrs(consumer, toFix label.Label) ([]string, error) {
+	output, err := plugin.bazel.run("query", "--output=build", consumer.String())
+	if err != nil {
+		return nil, err
+	}
+	file, err := build.ParseBuild("query", output)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the query output: %w", err)
+	}
+	rules := file.Rules("")
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("no rule found for %s", consumer)
+	}
+
+	var attrs []string
+	rule := rules[0]
+	for _, key := range rule.AttrKeys() {
+		if key == "name" || key == "visibility" {
+			continue
+		}
+		references := false
+		build.Walk(rule.Attr(key), func(x build.Expr, _ []build.Expr) {
+			str, ok := x.(*build.StringExpr)
+			if !ok || references {
+				return
+			}
+			if l, err := label.Parse(str.Value); err == nil {
+				references = l.Abs(consumer.Repo, consumer.Pkg).Equal(toFix)
+			}
+		})
+		if references {
+			attrs = append(attrs, key)
+		}
+	}
+	return attrs, nil
+}
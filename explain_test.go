@@ -0,0 +1,81 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+func TestDependencyPath(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		output string
+		path   []string
+	}{
+		{"from the root", "//app:app\n//app:lib\n//lib:a\n", []string{"//app:app", "//app:lib", "//lib:a"}},
+		{"from the dependency", "//lib:a\n//app:lib\n//app:app\n", []string{"//app:app", "//app:lib", "//lib:a"}},
+		{"blank lines", "\n//app:app\n\n//lib:a\n", []string{"//app:app", "//lib:a"}},
+		{"no path", "", nil},
+	} {
+		plugin := &FixVisibilityPlugin{bazel: &scriptedRunner{name: "bazel", outputs: map[string]string{
+			"query --output=label somepath(//app:app, //lib:a)": test.output,
+		}}}
+		path, err := plugin.dependencyPath("//app:app", "//lib:a")
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(path, test.path) {
+			t.Errorf("%s: dependencyPath() = %q, want %q", test.name, path, test.path)
+		}
+	}
+}
+
+func TestReferencingAttrs(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		output string
+		attrs  []string
+	}{
+		{
+			"absolute labels",
+			"go_binary(\n    name = \"app\",\n    srcs = [\"main.go\"],\n    deps = [\"//lib:a\", \"//lib:b\"],\n    data = [\"//lib:a\"],\n)\n",
+			[]string{"deps", "data"},
+		},
+		{
+			"select",
+			"go_binary(\n    name = \"app\",\n    deps = select({\"//conditions:default\": [\"//lib:a\"]}),\n)\n",
+			[]string{"deps"},
+		},
+		{
+			"shorthand",
+			"go_binary(\n    name = \"app\",\n    deps = [\"//lib\"],\n)\n",
+			nil,
+		},
+		{
+			"visibility",
+			"go_binary(\n    name = \"app\",\n    visibility = [\"//lib:a\"],\n)\n",
+			nil,
+		},
+	} {
+		plugin := &FixVisibilityPlugin{bazel: &scriptedRunner{name: "bazel", outputs: map[string]string{
+			"query --output=build //app": test.output,
+		}}}
+		attrs, err := plugin.referencingAttrs(label.New("", "app", "app"), label.New("", "lib", "a"))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(attrs, test.attrs) {
+			t.Errorf("%s: referencingAttrs() = %q, want %q", test.name, attrs, test.attrs)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/buildtools/wspace"
+)
+
+// localRepositoryPaths maps the apparent names of the external repositories
+// whose sources live on disk, as the labels of the main repository refer to
+// them, to their absolute path. They are declared with local_path_override in
+// the MODULE.bazel file or local_repository in the WORKSPACE file.
+type localRepositoryPaths map[string]string
+
+// findLocalRepositoryPaths parses the MODULE.bazel and WORKSPACE files found in
+// the workspace root, collecting the external repositories with a local path.
+// The local_path_override declarations name the module, which is visible
+// under the apparent name of its bazel_dep.
+func findLocalRepositoryPaths(workspaceRoot string) (localRepositoryPaths, error) {
+	rootModule, err := loadModuleFile(workspaceRoot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find local repositories: %w", err)
+	}
+	paths := make(localRepositoryPaths)
+	declarations := []struct {
+		filenames []string
+		parse     func(filename string, data []byte) (*build.File, error)
+		kind      string
+		nameAttr  string
+	}{
+		{[]string{"MODULE.bazel"}, build.ParseModule, "local_path_override", "module_name"},
+		{[]string{"WORKSPACE", "WORKSPACE.bazel"}, build.ParseWorkspace, "local_repository", "name"},
+	}
+	for _, declaration := range declarations {
+		for _, filename := range declaration.filenames {
+			path := filepath.Join(workspaceRoot, filename)
+			data, err := os.ReadFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to find local repositories: %w", err)
+			}
+			file, err := declaration.parse(path, data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find local repositories: %w", err)
+			}
+			for _, rule := range file.Rules(declaration.kind) {
+				name := rule.AttrString(declaration.nameAttr)
+				repoPath := rule.AttrString("path")
+				if name == "" || repoPath == "" {
+					continue
+				}
+				if apparent, ok := rootModule.apparentName(name); ok && declaration.kind == "local_path_override" {
+					name = apparent
+				}
+				if !filepath.IsAbs(repoPath) {
+					repoPath = filepath.Join(workspaceRoot, repoPath)
+				}
+				if _, exists := paths[name]; !exists {
+					paths[name] = repoPath
+				}
+			}
+		}
+	}
+	return paths, nil
+}
+
+// buildozerTarget returns the target to be passed to buildozer for the given
+// external label, pointing at the package directory under the local path of
+// the repository. It returns false if the repository is not local.
+func (paths localRepositoryPaths) buildozerTarget(l label.Label) (string, bool) {
+	repoPath, ok := paths[l.Repo]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("%s:%s", filepath.Join(repoPath, l.Pkg), l.Name), true
+}
+
+// isExternal returns whether the label refers to a target in an external
+// repository.
+func isExternal(l label.Label) bool {
+	return l.Repo != "" && l.Repo != "@"
+}
+
+// buildozerTarget returns the target to be passed to buildozer for the given
+// label. Labels in external repositories are only supported when the
+// repository has a local path, and labels in a workspace nested in the one
+// the build ran in are not supported, in which case an unfixableError is
+// returned.
+func (plugin *FixVisibilityPlugin) buildozerTarget(l label.Label) (string, error) {
+	workspaceRoot, err := plugin.workspaceRoot()
+	if err != nil {
+		return "", err
+	}
+
+	if !isExternal(l) {
+		if nested := nestedWorkspace(workspaceRoot, l.Pkg); nested != "" {
+			return "", &unfixableError{reason: fmt.Sprintf("its package belongs to the nested workspace at %s", nested)}
+		}
+		// Buildozer resolves labels against the workspace found from the current
+		// directory, which may not be the one the build ran in.
+		if cwdRoot, _ := wspace.FindWorkspaceRoot(""); cwdRoot != workspaceRoot {
+			return fmt.Sprintf("%s:%s", filepath.Join(workspaceRoot, l.Pkg), l.Name), nil
+		}
+		return plugin.formatLabel(l), nil
+	}
+
+	if plugin.localRepositories == nil {
+		localRepositories, err := findLocalRepositoryPaths(workspaceRoot)
+		if err != nil {
+			return "", err
+		}
+		plugin.localRepositories = localRepositories
+	}
+	target, ok := plugin.localRepositories.buildozerTarget(l)
+	if !ok {
+		return "", &unfixableError{reason: fmt.Sprintf("the BUILD files of the @%s repository are not on disk", l.Repo)}
+	}
+	return target, nil
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeModuleFile writes the given MODULE.bazel file in a new workspace,
+// returning its root.
+func writeModuleFile(t *testing.T, content string) string {
+	workspaceRoot := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workspaceRoot, "MODULE.bazel"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return workspaceRoot
+}
+
+const localModules = `bazel_dep(name = "rules_foo", version = "1.0", repo_name = "foo")
+bazel_dep(name = "rules_bar", version = "1.0")
+
+local_path_override(module_name = "rules_foo", path = "third_party/rules_foo")
+local_path_override(module_name = "rules_bar", path = "/src/rules_bar")
+`
+
+func TestLocalRepositoryPathsByApparentName(t *testing.T) {
+	workspaceRoot := writeModuleFile(t, localModules)
+	paths, err := findLocalRepositoryPaths(workspaceRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := localRepositoryPaths{
+		"foo":       filepath.Join(workspaceRoot, "third_party/rules_foo"),
+		"rules_bar": "/src/rules_bar",
+	}
+	if len(paths) != len(want) {
+		t.Errorf("findLocalRepositoryPaths() = %v, want %v", paths, want)
+	}
+	for name, path := range want {
+		if paths[name] != path {
+			t.Errorf("findLocalRepositoryPaths()[%q] = %q, want %q", name, paths[name], path)
+		}
+	}
+}
+
+func TestApparentLabel(t *testing.T) {
+	plugin := &FixVisibilityPlugin{workspaceDir: writeModuleFile(t, localModules)}
+	for _, test := range []struct {
+		label    string
+		apparent string
+	}{
+		{"@@rules_foo~//lib:lib", "@foo//lib:lib"},
+		{"@@rules_foo~1.0//lib:lib", "@foo//lib:lib"},
+		{"@@rules_foo+//lib:lib", "@foo//lib:lib"},
+		{"@@rules_bar~//lib:lib", "@rules_bar//lib:lib"},
+		{"@@//lib:lib", "@@//lib:lib"},
+		{"@foo//lib:lib", "@foo//lib:lib"},
+		{"//lib:lib", "//lib:lib"},
+		{"@@rules_foo~~ext~repo//lib:lib", "@@rules_foo~~ext~repo//lib:lib"},
+		{"@@rules_foo++ext+repo//lib:lib", "@@rules_foo++ext+repo//lib:lib"},
+		{"@@rules_baz~//lib:lib", "@@rules_baz~//lib:lib"},
+	} {
+		if apparent := plugin.apparentLabel(test.label); apparent != test.apparent {
+			t.Errorf("apparentLabel(%q) = %q, want %q", test.label, apparent, test.apparent)
+		}
+	}
+}
@@ -0,0 +1,633 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"aspect.build/cli/pkg/ioutils"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/buildtools/build"
+	"github.com/manifoldco/promptui"
+)
+
+// visibilityFix holds the edits computed to fix a single visibility issue.
+type visibilityFix struct {
+	node *fixNode
+	// target is the target being fixed as passed to buildozer.
+	target string
+	// grant is the visibility entry being added to the target being fixed.
+	grant label.Label
+	// commands are the buildozer commands performing the fix.
+	commands []buildozerCommand
+	// fileEdits are applied before the buildozer commands, performing the
+	// edits buildozer can't, e.g. when the visibility attribute of the target
+	// being fixed is not a literal list.
+	fileEdits []fileEdit
+	// cleanupCommands are the buildozer commands removing the visibility
+	// entries made redundant by the fix.
+	cleanupCommands []buildozerCommand
+	// referencingAttrs are the attributes of the consumer referencing the
+	// target being fixed, when the show_dependency_attrs property is set.
+	referencingAttrs []string
+	// tier is the risk tier of the fix, by how far the grant crosses the tree.
+	tier string
+	// severity is the severity of the fix, by how wide the grant is.
+	severity int
+	// merged are the fixes of the same target combined into this one, which
+	// then performs all of them.
+	merged []*visibilityFix
+	// reviewed is set when the fix was reviewed along with the others, in
+	// which case accepted tells whether the user selected it.
+	reviewed bool
+	accepted bool
+	// deprecation is the deprecation message of the target being fixed, if
+	// any.
+	deprecation string
+	// policy describes the policy which produced the grant.
+	policy string
+	// strategy is the name of the strategy which computed the fix.
+	strategy string
+	// existing are the entries of the visibility attribute of the target
+	// being fixed before the fix, when it's a literal list.
+	existing []string
+	// definition is the .bzl file defining the rule of the consumer, whose
+	// package is granted access when the consumer depends on the target being
+	// fixed through an implicit attribute.
+	definition string
+}
+
+// skipUnfixable reports that the issue represented by the given node can't
+// be fixed, quarantining it when the configuration blocks the fix.
+func (plugin *FixVisibilityPlugin) skipUnfixable(node *fixNode, unfixable *unfixableError) {
+	plugin.warnings.warnf("Cannot fix the visibility of %s: %s\n", node.toFix, unfixable)
+	plugin.metrics.countUnfixable()
+	if unfixable.rule != "" {
+		plugin.quarantineIssue(node, unfixable.rule, unfixable.override)
+	}
+	plugin.resolveIssue(node, historyUnfixed)
+}
+
+// prepareFix computes the edits fixing the visibility issue represented by the
+// given node. It returns nil if the issue can't be fixed.
+func (plugin *FixVisibilityPlugin) prepareFix(node *fixNode) (*visibilityFix, error) {
+	consumerLabel, err := label.Parse(node.from)
+	if err != nil {
+		return nil, err
+	}
+	toFixLabel, err := label.Parse(node.toFix)
+	if err != nil {
+		return nil, err
+	}
+
+	// The issues reported by the toolchain resolution are fixed on the
+	// toolchain() rule wrapping the implementation named by the error.
+	if node.resolution == resolutionToolchain {
+		wrapper, err := plugin.toolchainWrapper(toFixLabel, consumerLabel)
+		var unfixable *unfixableError
+		if err != nil && !errors.As(err, &unfixable) {
+			return nil, err
+		}
+		if wrapper != "" {
+			fmt.Fprintf(os.Stdout, "%s is a toolchain implementation, the visibility of its toolchain() rule %s is fixed instead\n", node.toFix, wrapper)
+			retargeted := *node
+			retargeted.toFix = wrapper
+			retargeted.origins = node.edges()
+			node = &retargeted
+			if toFixLabel, err = label.Parse(wrapper); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The .bzl files reached through the documentation chains are fixed on
+	// the bzl_library owning them in another package.
+	if isBzlFile(toFixLabel) {
+		owner, err := plugin.owningBzlLibrary(toFixLabel, consumerLabel)
+		if err != nil {
+			return nil, err
+		}
+		if owner != "" {
+			fmt.Fprintf(os.Stdout, "%s belongs to the bzl_library %s, whose visibility is fixed instead\n", node.toFix, owner)
+			// The BUILD file of the owner wasn't hashed at detection time.
+			retargeted := *node
+			retargeted.toFix = owner
+			retargeted.origins = node.edges()
+			retargeted.buildFileHash = ""
+			node = &retargeted
+			if toFixLabel, err = label.Parse(owner); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// The implicit dependencies of a rule must be visible from the package
+	// defining the rule, which is then the consumer the grant is computed for.
+	user := consumerLabel
+	definition, err := plugin.implicitDefinition(node, consumerLabel)
+	if err != nil {
+		return nil, err
+	}
+	if definition != nil {
+		fmt.Fprintf(os.Stdout, "%s is an implicit dependency of the %s attribute of the %s rule, the package of its definition %s is granted access instead\n",
+			node.toFix, node.implicitAttr, node.ruleKind, definition)
+		consumerLabel = *definition
+	}
+
+	// We construct the label for the target we want to add to the target being
+	// fixed, with the strategy configured for it.
+	s := plugin.strategyFor(toFixLabel)
+	edits, err := s.computeEdits(issue{toFix: toFixLabel, consumer: consumerLabel})
+	var unfixable *unfixableError
+	if errors.As(err, &unfixable) {
+		plugin.skipUnfixable(node, unfixable)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fromLabel := edits.grant
+
+	// Labels of external repositories can't be handed to buildozer as is. When
+	// the repository sources live on disk, buildozer is pointed at the package
+	// directory under its local path, otherwise the target can't be fixed. Nor
+	// can targets in a workspace nested in the one the build ran in, whose
+	// rule buildozer doesn't find, or whose BUILD file is generated.
+	target, err := plugin.buildozerTarget(toFixLabel)
+	if err == nil {
+		err = plugin.verifyTarget(target)
+	}
+	if err == nil {
+		var workspaceRoot string
+		if workspaceRoot, err = plugin.workspaceRoot(); err == nil {
+			err = plugin.checkEditable(workspaceRoot, target)
+		}
+	}
+	if errors.As(err, &unfixable) {
+		plugin.skipUnfixable(node, unfixable)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The grant must then use the name of the consumer repository as visible
+	// from the repository of the target being fixed, which differs from the one
+	// in the main repository under Bzlmod repository mapping. Public
+	// visibility has no repository.
+	if !isPublic(fromLabel) {
+		fromLabel.Repo, err = plugin.grantRepo(fromLabel.Repo, toFixLabel.Repo)
+	}
+	if errors.As(err, &unfixable) {
+		plugin.skipUnfixable(node, unfixable)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// We need to verify if the target being fixed contains //visibility:private,
+	// otherwise Bazel will yell at us since we will need to remove it to add
+	// any package to the visibility attribute.
+	visibility, err := plugin.currentVisibility(target)
+	if err != nil {
+		return nil, err
+	}
+	hasPrivateVisibility := false
+	for _, entry := range visibility.entries {
+		if entry == privateVisibility {
+			hasPrivateVisibility = true
+		}
+	}
+
+	// The issue may have been fixed since it was detected, e.g. when handed off
+	// from a previous invocation.
+	if alreadyGranted(visibility.entries, fromLabel, toFixLabel) {
+		plugin.resolveIssue(node, fixStatusNames[fixObsolete])
+		return nil, nil
+	}
+
+	fix := &visibilityFix{
+		node:     node,
+		target:   target,
+		grant:    fromLabel,
+		tier:     riskTierOf(fromLabel, toFixLabel),
+		policy:   edits.policy,
+		strategy: s.name(),
+		existing: visibility.entries,
+	}
+	if definition != nil {
+		fix.definition = definition.String()
+	}
+	if fix.severity, err = plugin.severityOf(fix.grant, toFixLabel); err != nil {
+		return nil, err
+	}
+	if fix.deprecation, err = plugin.deprecation(target); err != nil {
+		return nil, err
+	}
+
+	// Listing the attributes through which the consumer depends on the target
+	// being fixed helps reviewers judge whether the dependency is appropriate.
+	if plugin.properties.ShowDependencyAttrs {
+		if fix.referencingAttrs, err = plugin.referencingAttrs(user, toFixLabel); err != nil {
+			plugin.warnings.warnf("Could not find how %s depends on %s: %v\n", node.from, node.toFix, err)
+		}
+	}
+
+	// The default_visibility strategy edits the default visibility of the
+	// package, which only applies to the targets without a visibility of
+	// their own.
+	if edits.defaultTarget != "" && visibility.missing() {
+		prepared, err := plugin.prepareDefaultVisibilityFix(fix, edits, toFixLabel)
+		if errors.As(err, &unfixable) {
+			plugin.skipUnfixable(node, unfixable)
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if prepared {
+			fix.commands = append(fix.commands, edits.commands...)
+			return fix, nil
+		}
+	}
+
+	// The consumer being a test gets special treatment depending on the
+	// test_consumers property, under the consumer strategy: either the
+	// widening is flagged, or the grant is scoped to a tests-only
+	// package_group instead of the consumer package.
+	extraCommands := edits.commands
+	if s.name() == strategyConsumer && plugin.properties.TestConsumers != testConsumersPackage {
+		// The consumers folded into the issue are in the package being
+		// granted, which is only scoped to the tests if they are all tests.
+		isTest, err := plugin.isTestTarget(user)
+		if err != nil {
+			return nil, err
+		}
+		for _, folded := range node.folded {
+			if !isTest {
+				break
+			}
+			l, err := label.Parse(folded)
+			if err != nil {
+				return nil, err
+			}
+			if isTest, err = plugin.isTestTarget(l); err != nil {
+				return nil, err
+			}
+		}
+		if isTest {
+			switch plugin.properties.TestConsumers {
+			case testConsumersWarn:
+				plugin.warnings.warnf("Note: the visibility of %s is being widened because of the test target %s\n", node.toFix, node.from)
+			case testConsumersPackageGroup:
+				// The property was validated during Setup.
+				group, _ := label.Parse(plugin.properties.TestPackageGroup)
+				testEdits, err := (&packageGroupStrategy{
+					plugin: plugin,
+					group:  group,
+					policy: fmt.Sprintf("the tests-only package_group, for the test consumer (test_consumers: %s)", testConsumersPackageGroup),
+				}).computeEdits(issue{toFix: toFixLabel, consumer: consumerLabel})
+				if err != nil {
+					return nil, err
+				}
+				fix.grant, fix.policy = testEdits.grant, testEdits.policy
+				fix.strategy = strategyPackageGroup
+				extraCommands = append(extraCommands, testEdits.commands...)
+			}
+		}
+	}
+
+	// When the grants are kept in per-package allowlist files, the allowlist of
+	// the target is edited instead of its BUILD rule.
+	if plugin.properties.AllowlistFile != "" {
+		variable := allowlistVariable(toFixLabel.Name)
+		if visibility.literal || visibility.value == variable {
+			if err := plugin.prepareAllowlistFix(fix, visibility, variable); err != nil {
+				return nil, err
+			}
+			fix.policy += fmt.Sprintf(", kept in the %s allowlist file", plugin.properties.AllowlistFile)
+			fix.commands = append(fix.commands, extraCommands...)
+			return fix, nil
+		}
+	}
+
+	// The naive buildozer commands would mangle a visibility attribute that is
+	// not a literal list, such as a select() or a concatenation, so the BUILD
+	// file is edited through its syntax tree instead.
+	if !visibility.literal {
+		grant := plugin.formatLabel(fix.grant)
+		rewrite, err := loadVisibilityRewrite(node.toFix, target, func(expr build.Expr) build.Expr {
+			return appendVisibilityEntry(expr, grant)
+		})
+		if err != nil {
+			return nil, err
+		}
+		fix.fileEdits = append(fix.fileEdits, rewrite)
+		fix.commands = extraCommands
+		return fix, nil
+	}
+
+	fix.commands = []buildozerCommand{{
+		command: fmt.Sprintf("add visibility %s", plugin.formatLabel(fix.grant)),
+		target:  target,
+	}}
+	if hasPrivateVisibility {
+		fix.commands = append(fix.commands, buildozerCommand{
+			command: removePrivateVisibilityBuildozerCommand,
+			target:  target,
+		})
+	}
+	fix.commands = append(fix.commands, extraCommands...)
+
+	// Adding the grant may leave existing entries of the visibility list
+	// redundant, e.g. an exact __pkg__ covered by the __subpackages__ being
+	// added, which we offer to remove to keep the attribute minimal.
+	if redundant := redundantVisibility(visibility.entries, fix.grant, toFixLabel); len(redundant) > 0 {
+		fix.cleanupCommands = append(fix.cleanupCommands, buildozerCommand{
+			command: fmt.Sprintf("remove visibility %s", strings.Join(redundant, " ")),
+			target:  target,
+		})
+	}
+
+	return fix, nil
+}
+
+// fixStatus is the outcome of resolving a fix.
+type fixStatus int
+
+const (
+	// fixPrinted means the commands were printed for the user to perform the
+	// fix manually, without asking.
+	fixPrinted fixStatus = iota
+	// fixApplied means the fix was applied.
+	fixApplied
+	// fixDeclined means the user declined applying the fix when prompted.
+	fixDeclined
+	// fixObsolete means the fix was no longer needed when about to be
+	// applied, e.g. because its BUILD file was edited in the meantime.
+	fixObsolete
+)
+
+// resolveFix either applies the fix, after the user accepts it when running in
+// interactive mode, or prints the commands for the user to perform the fix
+// manually.
+func (plugin *FixVisibilityPlugin) resolveFix(
+	fix *visibilityFix,
+	isInteractiveMode bool,
+	promptRunner ioutils.PromptRunner,
+) (fixStatus, error) {
+	fmt.Fprintf(os.Stdout, "%s is not visible from %s (severity: %s)\n", fix.node.toFix, fix.consumersString(), severityNames[fix.severity])
+	if len(fix.referencingAttrs) > 0 {
+		fmt.Fprintf(os.Stdout, "%s depends on %s through its %s attribute(s)\n", fix.node.from, fix.node.toFix, strings.Join(fix.referencingAttrs, ", "))
+	}
+	if fix.node.resolution != "" {
+		fmt.Fprintf(os.Stdout, "The issue was reported by the %s resolution\n", fix.node.resolution)
+	}
+	plugin.printImpact(fix)
+	plugin.suggestRefactor(fix)
+	if fix.deprecation != "" {
+		plugin.notifyDeprecation(fix)
+	}
+	if isInteractiveMode {
+		for _, e := range fix.fileEdits {
+			fmt.Fprintf(os.Stdout, "The fix will %s\n", e)
+		}
+	}
+
+	// We check whether it's running in interactive mode, if so, send a request
+	// to prompt the user using the promptRunner.
+	var applyFix, prompted bool
+	switch {
+	case plugin.exceedsBreadth(fix):
+		// Past the max_visibility_entries, the fix must be applied manually.
+	case fix.reviewed && !fix.accepted:
+		// The fixes left out in the review are declined.
+		prompted = true
+	case plugin.skipRemaining && isInteractiveMode:
+		prompted = true
+	case fix.deprecation != "" && plugin.properties.DeprecatedTargets == deprecatedSuggest:
+		// The grant on a deprecated target is only applied when the user
+		// overrides the suggestion to migrate off it, as set by the
+		// deprecated_targets property.
+		if isInteractiveMode {
+			applyFix, prompted = plugin.overrideDeprecation(fix, promptRunner), true
+		} else if plugin.autoApply {
+			plugin.quarantine(fix, fmt.Sprintf("deprecated_targets: %s", deprecatedSuggest),
+				fmt.Sprintf("migrate off the deprecated target, override the suggestion when prompted, or set deprecated_targets to %s", deprecatedWarn))
+		}
+	case isInteractiveMode || plugin.autoApply:
+		// The confirmation required depends on the risk tier of the fix, as
+		// set by the risk_confirmation property.
+		switch plugin.confirmation(fix.tier) {
+		case confirmAuto:
+			fmt.Fprintf(os.Stdout, "Applying the %s fix of %s automatically\n", fix.tier, fix.node.toFix)
+			applyFix = true
+		case confirmStrong:
+			applyFix, prompted = plugin.confirmStrongly(fix, promptRunner), true
+		case confirmManual:
+			fmt.Fprintf(os.Stdout, "The %s fix of %s must be applied manually\n", fix.tier, fix.node.toFix)
+			plugin.quarantine(fix, fmt.Sprintf("risk_confirmation: %s: %s", fix.tier, confirmManual),
+				fmt.Sprintf("apply it manually, or set the %s tier of risk_confirmation to %s or %s", fix.tier, confirmPrompt, confirmStrong))
+		default:
+			// The fixes selected in the review are still confirmed as set
+			// for their risk tier, and the grant on a deprecated target
+			// overridden, but not prompted for again.
+			if fix.reviewed {
+				applyFix, prompted = true, true
+			} else if plugin.applyRemaining {
+				fmt.Fprintf(os.Stdout, "Applying the fix of %s, as answered for all the remaining fixes\n", fix.node.toFix)
+				applyFix = true
+			} else {
+				applyFix, prompted = plugin.promptFix(fix, promptRunner), true
+			}
+		}
+	}
+
+	// The pre_fix_commands may prepare the files for the fix, or veto it by
+	// failing, in which case its commands are printed instead.
+	if applyFix && len(plugin.properties.PreFixCommands) > 0 {
+		workspaceRoot, err := plugin.workspaceRoot()
+		if err != nil {
+			return fixPrinted, fmt.Errorf("failed to fix visibility: %w", err)
+		}
+		if err := runFixCommands(plugin.properties.PreFixCommands, workspaceRoot, filesOf(workspaceRoot, fix)); err != nil {
+			plugin.warnings.warnf("Not applying the fix of %s: %v\n", fix.node.toFix, err)
+			plugin.quarantine(fix, "pre_fix_commands", "fix the failure of the commands, or remove them from pre_fix_commands")
+			applyFix = false
+		}
+	}
+
+	// Here we either perform the fix automatically, or print the commands for
+	// the user to perform the fixes manually. The commands run are recorded
+	// in the audit trail.
+	var runs []commandRun
+	if applyFix {
+		current, err := plugin.reverifyFix(fix)
+		if err != nil {
+			return fixPrinted, fmt.Errorf("failed to fix visibility: %w", err)
+		}
+		if !current {
+			return fixObsolete, nil
+		}
+		// A fix failing partway is recorded along with what it applied.
+		var applied []fileEdit
+		for _, e := range fix.fileEdits {
+			if err := e.apply(); err != nil {
+				plugin.auditFix(fix, applied, nil, err)
+				return fixPrinted, fmt.Errorf("failed to fix visibility: %w", err)
+			}
+			applied = append(applied, e)
+		}
+		if runs, err = plugin.runCommands(fix.commands); err != nil {
+			plugin.auditFix(fix, applied, runs, err)
+			return fixPrinted, fmt.Errorf("failed to fix visibility: %w", err)
+		}
+		plugin.recordWrites(fix.commands, fix.fileEdits)
+		if workspaceRoot, err := plugin.workspaceRoot(); err == nil {
+			plugin.edited.add(filesOf(workspaceRoot, fix))
+		}
+	} else {
+		fmt.Fprintf(plugin.commandOutput(), "%s\n", plugin.messages.FixCommands)
+		for _, e := range fix.fileEdits {
+			fmt.Fprintf(plugin.commandOutput(), "%s\n", commentLines(e.String()))
+			if plugin.commandFile != nil {
+				plugin.commandFile.addComment(e.String())
+			}
+		}
+		plugin.printCommands(fix.commands)
+	}
+
+	// The cleanup of redundant entries is offered separately, so that the
+	// user can accept the fix while keeping the visibility list untouched.
+	if len(fix.cleanupCommands) > 0 {
+		var applyCleanup bool
+		if applyFix && isInteractiveMode && !fix.reviewed && !plugin.applyRemaining {
+			cleanupPrompt := plugin.prompt(promptui.Prompt{
+				Label:     plugin.messages.CleanupPrompt,
+				IsConfirm: true,
+			})
+			_, err := promptRunner.Run(cleanupPrompt)
+			applyCleanup = err == nil
+		}
+		if applyCleanup {
+			cleanupRuns, err := plugin.runCommands(fix.cleanupCommands)
+			runs = append(runs, cleanupRuns...)
+			if err != nil {
+				plugin.auditFix(fix, fix.fileEdits, runs, err)
+				return fixApplied, fmt.Errorf("failed to remove redundant visibility: %w", err)
+			}
+			plugin.recordWrites(fix.cleanupCommands, nil)
+		} else {
+			fmt.Fprintf(plugin.commandOutput(), "%s\n", plugin.messages.CleanupCommands)
+			plugin.printCommands(fix.cleanupCommands)
+		}
+	}
+
+	if applyFix {
+		plugin.auditFix(fix, fix.fileEdits, runs, nil)
+	}
+
+	switch {
+	case applyFix:
+		return fixApplied, nil
+	case prompted:
+		return fixDeclined, nil
+	default:
+		return fixPrinted, nil
+	}
+}
+
+// promptFix asks the user whether to apply the fix. Besides yes and no, the
+// user can ask for an explanation of the issue before answering, or answer for
+// all the remaining fixes at once.
+func (plugin *FixVisibilityPlugin) promptFix(fix *visibilityFix, promptRunner ioutils.PromptRunner) bool {
+	m := plugin.messages
+	var answers []string
+	for _, a := range [][]string{m.Yes, m.No, m.Explain, m.All, m.Quit} {
+		answers = append(answers, a...)
+	}
+	label := m.FixPrompt
+	if len(fix.merged) > 0 {
+		label = strings.NewReplacer("{count}", fmt.Sprint(len(fix.merged)), "{target}", fix.node.toFix).Replace(m.MergedFixPrompt)
+	}
+	applyFixPrompt := plugin.prompt(promptui.Prompt{
+		Label:    label,
+		Validate: validateAnswer(answers...),
+	})
+	for {
+		answer, err := promptRunner.Run(applyFixPrompt)
+		// Any non-nil error, such as the user aborting the prompt, represents a NO.
+		if err != nil {
+			return false
+		}
+		switch {
+		case isAnswer(answer, m.Yes):
+			return true
+		case isAnswer(answer, m.All):
+			plugin.applyRemaining = true
+			return true
+		case isAnswer(answer, m.Quit):
+			plugin.skipRemaining = true
+			return false
+		case isAnswer(answer, m.Explain):
+			for _, f := range fix.constituents() {
+				plugin.explain(f)
+			}
+		default:
+			return false
+		}
+	}
+}
+
+// validateAnswer returns a prompt validation function accepting an empty
+// answer, for the default, or any of the given answers.
+func validateAnswer(answers ...string) promptui.ValidateFunc {
+	return func(input string) error {
+		input = strings.ToLower(strings.TrimSpace(input))
+		if input == "" {
+			return nil
+		}
+		for _, answer := range answers {
+			if input == answer {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid answer %q", input)
+	}
+}
+
+// runCommands runs the given buildozer commands in order, returning them
+// with their outputs.
+func (plugin *FixVisibilityPlugin) runCommands(commands []buildozerCommand) ([]commandRun, error) {
+	var runs []commandRun
+	for _, c := range commands {
+		output, err := plugin.buildozer.run(c.command, c.target)
+		if err != nil {
+			return runs, err
+		}
+		runs = append(runs, commandRun{Command: c.command, Target: c.target, Output: string(output)})
+	}
+	return runs, nil
+}
+
+// printCommands prints the given buildozer commands for the user to run them
+// manually, also adding them to the command file when one is configured. In
+// table format, they are printed after the table.
+func (plugin *FixVisibilityPlugin) printCommands(commands []buildozerCommand) {
+	for _, c := range commands {
+		fmt.Fprintf(plugin.commandOutput(), "buildozer '%s' %s\n", c.command, c.target)
+	}
+	if plugin.commandFile != nil {
+		plugin.commandFile.addCommands(commands)
+	}
+}
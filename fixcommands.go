@@ -0,0 +1,81 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// fixFilesEnv is the environment variable holding the files edited by the
+// fixes, one per line, for the pre_fix_commands and post_fix_commands.
+const fixFilesEnv = "FIX_VISIBILITY_FILES"
+
+// editedFiles accumulates the files edited by the applied fixes.
+type editedFiles struct {
+	paths []string
+	seen  map[string]bool
+}
+
+// add adds the files edited by the given fix.
+func (e *editedFiles) add(files []string) {
+	if e.seen == nil {
+		e.seen = map[string]bool{}
+	}
+	e.paths = appendNew(e.paths, e.seen, files...)
+}
+
+// filesOf returns the files the given fix edits, relative to the workspace
+// root when under it: the BUILD files of the targets of its commands, and the
+// files of its file edits.
+func filesOf(workspaceRoot string, fix *visibilityFix) []string {
+	var files []string
+	seen := map[string]bool{}
+	for _, e := range fix.fileEdits {
+		var path string
+		switch e := e.(type) {
+		case *visibilityRewrite:
+			path = e.path
+		case *allowlistEdit:
+			path = e.path
+		default:
+			continue
+		}
+		if rel, err := filepath.Rel(workspaceRoot, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		files = appendNew(files, seen, path)
+	}
+	for _, c := range fix.commands {
+		files = appendNew(files, seen, relativeBuildFile(workspaceRoot, c.target))
+	}
+	return files
+}
+
+// runFixCommands runs the given user-defined commands with sh in the
+// workspace root, with the given files as arguments and in the
+// FIX_VISIBILITY_FILES environment variable. It stops at the first failing
+// command.
+func runFixCommands(commands []string, workspaceRoot string, files []string) error {
+	for _, command := range commands {
+		cmd := exec.Command("sh", append([]string{"-c", command, "sh"}, files...)...)
+		cmd.Dir = workspaceRoot
+		cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%s", fixFilesEnv, strings.Join(files, "\n")))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to run %q: %w", command, err)
+		}
+	}
+	return nil
+}
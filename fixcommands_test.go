@@ -0,0 +1,67 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFilesOf(t *testing.T) {
+	root := t.TempDir()
+	fix := &visibilityFix{
+		fileEdits: []fileEdit{
+			&allowlistEdit{path: filepath.Join(root, "lib", "visibility.bzl")},
+			&visibilityRewrite{path: "/elsewhere/BUILD.bazel"},
+		},
+		commands: []buildozerCommand{
+			{command: "add visibility //app:__pkg__", target: filepath.Join(root, "lib") + ":a"},
+			{command: "add visibility //tool:__pkg__", target: filepath.Join(root, "lib") + ":a"},
+		},
+	}
+	want := []string{filepath.Join("lib", "visibility.bzl"), "/elsewhere/BUILD.bazel", filepath.Join("lib", "BUILD")}
+	if files := filesOf(root, fix); !reflect.DeepEqual(files, want) {
+		t.Errorf("filesOf() = %q, want %q", files, want)
+	}
+}
+
+func TestRunFixCommands(t *testing.T) {
+	root := t.TempDir()
+	files := []string{"lib/BUILD.bazel", "tool/BUILD.bazel"}
+	commands := []string{
+		`echo "$@" > args.txt`,
+		`printf '%s' "$FIX_VISIBILITY_FILES" > env.txt`,
+	}
+	if err := runFixCommands(commands, root, files); err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]string{
+		"args.txt": "lib/BUILD.bazel tool/BUILD.bazel\n",
+		"env.txt":  "lib/BUILD.bazel\ntool/BUILD.bazel",
+	} {
+		data, err := os.ReadFile(filepath.Join(root, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s = %q, want %q", file, data, want)
+		}
+	}
+
+	// The commands following a failing one are not run.
+	if err := runFixCommands([]string{"exit 3", "touch after.txt"}, root, files); err == nil {
+		t.Error("runFixCommands() succeeded, though a command failed")
+	}
+	if _, err := os.Stat(filepath.Join(root, "after.txt")); err == nil {
+		t.Error("the command following the failing one was run")
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// forwardingAttrs are the attributes through which the rules of the given
+// kinds forward the targets they reference to their consumers.
+var forwardingAttrs = map[string]string{
+	"alias":     "actual",
+	"filegroup": "srcs",
+}
+
+// forwardedIssues returns the visibility issues hidden behind the target
+// being fixed by the given node, when it forwards other targets to its
+// consumers, e.g. an alias or a filegroup. Those targets must be visible from
+// the package of the forwarding target, otherwise fixing only the forwarding
+// target leaves the build broken. Forwarded targets that are forwarding
+// targets themselves are followed by processing the returned issues in turn.
+func (plugin *FixVisibilityPlugin) forwardedIssues(node *fixNode) ([]*fixNode, error) {
+	toFixLabel, err := label.Parse(node.toFix)
+	if err != nil {
+		return nil, err
+	}
+	// The targets that can't be fixed are reported when preparing their fix.
+	target, err := plugin.buildozerTarget(toFixLabel)
+	if err == nil {
+		err = plugin.verifyTarget(target)
+	}
+	var unfixable *unfixableError
+	if errors.As(err, &unfixable) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := plugin.buildozer.run("print kind", target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the forwarded targets: %w", err)
+	}
+	attr, ok := forwardingAttrs[strings.TrimSpace(string(output))]
+	if !ok {
+		return nil, nil
+	}
+	if output, err = plugin.buildozer.run(fmt.Sprintf("print %s", attr), target); err != nil {
+		return nil, fmt.Errorf("failed to find the forwarded targets: %w", err)
+	}
+
+	forwarder := toFixLabel
+	forwarder.Name = "__pkg__"
+	var issues []*fixNode
+	for _, value := range parseLabelList(output) {
+		l, err := label.Parse(value)
+		if err != nil {
+			continue
+		}
+		l = l.Abs(toFixLabel.Repo, toFixLabel.Pkg)
+		forwardedTarget, err := plugin.buildozerTarget(l)
+		if err != nil {
+			continue
+		}
+		// Source files don't have a visibility attribute for buildozer to print
+		// and are skipped.
+		visibility, err := plugin.currentVisibility(forwardedTarget)
+		if err != nil {
+			continue
+		}
+		if (l.Repo == toFixLabel.Repo && l.Pkg == toFixLabel.Pkg) || alreadyGranted(visibility.entries, forwarder, l) {
+			continue
+		}
+		forwarded, isNew := plugin.targetsToFix.insert(l.String(), toFixLabel.String(), "")
+		forwarded.origins = append(forwarded.origins, node.edges()...)
+		if isNew {
+			forwarded.topLevelTargets = node.topLevelTargets
+			issues = append(issues, forwarded)
+		}
+	}
+	return issues, nil
+}
+
+// parseLabelList parses the output of buildozer printing a label or label
+// list attribute.
+func parseLabelList(output []byte) []string {
+	value := strings.TrimSpace(string(output))
+	if value == "(missing)" {
+		return nil
+	}
+	return strings.Fields(strings.Trim(value, "[]"))
+}
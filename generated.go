@@ -0,0 +1,85 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/buildtools/edit"
+)
+
+// checkEditable returns an unfixableError when the BUILD file of the given
+// buildozer target is generated, and editing it would be wasted: when it's a
+// symlink, e.g. to a build output, when it matches the generated_build_files
+// patterns, or when it's not tracked by git and the skip_untracked_build_files
+// property is set.
+func (plugin *FixVisibilityPlugin) checkEditable(workspaceRoot, target string) error {
+	buildFile, _, _ := edit.InterpretLabelForWorkspaceLocation("", target)
+	rel := relativeBuildFile(workspaceRoot, target)
+
+	if info, err := os.Lstat(buildFile); err == nil && info.Mode()&os.ModeSymlink != 0 {
+		return &unfixableError{reason: fmt.Sprintf("%s is a symlink, it's likely generated", rel)}
+	}
+	for _, pattern := range plugin.properties.GeneratedBuildFiles {
+		if matchesGenerated(pattern, filepath.ToSlash(rel)) {
+			return &unfixableError{
+				reason:   fmt.Sprintf("%s is generated, as it matches %q of generated_build_files", rel, pattern),
+				rule:     fmt.Sprintf("generated_build_files: %q", pattern),
+				override: fmt.Sprintf("fix the generator of %s, or remove %q from generated_build_files", rel, pattern),
+			}
+		}
+	}
+	if plugin.properties.SkipUntrackedBuildFiles {
+		tracked, err := gitTracked(workspaceRoot, buildFile)
+		if err != nil {
+			plugin.debug.printf("could not check if %s is tracked by git: %v", rel, err)
+		} else if !tracked {
+			return &unfixableError{
+				reason:   fmt.Sprintf("%s is not tracked by git, it's likely generated", rel),
+				rule:     "skip_untracked_build_files: true",
+				override: fmt.Sprintf("add %s to git, or unset skip_untracked_build_files", rel),
+			}
+		}
+	}
+	return nil
+}
+
+// matchesGenerated returns whether the given path, relative to the workspace
+// root, matches the given pattern of the generated_build_files property: a
+// path.Match pattern, which matches a directory and all of its contents when
+// ending with "/**".
+func matchesGenerated(pattern, path string) bool {
+	if dir := strings.TrimSuffix(pattern, "/**"); dir != pattern {
+		return path == dir || strings.HasPrefix(path, dir+"/")
+	}
+	matched, _ := filepath.Match(pattern, path)
+	return matched
+}
+
+// gitTracked returns whether the given file is tracked by git. It returns an
+// error when the workspace is not a git work tree, or git is not installed.
+func gitTracked(workspaceRoot, path string) (bool, error) {
+	cmd := exec.Command("git", "ls-files", "--error-unmatch", "--", path)
+	cmd.Dir = workspaceRoot
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err == nil {
+		return true, nil
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() == 1 {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to run git: %w: %s", err, stderr.String())
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMatchesGenerated(t *testing.T) {
+	for _, test := range []struct {
+		pattern, path string
+		matches       bool
+	}{
+		{"gen/BUILD.bazel", "gen/BUILD.bazel", true},
+		{"gen/BUILD.bazel", "gen/BUILD", false},
+		{"*/BUILD.bazel", "gen/BUILD.bazel", true},
+		{"*/BUILD.bazel", "gen/sub/BUILD.bazel", false},
+		{"gen/**", "gen/BUILD.bazel", true},
+		{"gen/**", "gen/sub/BUILD.bazel", true},
+		{"gen/**", "generated/BUILD.bazel", false},
+	} {
+		if matches := matchesGenerated(test.pattern, test.path); matches != test.matches {
+			t.Errorf("matchesGenerated(%q, %q) = %t, want %t", test.pattern, test.path, matches, test.matches)
+		}
+	}
+}
+
+func TestCheckEditable(t *testing.T) {
+	root := t.TempDir()
+	for _, file := range []string{"WORKSPACE", "lib/BUILD.bazel", "out/BUILD.bazel", "gen/BUILD.bazel"} {
+		path := filepath.Join(root, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(root, "link"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(root, "out", "BUILD.bazel"), filepath.Join(root, "link", "BUILD.bazel")); err != nil {
+		t.Fatal(err)
+	}
+
+	plugin := &FixVisibilityPlugin{properties: &pluginProperties{GeneratedBuildFiles: []string{"gen/**"}}}
+	for _, test := range []struct {
+		pkg       string
+		unfixable bool
+	}{
+		{"lib", false},
+		{"gen", true},
+		{"link", true},
+	} {
+		err := plugin.checkEditable(root, filepath.Join(root, test.pkg)+":a")
+		var unfixable *unfixableError
+		if errors.As(err, &unfixable) != test.unfixable || (err != nil && !test.unfixable) {
+			t.Errorf("checkEditable(%s) = %v, want unfixable %t", test.pkg, err, test.unfixable)
+		}
+	}
+}
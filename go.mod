@@ -8,6 +8,7 @@ require (
 	github.com/bazelbuild/buildtools v0.0.0-20221004120235-7186f635531b
 	github.com/hashicorp/go-plugin v1.4.5
 	github.com/manifoldco/promptui v0.9.0
+	gopkg.in/yaml.v2 v2.4.0
 )
 
 require (
@@ -46,6 +47,5 @@ require (
 	google.golang.org/grpc v1.49.0 // indirect
 	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/ini.v1 v1.67.0 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// grantPlaceholderRegex matches the placeholders of the grant template.
+var grantPlaceholderRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// grantDirDepthRegex matches the placeholder expanding to the leading
+// directories of the consumer package, up to the given depth.
+var grantDirDepthRegex = regexp.MustCompile(`^from_dir_depth_([0-9]+)$`)
+
+// expandGrantTemplate expands the placeholders of the grant template for the
+// given consumer package:
+//
+//	{from_pkg}          the consumer package, e.g. a/b/c
+//	{from_dir_depth_N}  its first N directories, e.g. a/b for N = 2
+func expandGrantTemplate(template, pkg string) (string, error) {
+	var expandErr error
+	expanded := grantPlaceholderRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := strings.Trim(placeholder, "{}")
+		if name == "from_pkg" {
+			return pkg
+		}
+		matches := grantDirDepthRegex.FindStringSubmatch(name)
+		if matches == nil {
+			expandErr = fmt.Errorf("unknown placeholder %s", placeholder)
+			return placeholder
+		}
+		depth, _ := strconv.Atoi(matches[1])
+		dirs := strings.Split(pkg, "/")
+		if depth < len(dirs) {
+			dirs = dirs[:depth]
+		}
+		return strings.Join(dirs, "/")
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return strings.Replace(expanded, "///", "//", 1), nil
+}
+
+// parseGrantTemplate expands the grant template for the given consumer
+// package, returning the grant label. It must be a package or subpackages
+// label.
+func parseGrantTemplate(template, pkg string) (label.Label, error) {
+	expanded, err := expandGrantTemplate(template, pkg)
+	if err != nil {
+		return label.NoLabel, err
+	}
+	grant, err := label.Parse(expanded)
+	if err != nil {
+		return label.NoLabel, err
+	}
+	if grant.Name != "__pkg__" && grant.Name != "__subpackages__" {
+		return label.NoLabel, fmt.Errorf("%s is neither a __pkg__ nor a __subpackages__ label", expanded)
+	}
+	return grant, nil
+}
+
+// grantLabel returns the visibility entry granting access to the given
+// consumer: the root directory of its owning team when the codeowners_grant
+// property is set, otherwise its package, unless the grant property sets a
+// template. It also describes the policy which produced the entry.
+func (plugin *FixVisibilityPlugin) grantLabel(consumer label.Label) (label.Label, string, error) {
+	if plugin.properties.CodeownersGrant != "" {
+		grant, ok, err := plugin.codeownersGrant(consumer)
+		if err != nil {
+			return label.NoLabel, "", err
+		}
+		if ok {
+			return grant, fmt.Sprintf("the CODEOWNERS rule of the /%s/ directory (codeowners_grant: %s)",
+				grant.Pkg, plugin.properties.CodeownersGrant), nil
+		}
+	}
+	if plugin.properties.Grant == "" {
+		grant := consumer
+		grant.Name = "__pkg__"
+		return grant, "the default grant of the consumer package", nil
+	}
+	grant, err := parseGrantTemplate(plugin.properties.Grant, consumer.Pkg)
+	if err != nil {
+		return label.NoLabel, "", fmt.Errorf("failed to expand the grant template: %w", err)
+	}
+	// The template addresses the consumer repository, unless it names one.
+	if grant.Repo == "" {
+		grant.Repo = consumer.Repo
+	}
+	return grant, fmt.Sprintf("the grant template `%s`", plugin.properties.Grant), nil
+}
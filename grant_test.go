@@ -0,0 +1,47 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import "testing"
+
+func TestParseGrantTemplate(t *testing.T) {
+	for _, test := range []struct {
+		template string
+		pkg      string
+		grant    string
+		invalid  bool
+	}{
+		{"//{from_pkg}:__pkg__", "a/b/c", "//a/b/c:__pkg__", false},
+		{"//{from_dir_depth_1}:__subpackages__", "a/b/c", "//a:__subpackages__", false},
+		{"//{from_dir_depth_2}:__subpackages__", "a/b/c", "//a/b:__subpackages__", false},
+		{"//{from_dir_depth_5}:__subpackages__", "a/b/c", "//a/b/c:__subpackages__", false},
+		{"//{from_pkg}:__pkg__", "", "//:__pkg__", false},
+		{"//{from_dir_depth_1}:__subpackages__", "", "//:__subpackages__", false},
+		{"@other//{from_pkg}:__pkg__", "a", "@other//a:__pkg__", false},
+		{"//{from_team}:__pkg__", "a", "", true},
+		{"//{from_pkg}:lib", "a", "", true},
+		{"//{from_pkg}:__pkg__:x", "a", "", true},
+	} {
+		grant, err := parseGrantTemplate(test.template, test.pkg)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("parseGrantTemplate(%q, %q) = %s, want an error", test.template, test.pkg, grant)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseGrantTemplate(%q, %q) failed: %v", test.template, test.pkg, err)
+			continue
+		}
+		if got := grant.String(); got != test.grant {
+			t.Errorf("parseGrantTemplate(%q, %q) = %s, want %s", test.template, test.pkg, got, test.grant)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	aspectbazel "aspect.build/cli/pkg/bazel"
+)
+
+// trendsCommandName is the name of the custom command reporting the most
+// recurring unfixed issues of the history file.
+const trendsCommandName = "fix-visibility-trends"
+
+// trendsCommandHelp is the description of the trends command.
+const trendsCommandHelp = `Reports the visibility issues recorded in the history file, set by the
+history_file property, which the most runs left unfixed, e.g.
+"aspect fix-visibility-trends 10" for the 10 most recurring ones.`
+
+// trendsDefaultCount is the number of issues the trend report lists unless
+// given.
+const trendsDefaultCount = 20
+
+// historyUnfixed is the status in the history of the issues no fix was
+// computed for, e.g. because the target can't be edited.
+const historyUnfixed = "unfixed"
+
+// historyRecord is the record of the issues detected by a run, appended as a
+// JSON line to the history file and POSTed to the history URL.
+type historyRecord struct {
+	Timestamp    string         `json:"timestamp"`
+	InvocationID string         `json:"invocation_id"`
+	Hook         string         `json:"hook"`
+	Issues       []historyIssue `json:"issues"`
+
+	// index maps the detected issues to their position in Issues, and
+	// resolved tells which of them an outcome was recorded for.
+	index    map[fixKey]int
+	resolved map[int]bool
+}
+
+// historyIssue is an issue detected by a run, along with the status of its
+// fix.
+type historyIssue struct {
+	ToFix  string `json:"to_fix"`
+	From   string `json:"from"`
+	Status string `json:"status"`
+}
+
+// newHistoryRecord returns the record of the given detected issues, including
+// the consumers folded into them, all unfixed until their fix is resolved.
+func (plugin *FixVisibilityPlugin) newHistoryRecord(hook string, nodes []*fixNode) *historyRecord {
+	record := &historyRecord{
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		InvocationID: plugin.invocationID,
+		Hook:         hook,
+		index:        map[fixKey]int{},
+		resolved:     map[int]bool{},
+	}
+	for _, node := range nodes {
+		for _, key := range node.edges() {
+			if _, ok := record.index[key]; !ok {
+				record.index[key] = len(record.Issues)
+				record.Issues = append(record.Issues, historyIssue{ToFix: key.toFix, From: key.from, Status: historyUnfixed})
+			}
+		}
+	}
+	return record
+}
+
+// resolve records the given outcome of the given node for the detected issues
+// it stands for: its own, those of the consumers folded into it, and those it
+// was derived from, e.g. when forwarded by an alias, retargeted to a
+// toolchain() rule or expanded from a test_suite. A detected issue is only
+// fixed when all the nodes derived from it are, so the first other outcome
+// recorded for it is kept.
+func (record *historyRecord) resolve(node *fixNode, status string) {
+	if record == nil {
+		return
+	}
+	for _, key := range node.edges() {
+		i, ok := record.index[key]
+		if !ok {
+			continue
+		}
+		if !record.resolved[i] || isFixedStatus(record.Issues[i].Status) {
+			record.Issues[i].Status = status
+		}
+		record.resolved[i] = true
+	}
+}
+
+// resolveIssue records the given outcome, as named in the history, of the
+// given node in the history and in the state handed off to the next
+// invocation.
+func (plugin *FixVisibilityPlugin) resolveIssue(node *fixNode, status string) {
+	plugin.history.resolve(node, status)
+	plugin.state.resolve(node, status)
+}
+
+// isFixedStatus returns whether the given status of the history is that of a
+// fixed issue.
+func isFixedStatus(status string) bool {
+	return status == fixStatusNames[fixApplied] || status == fixStatusNames[fixObsolete]
+}
+
+// saveHistory appends the record to the history file and POSTs it to the history
+// URL, as set by the history_file and history_url properties. The labels
+// sent to the URL are redacted as set by the redact_labels property.
+func (plugin *FixVisibilityPlugin) saveHistory(workspaceRoot string, record *historyRecord) error {
+	if plugin.properties.HistoryFile != "" {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to record the history: %w", err)
+		}
+		path := plugin.historyPath(workspaceRoot)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to record the history: %w", err)
+		}
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to record the history: %w", err)
+		}
+		defer f.Close()
+		if _, err := f.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to record the history: %w", err)
+		}
+	}
+
+	if plugin.properties.HistoryURL != "" {
+		redacted := *record
+		redacted.Issues = nil
+		for _, issue := range record.Issues {
+			issue.ToFix, issue.From = plugin.redact(issue.ToFix), plugin.redact(issue.From)
+			redacted.Issues = append(redacted.Issues, issue)
+		}
+		data, err := json.Marshal(redacted)
+		if err != nil {
+			return fmt.Errorf("failed to publish the history: %w", err)
+		}
+		client := &http.Client{Timeout: resultsTimeout}
+		resp, err := client.Post(plugin.properties.HistoryURL, "application/json", bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to publish the history: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			return fmt.Errorf("failed to publish the history: %s responded %s", plugin.properties.HistoryURL, resp.Status)
+		}
+	}
+	return nil
+}
+
+// historyPath returns the path of the history file, relative to the workspace
+// root unless absolute.
+func (plugin *FixVisibilityPlugin) historyPath(workspaceRoot string) string {
+	path := plugin.properties.HistoryFile
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceRoot, path)
+	}
+	return path
+}
+
+// readHistory calls the given function with each record of the history file
+// at the given path, in order.
+func readHistory(path string, fn func(record *historyRecord)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the history: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var record historyRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			return fmt.Errorf("failed to parse the history: %w", err)
+		}
+		fn(&record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read the history: %w", err)
+	}
+	return nil
+}
+
+// issueTrend is the recurrence of an issue across the runs of the history.
+type issueTrend struct {
+	toFix     string
+	from      string
+	unfixed   int
+	firstSeen string
+	lastSeen  string
+	// lastStatus is the status of the fix in the last run the issue was
+	// detected in.
+	lastStatus string
+}
+
+// loadTrends reads the history file at the given path, returning the issues
+// left unfixed by at least one run, the most recurring first, along with the
+// number of runs.
+func loadTrends(path string) ([]*issueTrend, int, error) {
+	trends := map[fixKey]*issueTrend{}
+	runs := 0
+	err := readHistory(path, func(record *historyRecord) {
+		runs++
+		for _, issue := range record.Issues {
+			key := fixKey{toFix: issue.ToFix, from: issue.From}
+			trend, ok := trends[key]
+			if !ok {
+				trend = &issueTrend{toFix: issue.ToFix, from: issue.From, firstSeen: record.Timestamp}
+				trends[key] = trend
+			}
+			trend.lastSeen = record.Timestamp
+			trend.lastStatus = issue.Status
+			if !isFixedStatus(issue.Status) {
+				trend.unfixed++
+			}
+		}
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var sorted []*issueTrend
+	for _, trend := range trends {
+		if trend.unfixed > 0 {
+			sorted = append(sorted, trend)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].unfixed != sorted[j].unfixed {
+			return sorted[i].unfixed > sorted[j].unfixed
+		}
+		if sorted[i].toFix != sorted[j].toFix {
+			return sorted[i].toFix < sorted[j].toFix
+		}
+		return sorted[i].from < sorted[j].from
+	})
+	return sorted, runs, nil
+}
+
+// runTrendsCommand prints the issues of the history file most often left
+// unfixed, as many as given as argument.
+func (plugin *FixVisibilityPlugin) runTrendsCommand(ctx context.Context, args []string, bzl aspectbazel.Bazel) error {
+	count := trendsDefaultCount
+	if len(args) > 1 {
+		return fmt.Errorf("usage: aspect %s [<count>]", trendsCommandName)
+	}
+	if len(args) == 1 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("usage: aspect %s [<count>]", trendsCommandName)
+		}
+		count = n
+	}
+	if plugin.properties.HistoryFile == "" {
+		return fmt.Errorf("the history_file property is not set, there's no history to report on")
+	}
+
+	workspaceRoot, err := plugin.workspaceRoot()
+	if err != nil {
+		return err
+	}
+	trends, runs, err := loadTrends(plugin.historyPath(workspaceRoot))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stdout, "%d visibility issues were left unfixed over the %d recorded runs\n", len(trends), runs)
+	if len(trends) == 0 {
+		return nil
+	}
+	if len(trends) > count {
+		trends = trends[:count]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tCONSUMER\tUNFIXED RUNS\tFIRST SEEN\tLAST SEEN\tLAST STATUS")
+	for _, trend := range trends {
+		fmt.Fprintln(w, strings.Join([]string{
+			trend.toFix, trend.from, strconv.Itoa(trend.unfixed), trend.firstSeen, trend.lastSeen, trend.lastStatus,
+		}, "\t"))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestHistoryRecord(t *testing.T) {
+	alias := &fixNode{toFix: "//lib:a", from: "//app:app", folded: []string{"//app:other"}}
+	actual := &fixNode{toFix: "//lib:actual", from: "//lib:a", origins: []fixKey{{toFix: "//lib:a", from: "//tool:tool"}}}
+	derived := &fixNode{toFix: "//lib:b", from: "//tool:tool", origins: []fixKey{{toFix: "//lib:a", from: "//tool:tool"}}}
+	plugin := &FixVisibilityPlugin{invocationID: "e2e"}
+	record := plugin.newHistoryRecord("post_build", []*fixNode{alias, {toFix: "//lib:a", from: "//tool:tool"}})
+
+	record.resolve(alias, "applied")
+	// The issue of //tool:tool is only fixed when both nodes derived from it
+	// are.
+	record.resolve(actual, "declined")
+	record.resolve(derived, "applied")
+	// Nodes that no detected issue stands for are ignored.
+	record.resolve(&fixNode{toFix: "//lib:c", from: "//app:app"}, "applied")
+	var nilRecord *historyRecord
+	nilRecord.resolve(alias, "applied")
+
+	want := []historyIssue{
+		{ToFix: "//lib:a", From: "//app:app", Status: "applied"},
+		{ToFix: "//lib:a", From: "//app:other", Status: "applied"},
+		{ToFix: "//lib:a", From: "//tool:tool", Status: "declined"},
+	}
+	if !reflect.DeepEqual(record.Issues, want) {
+		t.Errorf("Issues = %+v, want %+v", record.Issues, want)
+	}
+}
+
+func TestLoadTrends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	history := `{"timestamp":"2026-01-01T00:00:00Z","issues":[{"to_fix":"//lib:a","from":"//app:app","status":"unfixed"},{"to_fix":"//lib:b","from":"//app:app","status":"declined"}]}
+
+{"timestamp":"2026-01-02T00:00:00Z","issues":[{"to_fix":"//lib:a","from":"//app:app","status":"declined"},{"to_fix":"//lib:c","from":"//app:app","status":"applied"}]}
+{"timestamp":"2026-01-03T00:00:00Z","issues":[{"to_fix":"//lib:a","from":"//app:app","status":"applied"},{"to_fix":"//lib:b","from":"//app:app","status":"obsolete"}]}
+`
+	if err := os.WriteFile(path, []byte(history), 0644); err != nil {
+		t.Fatal(err)
+	}
+	trends, runs, err := loadTrends(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*issueTrend{
+		{toFix: "//lib:a", from: "//app:app", unfixed: 2, firstSeen: "2026-01-01T00:00:00Z", lastSeen: "2026-01-03T00:00:00Z", lastStatus: "applied"},
+		{toFix: "//lib:b", from: "//app:app", unfixed: 1, firstSeen: "2026-01-01T00:00:00Z", lastSeen: "2026-01-03T00:00:00Z", lastStatus: "obsolete"},
+	}
+	if runs != 3 || !reflect.DeepEqual(trends, want) {
+		t.Errorf("loadTrends() = %+v, %d, want %+v, 3", trends, runs, want)
+	}
+
+	if err := os.WriteFile(path, []byte("not json\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadTrends(path); err == nil {
+		t.Error("loadTrends() of an invalid history succeeded")
+	}
+}
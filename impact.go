@@ -0,0 +1,93 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// blockingIssues counts, for each top-level target whose analysis failed, the
+// collected issues it failed because of.
+func blockingIssues(nodes []*fixNode) map[string]int {
+	blockers := map[string]int{}
+	for _, node := range nodes {
+		for _, t := range node.topLevelTargets {
+			blockers[t]++
+		}
+	}
+	return blockers
+}
+
+// impactOf returns the top-level targets whose analysis failed because of the
+// issues of the given fix, split between the ones the fix unblocks, which
+// failed because of no other issue not fixed yet, and the ones still blocked
+// by other issues after the fix.
+func (plugin *FixVisibilityPlugin) impactOf(fix *visibilityFix) (unblocked, blocked []string) {
+	var targets []string
+	seen := map[string]bool{}
+	for _, f := range fix.constituents() {
+		targets = appendNew(targets, seen, f.node.topLevelTargets...)
+	}
+	for _, t := range targets {
+		if countOf(fix, t) >= plugin.blockers[t] {
+			unblocked = append(unblocked, t)
+		} else {
+			blocked = append(blocked, t)
+		}
+	}
+	return unblocked, blocked
+}
+
+// printImpact prints the top-level targets the fix unblocks, and those it
+// doesn't fully.
+func (plugin *FixVisibilityPlugin) printImpact(fix *visibilityFix) {
+	unblocked, blocked := plugin.impactOf(fix)
+	if len(unblocked) > 0 {
+		fmt.Fprintf(os.Stdout, "Applying the fix unblocks %s\n", joinLabels(unblocked))
+	}
+	for _, t := range blocked {
+		fmt.Fprintf(os.Stdout, "%s stays blocked by %d other visibility issue(s)\n", t, plugin.blockers[t]-countOf(fix, t))
+	}
+}
+
+// countOf returns the number of the issues of the fix the given top-level
+// target failed because of.
+func countOf(fix *visibilityFix, topLevel string) int {
+	n := 0
+	for _, f := range fix.constituents() {
+		for _, t := range f.node.topLevelTargets {
+			if t == topLevel {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// joinLabels joins the given labels as in "//a, //b and //c".
+func joinLabels(labels []string) string {
+	if len(labels) == 1 {
+		return labels[0]
+	}
+	return fmt.Sprintf("%s and %s", strings.Join(labels[:len(labels)-1], ", "), labels[len(labels)-1])
+}
+
+// unblock discounts the issues of the given applied fix from the blockers of
+// the top-level targets, so that the impact of the remaining fixes accounts
+// for it.
+func (plugin *FixVisibilityPlugin) unblock(fix *visibilityFix) {
+	for _, f := range fix.constituents() {
+		for _, t := range f.node.topLevelTargets {
+			plugin.blockers[t]--
+		}
+	}
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImpactOf(t *testing.T) {
+	a := &visibilityFix{node: &fixNode{toFix: "//lib:a", from: "//app:app", topLevelTargets: []string{"//app:app", "//app:bin"}}}
+	b := &visibilityFix{node: &fixNode{toFix: "//lib:b", from: "//app:app", topLevelTargets: []string{"//app:app"}}}
+	c := &visibilityFix{node: &fixNode{toFix: "//lib:c", from: "//tool:tool", topLevelTargets: []string{"//tool:tool"}}}
+	merged := &visibilityFix{node: b.node, merged: []*visibilityFix{b, c}}
+	plugin := &FixVisibilityPlugin{blockers: blockingIssues([]*fixNode{a.node, b.node, c.node})}
+
+	for _, test := range []struct {
+		name               string
+		fix                *visibilityFix
+		unblocked, blocked []string
+	}{
+		{"partially unblocking", a, []string{"//app:bin"}, []string{"//app:app"}},
+		{"merged", merged, []string{"//tool:tool"}, []string{"//app:app"}},
+	} {
+		unblocked, blocked := plugin.impactOf(test.fix)
+		if !reflect.DeepEqual(unblocked, test.unblocked) || !reflect.DeepEqual(blocked, test.blocked) {
+			t.Errorf("%s: impactOf() = %q, %q, want %q, %q", test.name, unblocked, blocked, test.unblocked, test.blocked)
+		}
+	}
+
+	// Once a is applied, b is the last issue blocking //app:app.
+	plugin.unblock(a)
+	if unblocked, blocked := plugin.impactOf(b); !reflect.DeepEqual(unblocked, []string{"//app:app"}) || blocked != nil {
+		t.Errorf("impactOf() after applying a = %q, %q, want [//app:app], []", unblocked, blocked)
+	}
+}
+
+func TestJoinLabels(t *testing.T) {
+	for _, test := range []struct {
+		labels []string
+		joined string
+	}{
+		{[]string{"//a"}, "//a"},
+		{[]string{"//a", "//b"}, "//a and //b"},
+		{[]string{"//a", "//b", "//c"}, "//a, //b and //c"},
+	} {
+		if joined := joinLabels(test.labels); joined != test.joined {
+			t.Errorf("joinLabels(%q) = %q, want %q", test.labels, joined, test.joined)
+		}
+	}
+}
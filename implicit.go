@@ -0,0 +1,76 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/buildtools/edit"
+)
+
+// implicitAttrRegex matches the attribute and the rule kind in the description
+// of an issue reported for an implicit, private, attribute of a rule, e.g.
+// `in _tool attribute of my_rule rule //app:app`.
+var implicitAttrRegex = regexp.MustCompile(`in (_\w+) attribute of (\w+) rule`)
+
+// implicitDependency returns the implicit attribute through which the consumer
+// depends on the target being fixed, along with the kind of the consumer rule,
+// if the given error description reports such an issue.
+func implicitDependency(description string) (attr, kind string) {
+	matches := implicitAttrRegex.FindStringSubmatch(description)
+	if len(matches) != 3 {
+		return "", ""
+	}
+	return matches[1], matches[2]
+}
+
+// implicitDefinition returns the label of the .bzl file defining the rule of
+// the consumer, when the issue is reported for an implicit attribute of the
+// rule. Bazel checks the visibility of the implicit dependencies against the
+// package of the rule definition rather than the one of the BUILD file using
+// the rule, so that package is the one to grant access to. The definition is
+// found among the loads of the BUILD file of the consumer; it returns nil
+// if it isn't, e.g. when the rule is instantiated by a macro.
+func (plugin *FixVisibilityPlugin) implicitDefinition(node *fixNode, consumer label.Label) (*label.Label, error) {
+	if node.implicitAttr == "" || isExternal(consumer) {
+		return nil, nil
+	}
+	path, _, _ := edit.InterpretLabelForWorkspaceLocation("", consumer.String())
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read BUILD file: %w", err)
+	}
+	file, err := build.ParseBuild(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse BUILD file: %w", err)
+	}
+	for _, stmt := range file.Stmt {
+		load, ok := stmt.(*build.LoadStmt)
+		if !ok {
+			continue
+		}
+		for _, from := range load.From {
+			if from.Name != node.ruleKind {
+				continue
+			}
+			module, err := label.Parse(load.Module.Value)
+			if err != nil {
+				return nil, nil
+			}
+			module = module.Abs(consumer.Repo, consumer.Pkg)
+			return &module, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// The possible values for the label_style property.
+const (
+	// labelStyleShort shortens the labels whose name is the last component of
+	// their package, e.g. //foo/bar for //foo/bar:bar. This is the default.
+	labelStyleShort = "short"
+	// labelStyleCanonical always spells out the name of the labels.
+	labelStyleCanonical = "canonical"
+)
+
+// formatLabel returns the given label in the style set by the label_style
+// property, used for the labels in both the printed commands and the applied
+// edits.
+func (plugin *FixVisibilityPlugin) formatLabel(l label.Label) string {
+	if plugin.properties.LabelStyle != labelStyleCanonical {
+		return l.String()
+	}
+	repo := ""
+	if l.Repo != "" {
+		repo = "@" + l.Repo
+	}
+	if l.Repo == "@" {
+		repo = "@"
+	}
+	return fmt.Sprintf("%s//%s:%s", repo, l.Pkg, l.Name)
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+func TestFormatLabel(t *testing.T) {
+	for _, test := range []struct {
+		label     label.Label
+		short     string
+		canonical string
+	}{
+		{label.New("", "foo/bar", "bar"), "//foo/bar", "//foo/bar:bar"},
+		{label.New("", "foo/bar", "baz"), "//foo/bar:baz", "//foo/bar:baz"},
+		{label.New("", "app", "__pkg__"), "//app:__pkg__", "//app:__pkg__"},
+		{label.New("", "", "__subpackages__"), "//:__subpackages__", "//:__subpackages__"},
+		{label.New("other", "lib", "lib"), "@other//lib", "@other//lib:lib"},
+		{label.New("@", "lib", "lib"), "@//lib", "@//lib:lib"},
+	} {
+		for style, want := range map[string]string{labelStyleShort: test.short, labelStyleCanonical: test.canonical} {
+			plugin := &FixVisibilityPlugin{properties: &pluginProperties{LabelStyle: style}}
+			if got := plugin.formatLabel(test.label); got != want {
+				t.Errorf("formatLabel(%#v) = %q in the %s style, want %q", test.label, got, style, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// messages are the wordings of the interactive experience, which can be
+// overridden by the messages property or the messages_file property.
+type messages struct {
+	// FixPrompt is the label of the prompt asking whether to apply a fix,
+	// including the hint of the answers.
+	FixPrompt string `yaml:"fix_prompt"`
+	// MergedFixPrompt is the label of the prompt asking whether to apply the
+	// fixes of several consumers of a target at once, with {count} replaced
+	// by the number of consumers and {target} by the target being fixed.
+	MergedFixPrompt string `yaml:"merged_fix_prompt"`
+	// Yes, No and Explain are the answers accepted by the fix prompt, the
+	// empty answer being no. All and Quit answer yes, respectively no, to the
+	// remaining fix prompts too.
+	Yes     []string `yaml:"yes"`
+	No      []string `yaml:"no"`
+	Explain []string `yaml:"explain"`
+	All     []string `yaml:"all"`
+	Quit    []string `yaml:"quit"`
+	// DeprecatedPrompt is the label of the prompt asking whether to grant
+	// access to a deprecated target regardless, with {target} replaced by the
+	// target being fixed.
+	DeprecatedPrompt string `yaml:"deprecated_prompt"`
+	// CleanupPrompt is the label of the prompt asking whether to remove the
+	// visibility entries made redundant by a fix.
+	CleanupPrompt string `yaml:"cleanup_prompt"`
+	// FixCommands and CleanupCommands introduce the printed commands.
+	FixCommands     string `yaml:"fix_commands"`
+	CleanupCommands string `yaml:"cleanup_commands"`
+	// EditorPrompt is the label of the prompt asking whether to open the files
+	// edited by the fixes in the editor, with {count} replaced by the number
+	// of files and {editor} by the editor.
+	EditorPrompt string `yaml:"editor_prompt"`
+	// BatchPrompt is the label of the prompt asking whether to go on with the
+	// next batch of fixes, with {batch} replaced by its number and {count} by
+	// the number of batches.
+	BatchPrompt string `yaml:"batch_prompt"`
+	// CommandFileWritten introduces the command running the command file,
+	// with {path} replaced by its path.
+	CommandFileWritten string `yaml:"command_file_written"`
+}
+
+// defaultMessages are the wordings used unless overridden.
+var defaultMessages = messages{
+	FixPrompt:          "Would you like to auto-fix to the visibility attribute? [y/N/a(ll)/q(uit)/e(xplain)]",
+	MergedFixPrompt:    "Would you like to grant these {count} consumers access to {target}? [y/N/a(ll)/q(uit)/e(xplain)]",
+	Yes:                []string{"y", "yes"},
+	No:                 []string{"n", "no"},
+	Explain:            []string{"e", "explain"},
+	All:                []string{"a", "all"},
+	Quit:               []string{"q", "quit"},
+	DeprecatedPrompt:   "Would you like to grant access to the deprecated {target} anyway",
+	CleanupPrompt:      "Would you like to remove the visibility entries made redundant by the fix",
+	FixCommands:        "To fix the visibility errors, run:",
+	CleanupCommands:    "To remove the visibility entries made redundant by the fix, run:",
+	EditorPrompt:       "Would you like to review the {count} edited file(s) in {editor}",
+	BatchPrompt:        "Would you like to continue with batch {batch} of {count}",
+	CommandFileWritten: "The buildozer commands were written to {path}, run them all with:",
+}
+
+// withDefaults returns the messages with the ones not set taken from the
+// given defaults.
+func (m messages) withDefaults(defaults messages) messages {
+	if m.FixPrompt == "" {
+		m.FixPrompt = defaults.FixPrompt
+	}
+	if m.MergedFixPrompt == "" {
+		m.MergedFixPrompt = defaults.MergedFixPrompt
+	}
+	if len(m.Yes) == 0 {
+		m.Yes = defaults.Yes
+	}
+	if len(m.No) == 0 {
+		m.No = defaults.No
+	}
+	if len(m.Explain) == 0 {
+		m.Explain = defaults.Explain
+	}
+	if len(m.All) == 0 {
+		m.All = defaults.All
+	}
+	if len(m.Quit) == 0 {
+		m.Quit = defaults.Quit
+	}
+	if m.DeprecatedPrompt == "" {
+		m.DeprecatedPrompt = defaults.DeprecatedPrompt
+	}
+	if m.CleanupPrompt == "" {
+		m.CleanupPrompt = defaults.CleanupPrompt
+	}
+	if m.FixCommands == "" {
+		m.FixCommands = defaults.FixCommands
+	}
+	if m.CleanupCommands == "" {
+		m.CleanupCommands = defaults.CleanupCommands
+	}
+	if m.EditorPrompt == "" {
+		m.EditorPrompt = defaults.EditorPrompt
+	}
+	if m.BatchPrompt == "" {
+		m.BatchPrompt = defaults.BatchPrompt
+	}
+	if m.CommandFileWritten == "" {
+		m.CommandFileWritten = defaults.CommandFileWritten
+	}
+	return m
+}
+
+// loadMessages resolves the messages of the invocation: those of the
+// messages property, then those of the messages file, relative to the
+// workspace root unless absolute, then the defaults.
+func (plugin *FixVisibilityPlugin) loadMessages(workspaceRoot string) error {
+	defaults := defaultMessages
+	if path := plugin.properties.MessagesFile; path != "" {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workspaceRoot, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read the messages file: %w", err)
+		}
+		var fromFile messages
+		if err := yaml.Unmarshal(data, &fromFile); err != nil {
+			return fmt.Errorf("failed to parse the messages file: %w", err)
+		}
+		defaults = fromFile.withDefaults(defaults)
+	}
+	plugin.messages = plugin.properties.Messages.withDefaults(defaults)
+	return nil
+}
+
+// isAnswer returns whether the given answer is one of the given ones.
+func isAnswer(answer string, answers []string) bool {
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	for _, a := range answers {
+		if answer == strings.ToLower(a) {
+			return true
+		}
+	}
+	return false
+}
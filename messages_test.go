@@ -0,0 +1,66 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadMessages(t *testing.T) {
+	workspaceRoot := t.TempDir()
+	content := `fix_prompt: "Corriger la visibilité ?"
+cleanup_prompt: "Supprimer les entrées redondantes ?"
+yes: ["o", "oui"]
+`
+	if err := os.WriteFile(filepath.Join(workspaceRoot, "messages.yaml"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plugin := &FixVisibilityPlugin{properties: &pluginProperties{
+		Messages:     messages{FixPrompt: "Corriger ?", No: []string{"non"}},
+		MessagesFile: "messages.yaml",
+	}}
+	if err := plugin.loadMessages(workspaceRoot); err != nil {
+		t.Fatal(err)
+	}
+	want := defaultMessages
+	want.FixPrompt = "Corriger ?"
+	want.CleanupPrompt = "Supprimer les entrées redondantes ?"
+	want.Yes = []string{"o", "oui"}
+	want.No = []string{"non"}
+	if !reflect.DeepEqual(plugin.messages, want) {
+		t.Errorf("loadMessages() = %+v, want %+v", plugin.messages, want)
+	}
+
+	plugin.properties.MessagesFile = "missing.yaml"
+	if err := plugin.loadMessages(workspaceRoot); err == nil {
+		t.Errorf("loadMessages() succeeded, want the failure to read the messages file")
+	}
+}
+
+func TestIsAnswer(t *testing.T) {
+	for _, test := range []struct {
+		answer  string
+		answers []string
+		is      bool
+	}{
+		{"y", []string{"y", "yes"}, true},
+		{" YES ", []string{"y", "yes"}, true},
+		{"Oui", []string{"o", "oui"}, true},
+		{"", []string{"y", "yes"}, false},
+		{"ye", []string{"y", "yes"}, false},
+	} {
+		if is := isAnswer(test.answer, test.answers); is != test.is {
+			t.Errorf("isAnswer(%q, %q) = %t, want %t", test.answer, test.answers, is, test.is)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// metricsTimeout bounds the time spent pushing the metrics, so that an
+// unavailable Pushgateway doesn't hold the terminal.
+const metricsTimeout = 10 * time.Second
+
+// metricsJob is the job the metrics are pushed under.
+const metricsJob = "fix_visibility"
+
+// ciJobVariables are the environment variables naming the CI job, as set by
+// the common CI systems, in the order they are looked up.
+var ciJobVariables = []string{
+	"CI_JOB_NAME",     // GitLab
+	"GITHUB_JOB",      // GitHub Actions
+	"BUILDKITE_LABEL", // Buildkite
+	"CIRCLE_JOB",      // CircleCI
+	"JOB_NAME",        // Jenkins
+}
+
+// runMetrics are the metrics of a post hook, pushed to the Pushgateway
+// configured by the metrics_url property. A nil *runMetrics discards them.
+type runMetrics struct {
+	start    time.Time
+	issues   int
+	statuses map[fixStatus]int
+	// unfixable is the number of issues which can't be fixed.
+	unfixable int
+	// fixing is the time spent resolving the fixes, including prompting.
+	fixing time.Duration
+	// events is the lag of the build events of the run.
+	events eventLagStats
+}
+
+// newRunMetrics returns the metrics of a post hook starting now.
+func newRunMetrics() *runMetrics {
+	return &runMetrics{start: time.Now(), statuses: make(map[fixStatus]int)}
+}
+
+// countIssues adds the given number of collected issues.
+func (m *runMetrics) countIssues(n int) {
+	if m != nil {
+		m.issues += n
+	}
+}
+
+// countUnfixable counts an issue which can't be fixed.
+func (m *runMetrics) countUnfixable() {
+	if m != nil {
+		m.unfixable++
+	}
+}
+
+// countFixes counts the given number of fixes with the given status,
+// resolved at once in the given time.
+func (m *runMetrics) countFixes(status fixStatus, n int, fixing time.Duration) {
+	if m != nil {
+		m.statuses[status] += n
+		m.fixing += fixing
+	}
+}
+
+// push POSTs the metrics to the given Pushgateway, grouped by the given
+// repository name, the CI job and the hook, along with whether the hook
+// failed.
+func (m *runMetrics) push(pushgatewayURL, repo, hook string, hookErr error) error {
+	var body bytes.Buffer
+	typed := make(map[string]bool)
+	gauge := func(name, help string, value interface{}, labels ...string) {
+		if !typed[name] {
+			typed[name] = true
+			fmt.Fprintf(&body, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		}
+		fmt.Fprintf(&body, "%s%s %v\n", name, strings.Join(labels, ""), value)
+	}
+	success := 1
+	if hookErr != nil {
+		success = 0
+	}
+	gauge("fix_visibility_run_success", "Whether the last run fixed the visibility issues without failing.", success)
+	gauge("fix_visibility_run_duration_seconds", "The duration of the last run.", time.Since(m.start).Seconds())
+	gauge("fix_visibility_fixing_duration_seconds", "The time the last run spent resolving the fixes, including prompting.", m.fixing.Seconds())
+	gauge("fix_visibility_issues", "The number of visibility issues collected by the last run.", m.issues)
+	gauge("fix_visibility_unfixable", "The number of visibility issues the last run couldn't fix.", m.unfixable)
+	for _, status := range []fixStatus{fixPrinted, fixApplied, fixDeclined, fixObsolete} {
+		gauge("fix_visibility_fixes", "The number of fixes of the last run, by status.", m.statuses[status],
+			fmt.Sprintf("{status=%q}", fixStatusNames[status]))
+	}
+	gauge("fix_visibility_build_events", "The number of build events processed by the last run.", m.events.events)
+	gauge("fix_visibility_build_event_processing_seconds", "The time the last run held the build events.", m.events.busy.Seconds())
+	gauge("fix_visibility_build_event_slowest_seconds", "The longest time the last run held a single build event.", m.events.slowest.Seconds())
+	gauge("fix_visibility_build_event_stall_seconds", "The time the last run held the build events for the violations file to catch up.", m.events.stalled.Seconds())
+	gauge("fix_visibility_violation_queue_peak", "The most violations queued for writing at once by the last run.", m.events.queuePeak)
+	gauge("fix_visibility_last_run_timestamp_seconds", "The time of the last run.", m.start.Unix())
+
+	ciJob := ""
+	for _, name := range ciJobVariables {
+		if ciJob = os.Getenv(name); ciJob != "" {
+			break
+		}
+	}
+	pushURL := strings.TrimSuffix(pushgatewayURL, "/") + "/metrics/job/" + metricsJob +
+		groupingLabel("repo", repo) +
+		groupingLabel("ci_job", ciJob) +
+		groupingLabel("hook", hook)
+
+	client := &http.Client{Timeout: metricsTimeout}
+	resp, err := client.Post(pushURL, "text/plain; version=0.0.4", &body)
+	if err != nil {
+		return fmt.Errorf("failed to push metrics: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to push metrics: %s responded %s", pushgatewayURL, resp.Status)
+	}
+	return nil
+}
+
+// groupingLabel returns the path segments of a Pushgateway grouping label.
+// The values which can't be path segments as is, such as the empty value or
+// those containing a '/', are base64-encoded as the Pushgateway supports.
+func groupingLabel(name, value string) string {
+	if value == "" || strings.Contains(value, "/") {
+		encoded := base64.RawURLEncoding.EncodeToString([]byte(value))
+		if encoded == "" {
+			encoded = "="
+		}
+		return "/" + name + "@base64/" + encoded
+	}
+	return "/" + name + "/" + url.PathEscape(value)
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGroupingLabel(t *testing.T) {
+	for _, test := range []struct {
+		value string
+		path  string
+	}{
+		{"repo", "/repo/repo"},
+		{"my repo", "/repo/my%20repo"},
+		{"", "/repo@base64/="},
+		{"a/b", "/repo@base64/YS9i"},
+	} {
+		if path := groupingLabel("repo", test.value); path != test.path {
+			t.Errorf("groupingLabel(%q) = %q, want %q", test.value, path, test.path)
+		}
+	}
+}
+
+func TestPushMetrics(t *testing.T) {
+	for _, name := range ciJobVariables {
+		t.Setenv(name, "")
+	}
+	t.Setenv("GITHUB_JOB", "lint")
+
+	var path, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		path, body = r.URL.EscapedPath(), string(data)
+	}))
+	defer server.Close()
+
+	m := newRunMetrics()
+	m.countIssues(3)
+	m.countUnfixable()
+	m.countFixes(fixApplied, 2, time.Second)
+	m.countFixes(fixDeclined, 1, time.Second)
+	if err := m.push(server.URL+"/", "my-repo", hookTest, fmt.Errorf("failed")); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/metrics/job/fix_visibility/repo/my-repo/ci_job/lint/hook/test"; path != want {
+		t.Errorf("push() pushed to %s, want %s", path, want)
+	}
+	for _, line := range []string{
+		"# TYPE fix_visibility_run_success gauge\nfix_visibility_run_success 0\n",
+		"fix_visibility_fixing_duration_seconds 2\n",
+		"fix_visibility_issues 3\n",
+		"fix_visibility_unfixable 1\n",
+		"# TYPE fix_visibility_fixes gauge\nfix_visibility_fixes{status=\"proposed\"} 0\nfix_visibility_fixes{status=\"applied\"} 2\nfix_visibility_fixes{status=\"declined\"} 1\nfix_visibility_fixes{status=\"obsolete\"} 0\n",
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("push() pushed:\n%s\nwant it to contain:\n%s", body, line)
+		}
+	}
+
+	// The metrics of a run without metrics_url are discarded.
+	var discarded *runMetrics
+	discarded.countIssues(1)
+	discarded.countUnfixable()
+	discarded.countFixes(fixApplied, 1, time.Second)
+}
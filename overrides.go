@@ -0,0 +1,146 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The protocol of the CLI passes no flags to the plugins, and Bazel rejects
+// the flags it doesn't know, so the overrides of a single invocation are
+// passed as build metadata, which Bazel accepts and reports in the
+// OptionsParsed event, e.g.
+//
+//	aspect build //... --build_metadata=FIX_VISIBILITY=strategy=public,dry-run
+//
+// The fix-visibility command takes them as flags of its own instead, e.g.
+//
+//	aspect fix-visibility cquery 'deps(//app)' --fix-visibility:dry-run
+
+// overridesMetadataKey is the key of the build metadata holding the
+// overrides.
+const overridesMetadataKey = "FIX_VISIBILITY"
+
+// overridesFlagPrefix is the prefix of the override flags of the custom
+// commands.
+const overridesFlagPrefix = "--fix-visibility:"
+
+// The overrides, as key or key=value.
+const (
+	// overrideStrategy sets the strategy fixing all the targets.
+	overrideStrategy = "strategy"
+	// overridePackageGroup sets the package_group granted by the
+	// package_group strategy.
+	overridePackageGroup = "package_group"
+	// overrideDryRun prints the fixes without applying them or prompting.
+	overrideDryRun = "dry-run"
+	// overrideFormat and overridePrompts set the format and prompts
+	// properties.
+	overrideFormat  = "format"
+	overridePrompts = "prompts"
+)
+
+// overridesFromCmdLine returns the overrides passed as build metadata in the
+// given Bazel command line.
+func overridesFromCmdLine(cmdLine []string) []string {
+	var overrides []string
+	for _, arg := range cmdLine {
+		value := strings.TrimPrefix(arg, "--build_metadata="+overridesMetadataKey+"=")
+		if value == arg {
+			continue
+		}
+		for _, override := range strings.Split(value, ",") {
+			if override = strings.TrimSpace(override); override != "" {
+				overrides = append(overrides, override)
+			}
+		}
+	}
+	return overrides
+}
+
+// splitOverrideFlags separates the override flags from the given arguments
+// of a custom command.
+func splitOverrideFlags(args []string) (rest, overrides []string) {
+	for _, arg := range args {
+		if override := strings.TrimPrefix(arg, overridesFlagPrefix); override != arg {
+			overrides = append(overrides, override)
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	return rest, overrides
+}
+
+// applyOverrides applies the overrides of the invocation on top of a copy of
+// the configured properties, which the next invocations start from again. It
+// returns whether the invocation is a dry run.
+func (plugin *FixVisibilityPlugin) applyOverrides() (bool, error) {
+	plugin.mu.Lock()
+	overrides := plugin.overrides
+	plugin.mu.Unlock()
+
+	if plugin.configured == nil {
+		plugin.configured = plugin.properties
+	}
+	properties := *plugin.configured
+	plugin.properties = &properties
+
+	dryRun := false
+	rule := strategyRule{Prefix: "//..."}
+	var ruleOverrides []string
+	for _, override := range overrides {
+		key, value, _ := strings.Cut(override, "=")
+		switch key {
+		case overrideDryRun:
+			dryRun = true
+		case overrideStrategy:
+			rule.Strategy = value
+			ruleOverrides = append(ruleOverrides, override)
+		case overridePackageGroup:
+			rule.PackageGroup = value
+			ruleOverrides = append(ruleOverrides, override)
+		case overrideFormat:
+			switch value {
+			case formatText, formatTable:
+				plugin.properties.Format = value
+			default:
+				return false, fmt.Errorf("invalid override %q: the format must be one of %q or %q", override, formatText, formatTable)
+			}
+		case overridePrompts:
+			switch value {
+			case promptsAuto, promptsNever, promptsCLI:
+				plugin.properties.Prompts = value
+			default:
+				return false, fmt.Errorf("invalid override %q: the prompts must be one of %q, %q or %q", override, promptsAuto, promptsNever, promptsCLI)
+			}
+		default:
+			return false, fmt.Errorf("invalid override %q: must be one of %s, %s=, %s=, %s= or %s=",
+				override, overrideDryRun, overrideStrategy, overridePackageGroup, overrideFormat, overridePrompts)
+		}
+	}
+
+	// The strategy overrides the strategies property for all the targets of
+	// the main repository.
+	if rule.Strategy != "" || rule.PackageGroup != "" {
+		if rule.Strategy == "" {
+			rule.Strategy = strategyPackageGroup
+		}
+		if err := rule.validate(); err != nil {
+			return false, fmt.Errorf("invalid override %q: %w", strings.Join(ruleOverrides, ","), err)
+		}
+		plugin.properties.Strategies = append([]strategyRule{rule}, plugin.properties.Strategies...)
+	}
+	if len(overrides) > 0 {
+		fmt.Fprintf(os.Stdout, "Overriding the configuration of the plugin with %s\n", strings.Join(overrides, ", "))
+	}
+	return dryRun, nil
+}
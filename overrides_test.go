@@ -0,0 +1,105 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"aspect.build/cli/bazel/buildeventstream"
+	aspectplugin "aspect.build/cli/pkg/plugin/sdk/v1alpha3/plugin"
+)
+
+func TestOverridesOnlyApplyToTheirInvocation(t *testing.T) {
+	workspaceDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(workspaceDir, "WORKSPACE"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	plugin := &FixVisibilityPlugin{
+		targetsToFix: &fixOrderedSet{nodes: make(map[fixKey]*fixNode)},
+	}
+	if err := plugin.Setup(&aspectplugin.SetupConfig{Properties: []byte("format: text\n")}); err != nil {
+		t.Fatal(err)
+	}
+	invocation := func(uuid string, cmdLine ...string) {
+		events := []*buildeventstream.BuildEvent{
+			{Payload: &buildeventstream.BuildEvent_Started{Started: &buildeventstream.BuildStarted{
+				Uuid:               uuid,
+				WorkspaceDirectory: workspaceDir,
+			}}},
+			{Payload: &buildeventstream.BuildEvent_OptionsParsed{OptionsParsed: &buildeventstream.OptionsParsed{
+				CmdLine: append([]string{"bazel", "build"}, cmdLine...),
+			}}},
+		}
+		for _, event := range events {
+			if err := plugin.BEPEventCallback(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := plugin.PostBuildHook(false, &scriptedPromptRunner{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	invocation("first", "--build_metadata=FIX_VISIBILITY=strategy=public,format=table")
+	if len(plugin.properties.Strategies) != 1 || plugin.properties.Format != formatTable {
+		t.Fatalf("properties = %+v, want the overridden strategy and format", plugin.properties)
+	}
+
+	invocation("second")
+	if len(plugin.properties.Strategies) != 0 || plugin.properties.Format != formatText {
+		t.Errorf("properties = %+v after an invocation without overrides, want the configured properties", plugin.properties)
+	}
+}
+
+func TestNextInvocationCollectsItsIssues(t *testing.T) {
+	plugin := &FixVisibilityPlugin{
+		targetsToFix: &fixOrderedSet{nodes: make(map[fixKey]*fixNode)},
+	}
+	if err := plugin.Setup(&aspectplugin.SetupConfig{Properties: []byte("hooks:\n  build: skip\n")}); err != nil {
+		t.Fatal(err)
+	}
+	for _, uuid := range []string{"first", "second"} {
+		started := &buildeventstream.BuildEvent{Payload: &buildeventstream.BuildEvent_Started{Started: &buildeventstream.BuildStarted{
+			Uuid:               uuid,
+			WorkspaceDirectory: t.TempDir(),
+		}}}
+		toFix := "//lib:" + uuid
+		issue := &buildeventstream.BuildEvent{Payload: &buildeventstream.BuildEvent_Aborted{Aborted: &buildeventstream.Aborted{
+			Reason:      buildeventstream.Aborted_ANALYSIS_FAILURE,
+			Description: "ERROR: in filegroup rule //app:app: target '" + toFix + "' is not visible from target '//app:app'",
+		}}}
+		for _, event := range []*buildeventstream.BuildEvent{started, issue} {
+			if err := plugin.BEPEventCallback(event); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if nodes := plugin.targetsToFix.list(); len(nodes) != 1 || nodes[0].toFix != toFix {
+			t.Fatalf("the %s invocation collected %v, want only %s", uuid, nodes, toFix)
+		}
+		if err := plugin.PostBuildHook(false, &scriptedPromptRunner{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInvalidOverrideNamesTheOverride(t *testing.T) {
+	plugin := &FixVisibilityPlugin{}
+	if err := plugin.Setup(&aspectplugin.SetupConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	plugin.overrides = []string{"strategy=package_group"}
+	_, err := plugin.applyOverrides()
+	if err == nil || !strings.Contains(err.Error(), `"strategy=package_group"`) {
+		t.Errorf("applyOverrides() = %v, want an error naming the override", err)
+	}
+}
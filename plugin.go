@@ -17,26 +17,33 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"aspect.build/cli/bazel/buildeventstream"
 	"aspect.build/cli/pkg/ioutils"
 	"aspect.build/cli/pkg/plugin/sdk/v1alpha3/config"
 	aspectplugin "aspect.build/cli/pkg/plugin/sdk/v1alpha3/plugin"
-	goplugin "github.com/hashicorp/go-plugin"
-	"github.com/manifoldco/promptui"
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/buildtools/edit"
+	goplugin "github.com/hashicorp/go-plugin"
 )
 
 // main starts up the plugin as a child process of the CLI and connects the gRPC communication.
 func main() {
 	goplugin.Serve(config.NewConfigFor(&FixVisibilityPlugin{
 		buildozer:    &buildozer{},
-		targetsToFix: &fixOrderedSet{nodes: make(map[fixNode]struct{})},
+		bazel:        &bazel{},
+		targetsToFix: &fixOrderedSet{nodes: make(map[fixKey]*fixNode)},
+		properties:   &pluginProperties{TestConsumers: testConsumersPackage},
+		openTerminal: openReviewTerminal,
 	}))
 }
 
@@ -45,17 +52,158 @@ type FixVisibilityPlugin struct {
 	aspectplugin.Base
 
 	buildozer    runner
+	bazel        runner
 	targetsToFix *fixOrderedSet
+	// properties are those of the invocation: the configured ones, with the
+	// overrides of the invocation applied.
+	properties *pluginProperties
+	configured *pluginProperties
+	// openTerminal opens the terminal of the full-screen review, which is
+	// driven by line commands when it's nil or fails.
+	openTerminal func() (*reviewTerminal, error)
+
+	// mu guards the state collected from the build events, which stops being
+	// collected once the post-build hook starts processing it. Once the hook
+	// is done, the next invocation served by the process starts afresh, see
+	// startInvocation.
+	mu             sync.Mutex
+	collected      bool
+	done           bool
+	unparsedIssues []unparsedIssue
+	// violations is the stream of the violations_file property, opened on the
+	// first violation.
+	violations *violationStream
+	// lag measures the time the build events are held by the plugin.
+	lag eventLag
+
+	// workspaceDir is the root of the workspace the build ran in, as reported by
+	// the BuildStarted event.
+	workspaceDir string
+	// invocationID is the ID of the build, as reported by the BuildStarted
+	// event.
+	invocationID string
+	// bazelColor is the value of the --color flag passed to Bazel, as reported
+	// by the OptionsParsed event.
+	bazelColor string
+	// overrides are the overrides of the configuration passed to the
+	// invocation, see overrides.go.
+	overrides []string
+	// rootModule is the MODULE.bazel file of the workspace the build ran in,
+	// read on the first canonical label of the issues, see apparentLabel.
+	rootModule *moduleFile
+
+	localRepositories localRepositoryPaths
+	codeowners        *codeowners
+	commandFile       *commandFile
+	table             *fixTable
+	metrics           *runMetrics
+	changelist        *changelist
+	warnings          warningLog
+	// edited are the files edited by the applied fixes, handed to the
+	// post_fix_commands.
+	edited editedFiles
+	// written are the hashes of the files the plugin wrote, as of its last
+	// write of each.
+	written map[string]string
+	// quarantined are the fixes blocked by the configuration.
+	quarantined []quarantined
+	// blockers counts the issues each failed top-level target is blocked by.
+	blockers map[string]int
+	// grants are the grants applied by the previous runs of the history, when
+	// the refactor_threshold property is set.
+	grants map[string]*grantRecurrence
+	// history is the record of the issues of the run, when the history_file or
+	// history_url property is set.
+	history *historyRecord
+	// state is the state handed off to the next invocation, when the
+	// persist_fixes property is set.
+	state *pluginState
+	// visibilities are the visibility attributes of the targets to fix read
+	// ahead of preparing the fixes, nil once they start being applied.
+	visibilities *visibilityCache
+	// debug is the debug log, nil unless the debug_log property is set.
+	debug *debugLog
+	// messages are the wordings of the interactive experience, resolved when
+	// the hook starts.
+	messages messages
+	// autoApply is set when the fixes are applied without asking, as
+	// configured for the hook by the hooks property.
+	autoApply bool
+	// applyRemaining and skipRemaining are set when the user answers yes,
+	// respectively no, to all the remaining fix prompts.
+	applyRemaining bool
+	skipRemaining  bool
 }
 
 const visibilityIssueSubstring = "is not visible from target"
 const removePrivateVisibilityBuildozerCommand = "remove visibility //visibility:private"
+
 var visibilityIssueRegex = regexp.MustCompile(fmt.Sprintf(`.*target '(.*)' %s '(.*)'.*`, visibilityIssueSubstring))
 
+// Setup satisfies the Plugin interface. It parses the properties configured for
+// this plugin in the .aspect/cli/plugins.yaml file.
+func (plugin *FixVisibilityPlugin) Setup(config *aspectplugin.SetupConfig) error {
+	properties, err := parsePluginProperties(config.Properties)
+	if err != nil {
+		return fmt.Errorf("failed to setup: %w", err)
+	}
+	plugin.properties = properties
+	plugin.configured = properties
+
+	canonicalLabels := properties.LabelStyle == labelStyleCanonical
+	switch {
+	case properties.BuildozerPath != "":
+		plugin.buildozer = &externalBuildozer{
+			path:            properties.BuildozerPath,
+			workspaceRoot:   plugin.workspaceRoot,
+			canonicalLabels: canonicalLabels,
+		}
+	case canonicalLabels:
+		plugin.buildozer = &buildozer{canonicalLabels: true}
+	}
+
+	// With the debug log enabled, every buildozer and bazel invocation made by
+	// the plugin is recorded, along with its output.
+	if properties.DebugLog {
+		plugin.debug = &debugLog{workspaceRoot: plugin.workspaceRoot}
+		plugin.buildozer = &loggedRunner{name: "buildozer", runner: plugin.buildozer, log: plugin.debug}
+		plugin.bazel = &loggedRunner{name: "bazel", runner: plugin.bazel, log: plugin.debug}
+	}
+	return nil
+}
+
 // BEPEventCallback satisfies the Plugin interface. It processes all the analysis
 // failures that represent a visibility issue, collecting them for later
 // processing in the post-build hook execution.
 func (plugin *FixVisibilityPlugin) BEPEventCallback(event *buildeventstream.BuildEvent) error {
+	start := time.Now()
+	defer func() { plugin.lag.observe(time.Since(start)) }()
+
+	// The workspace the build ran in is the one the labels refer to, which may
+	// not be the one found from the current directory. Like the issues, the
+	// state of the build is no longer updated once the post-build hook
+	// started, see collectIssue.
+	if started := event.GetStarted(); started != nil {
+		plugin.mu.Lock()
+		if started.GetUuid() != plugin.invocationID {
+			plugin.startInvocation()
+		}
+		if !plugin.collected {
+			plugin.workspaceDir = started.GetWorkspaceDirectory()
+			plugin.invocationID = started.GetUuid()
+		}
+		plugin.mu.Unlock()
+	}
+
+	if options := event.GetOptionsParsed(); options != nil {
+		plugin.mu.Lock()
+		if !plugin.collected {
+			plugin.bazelColor = bazelColor(options.GetCmdLine())
+			plugin.overrides = append(plugin.overrides, overridesFromCmdLine(options.GetCmdLine())...)
+		}
+		plugin.mu.Unlock()
+	}
+
 	// First, verify if the received event is of the type Aborted. The visibility
 	// issue events are emitted as ANALYSIS_FAILUE, so if there's an analysis
 	// failure and the description of the event contains the known-issue string,
@@ -66,159 +214,856 @@ func (plugin *FixVisibilityPlugin) BEPEventCallback(event *buildeventstream.Buil
 	if aborted != nil &&
 		aborted.Reason == buildeventstream.Aborted_ANALYSIS_FAILURE &&
 		strings.Contains(aborted.Description, visibilityIssueSubstring) {
-		matches := visibilityIssueRegex.FindStringSubmatch(aborted.Description)
-		if len(matches) == 3 {
-			// Here, we insert the matched targets in a linked list for processing
-			// in the post-build hook.
-			plugin.targetsToFix.insert(matches[1], matches[2])
+		return plugin.collectIssue(aborted.Description, topLevelTarget(event))
+	}
+	return nil
+}
+
+// startInvocation resets the state collected for the previous invocation,
+// once its hook is done, so that neither its issues nor its overrides carry
+// over to the next one. It must be called with plugin.mu held.
+func (plugin *FixVisibilityPlugin) startInvocation() {
+	if !plugin.done {
+		return
+	}
+	plugin.collected = false
+	plugin.done = false
+	plugin.unparsedIssues = nil
+	plugin.violations = nil
+	plugin.targetsToFix = &fixOrderedSet{nodes: make(map[fixKey]*fixNode)}
+	plugin.workspaceDir = ""
+	plugin.invocationID = ""
+	plugin.rootModule = nil
+	plugin.localRepositories = nil
+	plugin.bazelColor = ""
+	plugin.overrides = nil
+	plugin.lag.reset()
+}
+
+// collectIssue collects the visibility issue of the given error description,
+// along with the top-level target it was reported for, if known.
+func (plugin *FixVisibilityPlugin) collectIssue(description, topLevel string) error {
+	matches := visibilityIssueRegex.FindStringSubmatch(description)
+	plugin.debug.printf("visibility issue %q matched %q", description, matches)
+	if len(matches) != 3 {
+		return nil
+	}
+
+	// The CLI may still deliver events after the post-build hook started.
+	// Those are ignored, since the collected issues are being processed
+	// already.
+	plugin.mu.Lock()
+	defer plugin.mu.Unlock()
+	if plugin.collected {
+		return nil
+	}
+
+	// With Bzlmod, the labels of the other modules are reported with the
+	// canonical name of their repository, which the labels of the main
+	// repository don't use.
+	for i, captured := range matches[1:] {
+		matches[i+1] = plugin.apparentLabel(captured)
+	}
+
+	// The description may be truncated or wrapped, in which case the matched
+	// strings are not valid labels. Those are kept aside to be reported with
+	// suggestions in the post-build hook.
+	for _, captured := range matches[1:] {
+		if _, err := label.Parse(captured); err != nil {
+			plugin.unparsedIssues = append(plugin.unparsedIssues, unparsedIssue{
+				description: description,
+				captured:    captured,
+				err:         err,
+			})
+			return nil
 		}
 	}
+	// Here, we insert the matched targets in a linked list for processing
+	// in the post-build hook. New issues are also streamed right away, so
+	// that they can be triaged before the build finishes.
+	node, isNew := plugin.targetsToFix.insert(matches[1], matches[2], topLevel)
+	if isNew {
+		node.buildFileHash = detectionHash(plugin.workspaceDir, matches[1])
+	}
+	if resolution := resolutionOf(description); resolution != "" {
+		node.resolution = resolution
+	}
+	if attr, kind := implicitDependency(description); attr != "" {
+		node.implicitAttr, node.ruleKind = attr, kind
+	}
+	if isNew {
+		return plugin.streamViolation(matches[1], matches[2], topLevel)
+	}
 	return nil
 }
 
+// topLevelTarget returns the label of the top-level target the event refers
+// to, if any.
+func topLevelTarget(event *buildeventstream.BuildEvent) string {
+	if configured := event.GetId().GetTargetConfigured(); configured != nil {
+		return configured.GetLabel()
+	}
+	return event.GetId().GetTargetCompleted().GetLabel()
+}
+
 // PostBuildHook satisfies the Plugin interface. It prompts the user for
 // automatic fixes when in interactive mode. If the user rejects the automatic
 // fixes, or if running in non-interactive mode, the commands to perform the fixes
-// are printed to the terminal.
+// are printed to the terminal. The behavior can be changed by the hooks
+// property.
 func (plugin *FixVisibilityPlugin) PostBuildHook(
 	isInteractiveMode bool,
 	promptRunner ioutils.PromptRunner,
 ) error {
-	if plugin.targetsToFix.size == 0 {
+	return plugin.postHook(hookBuild, isInteractiveMode, promptRunner)
+}
+
+// postHook fixes the collected visibility issues after the given command, as
+// configured for it by the hooks property. The fixes are prepared, then
+// resolved, and the outcome is reported.
+func (plugin *FixVisibilityPlugin) postHook(
+	hook string,
+	isInteractiveMode bool,
+	promptRunner ioutils.PromptRunner,
+) (err error) {
+	// From here on, the collected state is owned by this hook, until it's
+	// done.
+	plugin.mu.Lock()
+	plugin.collected = true
+	violations := plugin.violations
+	plugin.mu.Unlock()
+	defer func() {
+		plugin.mu.Lock()
+		plugin.done = true
+		plugin.mu.Unlock()
+	}()
+
+	// Nor is the state of a previous hook served by the process carried over.
+	plugin.autoApply, plugin.applyRemaining, plugin.skipRemaining = false, false, false
+	plugin.commandFile, plugin.table, plugin.changelist, plugin.metrics = nil, nil, nil, nil
+	plugin.edited = editedFiles{}
+	plugin.quarantined = nil
+	plugin.grants = nil
+
+	// The fixes handed off by a previous invocation are processed along with
+	// the ones collected in this one. Those not resolved by the time the hook
+	// returns, however it does, are handed off to the next invocation.
+	plugin.state = nil
+	if plugin.properties.PersistFixes {
+		var workspaceRoot string
+		if workspaceRoot, err = plugin.workspaceRoot(); err != nil {
+			return fmt.Errorf("failed to fix visibility: %w", err)
+		}
+		if plugin.state, err = plugin.loadPendingFixes(workspaceRoot); err != nil {
+			return fmt.Errorf("failed to fix visibility: %w", err)
+		}
+		state := plugin.state
+		defer func() {
+			if saveErr := state.save(workspaceRoot); saveErr != nil && err == nil {
+				err = fmt.Errorf("failed to fix visibility: %w", saveErr)
+			}
+		}()
+	}
+
+	dryRun, err := plugin.applyOverrides()
+	if err != nil {
+		return err
+	}
+	isInteractiveMode, skip := plugin.hookPromptMode(hook, dryRun, isInteractiveMode)
+	if skip {
 		return nil
 	}
+	if isInteractiveMode && plugin.plainOutput() {
+		promptRunner = newLinePromptRunner(os.Stdin, os.Stdout)
+	}
 
-	// For each collected visibility issue...
-	for node := plugin.targetsToFix.head; node != nil; node = node.next {
-		// ... we construct the label for the target we want to add to the target
-		// being fixed.
-		fromLabel, err := label.Parse(node.from)
-		if err != nil {
+	if violations != nil {
+		if err := violations.close(); err != nil {
 			return fmt.Errorf("failed to fix visibility: %w", err)
 		}
-		fromLabel.Name = "__pkg__"
+	}
+	lag := plugin.lag.stats()
+	plugin.debug.printf("build events: %s", lag)
+	if lag.stalled > 0 {
+		plugin.warnings.warnf("The build events were held for %s while the violations file caught up\n", lag.stalled.Round(time.Millisecond))
+	}
+
+	// The repeated warnings are summarized once all the fixes are handled.
+	defer plugin.warnings.flush()
+
+	// Failing to push the metrics doesn't fail the hook.
+	if plugin.properties.MetricsURL != "" {
+		plugin.metrics = newRunMetrics()
+		defer func() {
+			plugin.metrics.events = plugin.lag.stats()
+			workspaceRoot, _ := plugin.workspaceRoot()
+			repo := plugin.redactRepository(filepath.Base(workspaceRoot))
+			if pushErr := plugin.metrics.push(plugin.properties.MetricsURL, repo, hook, err); pushErr != nil {
+				plugin.warnings.warnf("Could not push the metrics: %v\n", pushErr)
+			}
+		}()
+	}
+
+	start := time.Now()
+	defer func() {
+		plugin.debug.printf("post-build hook took %s", time.Since(start))
+		plugin.debug.close()
+	}()
+
+	plugin.reportUnparsedIssues()
+
+	workspaceRoot, err := plugin.workspaceRoot()
+	if err != nil {
+		return fmt.Errorf("failed to fix visibility: %w", err)
+	}
+	if err := plugin.loadMessages(workspaceRoot); err != nil {
+		return fmt.Errorf("failed to fix visibility: %w", err)
+	}
+
+	nodes := plugin.targetsToFix.list()
+	if len(nodes) == 0 {
+		return nil
+	}
+	plugin.metrics.countIssues(len(nodes))
+
+	var results *buildResults
+	if plugin.properties.ResultsURL != "" {
+		results = &buildResults{
+			InvocationID: plugin.invocationID,
+			redact:       plugin.redact,
+		}
+	}
+
+	fixes, err := plugin.prepareFixes(hook, workspaceRoot, nodes)
+	if err != nil {
+		return err
+	}
+	if err := plugin.resolveFixes(fixes, workspaceRoot, results, isInteractiveMode, promptRunner); err != nil {
+		return err
+	}
+	return plugin.reportFixes(workspaceRoot, results, lag, isInteractiveMode, promptRunner)
+}
+
+// hookPromptMode returns whether the fixes are prompted for after the given
+// command, as configured by the hooks property, or whether the hook is
+// skipped altogether.
+func (plugin *FixVisibilityPlugin) hookPromptMode(hook string, dryRun, isInteractiveMode bool) (interactive, skip bool) {
+	// A dry run prints the fixes instead of applying them, but doesn't run
+	// the hooks set to skip.
+	mode := plugin.hookMode(hook)
+	if dryRun && mode != hookSkip {
+		mode = hookPrint
+	}
+	switch mode {
+	case hookSkip:
+		return false, true
+	case hookPrint:
+		return false, false
+	case hookAuto:
+		plugin.autoApply = true
+	}
+
+	// Prompting in CI would hang the job, so the fixes are printed or applied
+	// as configured instead.
+	if isInteractiveMode {
+		if suppress, reason := plugin.suppressPrompts(); suppress {
+			fmt.Fprintf(os.Stdout, "Not prompting for the visibility fixes: %s\n", reason)
+			return false, false
+		}
+	}
+	return isInteractiveMode, false
+}
+
+// prepareFixes computes the fixes of the given nodes and of the issues they
+// stand for, combined by target and in the order they're resolved in.
+func (plugin *FixVisibilityPlugin) prepareFixes(hook, workspaceRoot string, nodes []*fixNode) ([]*visibilityFix, error) {
+	if plugin.properties.CommandFile != "" {
+		plugin.commandFile = &commandFile{}
+	}
+
+	if plugin.properties.Format == formatTable {
+		plugin.table = &fixTable{}
+	}
+
+	if plugin.properties.ChangelistFile != "" {
+		plugin.changelist = &changelist{}
+	}
+
+	plugin.history = nil
+	if plugin.properties.HistoryFile != "" || plugin.properties.HistoryURL != "" {
+		plugin.history = plugin.newHistoryRecord(hook, nodes)
+	}
 
-		// We need to verify if the target being fixed contains //visibility:private,
-		// otherwise Bazel will yell at us since we will need to remove it to add
-		// any package to the visibility attribute.
-		hasPrivateVisibility, err := plugin.hasPrivateVisibility(node.toFix)
+	// The issues hidden behind a target forwarding others, e.g. an alias, are
+	// collected too, so that the complete set of grants is proposed.
+	for i := 0; i < len(nodes); i++ {
+		forwarded, err := plugin.forwardedIssues(nodes[i])
 		if err != nil {
-			return fmt.Errorf("failed to fix visibility: %w", err)
+			return nil, fmt.Errorf("failed to fix visibility: %w", err)
 		}
+		nodes = append(nodes, forwarded...)
+		plugin.metrics.countIssues(len(forwarded))
+	}
 
-		// We check whether it's running in interactive mode, if so, send a request
-		// to prompt the user using the promptRunner injected by the CLI core in
-		// this method.
-		var applyFix bool
-		if isInteractiveMode {
-			applyFixPrompt := promptui.Prompt{
-				Label:     "Would you like to auto-fix to the visibility attribute",
-				IsConfirm: true,
-			}
-			_, err := promptRunner.Run(applyFixPrompt)
-			// Since the prompt is a boolean, any non-nil error should represent a NO.
-			applyFix = err == nil
+	// The consumers which are test_suites are resolved to their tests.
+	nodes, err := plugin.expandSuites(nodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fix visibility: %w", err)
+	}
+
+	plugin.blockers = blockingIssues(nodes)
+
+	// The grants of the previous runs are only known from the history.
+	if plugin.properties.RefactorThreshold > 0 {
+		if plugin.properties.HistoryFile == "" {
+			plugin.warnings.warnf("The refactor_threshold property requires the history_file property, no restructuring is suggested\n")
+		} else if plugin.grants, err = loadGrants(plugin.historyPath(workspaceRoot)); err != nil {
+			plugin.warnings.warnf("Could not read the history: %v\n", err)
 		}
+	}
 
-		// Here we either perform the fix automatically, or print the commands for
-		// the user to perform the fixes manually.
-		addVisibilityBuildozerCommand := fmt.Sprintf("add visibility %s", fromLabel)
-		if applyFix {
-			if _, err := plugin.buildozer.run(addVisibilityBuildozerCommand, node.toFix); err != nil {
-				return fmt.Errorf("failed to fix visibility: %w", err)
+	// For each collected visibility issue, we compute the edits to fix it,
+	// with the visibility of the targets read ahead at once.
+	plugin.prefetchVisibility(nodes)
+	var fixes []*visibilityFix
+	for _, node := range nodes {
+		fix, err := plugin.prepareFix(node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fix visibility: %w", err)
+		}
+		if fix != nil {
+			fixes = append(fixes, fix)
+		}
+	}
+
+	plugin.dropVisibilityCache()
+
+	// The fixes of the same target are combined, the most severe first so
+	// that reviewers focus on the risky ones, and those of deprecated targets
+	// last.
+	if fixes, err = mergeFixes(fixes); err != nil {
+		return nil, fmt.Errorf("failed to fix visibility: %w", err)
+	}
+	sortBySeverity(fixes)
+	sortDeprecatedLast(fixes)
+	return fixes, nil
+}
+
+// resolveFixes either applies the given fixes or prints them for the user,
+// recording the outcome of each of them.
+func (plugin *FixVisibilityPlugin) resolveFixes(
+	fixes []*visibilityFix,
+	workspaceRoot string,
+	results *buildResults,
+	isInteractiveMode bool,
+	promptRunner ioutils.PromptRunner,
+) error {
+	// Past the review_threshold, the user reviews them all at once rather
+	// than being asked about each in turn.
+	if isInteractiveMode && !plugin.autoApply && plugin.properties.ReviewThreshold > 0 &&
+		len(fixes) >= plugin.properties.ReviewThreshold {
+		plugin.reviewFixes(fixes, promptRunner)
+	}
+
+	// Past the batch_size, the fixes are handled in batches, pausing between
+	// them in interactive mode, e.g. to commit each batch on its own. The
+	// remaining fixes are printed when the user stops.
+	batches := plugin.batchFixes(fixes)
+	for i, batch := range batches {
+		if len(batches) > 1 {
+			batch.printHeader(i, len(batches))
+		}
+		for _, fix := range batch.fixes {
+			resolveStart := time.Now()
+			status, err := plugin.resolveFix(fix, isInteractiveMode, promptRunner)
+			if err != nil {
+				return err
+			}
+			plugin.metrics.countFixes(status, len(fix.constituents()), time.Since(resolveStart))
+			batch.count(status, len(fix.constituents()))
+			unblocked, _ := plugin.impactOf(fix)
+			if status == fixApplied {
+				plugin.unblock(fix)
 			}
-			if hasPrivateVisibility {
-				if _, err := plugin.buildozer.run(removePrivateVisibilityBuildozerCommand, node.toFix); err != nil {
-					return fmt.Errorf("failed to fix visibility: %w", err)
+			for _, f := range fix.constituents() {
+				if results != nil {
+					results.add(f, status, unblocked)
+				}
+				plugin.resolveIssue(f.node, fixStatusNames[status])
+				if plugin.table != nil {
+					plugin.table.add(workspaceRoot, f, status)
+				}
+				if plugin.changelist != nil && status == fixApplied {
+					plugin.changelist.add(workspaceRoot, plugin.changelistPath(workspaceRoot), f)
 				}
 			}
-		} else {
-			fmt.Fprintf(os.Stdout, "To fix the visibility errors, run:\n")
-			fmt.Fprintf(os.Stdout, "buildozer '%s' %s\n", addVisibilityBuildozerCommand, node.toFix)
-			if hasPrivateVisibility {
-				fmt.Fprintf(os.Stdout, "buildozer '%s' %s\n", removePrivateVisibilityBuildozerCommand, node.toFix)
+		}
+		if len(batches) > 1 {
+			batch.printSummary(i)
+			if isInteractiveMode && !plugin.skipRemaining && i+1 < len(batches) &&
+				!plugin.continueBatches(i+1, len(batches), promptRunner) {
+				plugin.skipRemaining = true
 			}
 		}
 	}
+	return nil
+}
+
+// reportFixes hands the outcome of the resolved fixes to the user and to the
+// configured outputs, and runs the post_fix_commands over the edited files.
+func (plugin *FixVisibilityPlugin) reportFixes(
+	workspaceRoot string,
+	results *buildResults,
+	lag eventLagStats,
+	isInteractiveMode bool,
+	promptRunner ioutils.PromptRunner,
+) error {
+	if plugin.table != nil && len(plugin.table.rows) > 0 {
+		plugin.table.render(os.Stdout)
+	}
+	plugin.printQuarantine(os.Stdout)
+	if plugin.properties.DebugLog {
+		fmt.Fprintf(os.Stdout, "Build events: %s\n", lag)
+	}
+
+	// Failing to publish the results doesn't prevent the fixes from being
+	// handed to the user.
+	if results != nil {
+		results.addQuarantined(plugin.quarantined)
+	}
+	if results != nil && (len(results.Fixes) > 0 || len(results.Quarantined) > 0) {
+		if err := results.publish(plugin.properties.ResultsURL); err != nil {
+			plugin.warnings.warnf("Could not publish the fix results: %v\n", err)
+		}
+	}
+
+	// The post_fix_commands keep the invariants of the edited files, e.g. by
+	// running a formatter or a BUILD file generator over them.
+	if len(plugin.properties.PostFixCommands) > 0 && len(plugin.edited.paths) > 0 {
+		if err := runFixCommands(plugin.properties.PostFixCommands, workspaceRoot, plugin.edited.paths); err != nil {
+			plugin.warnings.warnf("The post_fix_commands failed: %v\n", err)
+		}
+	}
+
+	plugin.offerEditor(workspaceRoot, isInteractiveMode, promptRunner)
+
+	if plugin.history != nil {
+		if err := plugin.saveHistory(workspaceRoot, plugin.history); err != nil {
+			plugin.warnings.warnf("Could not record the history: %v\n", err)
+		}
+	}
+
+	if plugin.commandFile != nil && len(plugin.commandFile.lines) > 0 {
+		path, err := plugin.commandFile.write(workspaceRoot, plugin.properties.CommandFile)
+		if err != nil {
+			return fmt.Errorf("failed to fix visibility: %w", err)
+		}
+		fmt.Fprintf(os.Stdout, "%s\nbuildozer -f %s\n", strings.ReplaceAll(plugin.messages.CommandFileWritten, "{path}", path), path)
+	}
+
+	if plugin.changelist != nil && len(plugin.changelist.targets) > 0 {
+		path := plugin.changelistPath(workspaceRoot)
+		if err := plugin.changelist.write(path); err != nil {
+			return fmt.Errorf("failed to fix visibility: %w", err)
+		}
+		fmt.Fprintf(os.Stdout, "The description of the applied fixes was written to %s\n", path)
+	}
 
 	return nil
 }
 
-// PostTestHook satisfies the Plugin interface. In this case, it just calls the
-// PostBuildHook.
+// hookMode returns the behavior configured for the given hook.
+func (plugin *FixVisibilityPlugin) hookMode(hook string) string {
+	if mode, ok := plugin.properties.Hooks[hook]; ok {
+		return mode
+	}
+	return hookPrompt
+}
+
+// PostTestHook satisfies the Plugin interface. It behaves like the
+// PostBuildHook, unless configured otherwise for test by the hooks property.
 func (plugin *FixVisibilityPlugin) PostTestHook(
 	isInteractiveMode bool,
 	promptRunner ioutils.PromptRunner,
 ) error {
-	return plugin.PostBuildHook(isInteractiveMode, promptRunner)
+	return plugin.postHook(hookTest, isInteractiveMode, promptRunner)
 }
 
-// PostRunHook satisfies the Plugin interface. In this case, it just calls the
-// PostBuildHook.
+// PostRunHook satisfies the Plugin interface. It behaves like the
+// PostBuildHook, unless configured otherwise for run by the hooks property.
 func (plugin *FixVisibilityPlugin) PostRunHook(
 	isInteractiveMode bool,
 	promptRunner ioutils.PromptRunner,
 ) error {
-	return plugin.PostBuildHook(isInteractiveMode, promptRunner)
+	return plugin.postHook(hookRun, isInteractiveMode, promptRunner)
+}
+
+// visibilityAttr is the current visibility attribute of a target.
+type visibilityAttr struct {
+	// entries are the entries of the attribute when it is a literal list.
+	entries []string
+	// literal is whether the attribute is a literal list (or missing).
+	literal bool
+	// value is the attribute value as printed by buildozer.
+	value string
+}
+
+// missing returns whether the target has no visibility attribute.
+func (v *visibilityAttr) missing() bool {
+	return v.value == "" || v.value == "(missing)"
+}
+
+// currentVisibility returns the visibility attribute of the target being
+// fixed, as read ahead by prefetchVisibility if it was.
+func (plugin *FixVisibilityPlugin) currentVisibility(toFix string) (*visibilityAttr, error) {
+	if attr, ok := plugin.visibilities.get(toFix); ok {
+		return attr, nil
+	}
+	return plugin.readVisibility(toFix)
 }
 
-func (plugin *FixVisibilityPlugin) hasPrivateVisibility(toFix string) (bool, error) {
+// readVisibility reads the visibility attribute of the given target with
+// buildozer.
+func (plugin *FixVisibilityPlugin) readVisibility(toFix string) (*visibilityAttr, error) {
 	visibility, err := plugin.buildozer.run("print visibility", toFix)
 	if err != nil {
-		return false, fmt.Errorf("failed to check if target has private visibility: %w", err)
+		return nil, fmt.Errorf("failed to get the current visibility: %w", err)
+	}
+	entries, literal := parseVisibilityList(visibility)
+	return &visibilityAttr{
+		entries: entries,
+		literal: literal,
+		value:   strings.TrimSpace(string(visibility)),
+	}, nil
+}
+
+// verifyTarget checks that buildozer finds the rule of the given target in
+// its BUILD file, returning an unfixableError when it doesn't, e.g. because
+// the target was deleted, renamed, or is generated by a macro.
+func (plugin *FixVisibilityPlugin) verifyTarget(target string) error {
+	if _, err := plugin.buildozer.run("print label", target); err != nil {
+		buildFile, _, _ := edit.InterpretLabelForWorkspaceLocation("", target)
+		return &unfixableError{reason: fmt.Sprintf("its rule was not found in %s, it may have been deleted, renamed or be generated by a macro", buildFile)}
 	}
-	return bytes.Contains(visibility, []byte("//visibility:private")), nil
+	return nil
 }
 
+// isTestTarget returns whether the given target is a test rule or is marked
+// as testonly. Targets whose BUILD files can't be edited are assumed not to be.
+func (plugin *FixVisibilityPlugin) isTestTarget(l label.Label) (bool, error) {
+	target, err := plugin.buildozerTarget(l)
+	var unfixable *unfixableError
+	if errors.As(err, &unfixable) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	output, err := plugin.buildozer.run("print kind testonly", target)
+	if err != nil {
+		return false, fmt.Errorf("failed to check if target is a test: %w", err)
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return false, nil
+	}
+	kind, testonly := fields[0], fields[1]
+	return strings.HasSuffix(kind, "_test") || kind == "test_suite" || testonly == "True", nil
+}
+
+// packageSpec returns the package_group specification matching exactly the
+// package of the given label, e.g. //foo/bar for //foo/bar:baz, or the package
+// and its subpackages for a __subpackages__ label, e.g. //foo/bar/...
+func packageSpec(l label.Label) string {
+	spec := fmt.Sprintf("//%s", l.Pkg)
+	if l.Repo != "" && l.Repo != "@" {
+		spec = fmt.Sprintf("@%s//%s", l.Repo, l.Pkg)
+	}
+	if l.Name == "__subpackages__" {
+		spec = strings.TrimSuffix(spec, "/") + "/..."
+	}
+	return spec
+}
+
+// fixOrderedSet is the insertion-ordered set of the visibility issues to fix.
+// It is safe for concurrent use.
 type fixOrderedSet struct {
+	mu    sync.Mutex
 	head  *fixNode
 	tail  *fixNode
-	nodes map[fixNode]struct{}
+	nodes map[fixKey]*fixNode
 	size  int
 }
 
-func (s *fixOrderedSet) insert(toFix, from string) {
-	node := fixNode{
+// insert adds the visibility issue to the set, unless it's already there. The
+// consumers are keyed by package, since the grants of the consumers of the
+// same package are the same: a consumer in the package of one already there,
+// e.g. //app:tests after //app:app, is folded into its issue. The top-level
+// target whose analysis failed because of the issue, if known, is recorded
+// along with it. It returns the node of the issue and whether it's new.
+func (s *fixOrderedSet) insert(toFix, from, topLevelTarget string) (*fixNode, bool) {
+	key := fixKey{
 		toFix: toFix,
-		from:  from,
+		from:  consumerPackage(from),
 	}
 
-	if _, exists := s.nodes[node]; !exists {
-		s.nodes[node] = struct{}{}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, exists := s.nodes[key]
+	if !exists {
+		node = &fixNode{
+			toFix: toFix,
+			from:  from,
+		}
+		s.nodes[key] = node
 		if s.head == nil {
-			s.head = &node
+			s.head = node
 		} else {
-			s.tail.next = &node
+			s.tail.next = node
 		}
-		s.tail = &node
+		s.tail = node
 		s.size++
+	} else if from != node.from && !node.isFolded(from) {
+		node.folded = append(node.folded, from)
 	}
+	if topLevelTarget != "" {
+		for _, t := range node.topLevelTargets {
+			if t == topLevelTarget {
+				return node, !exists
+			}
+		}
+		node.topLevelTargets = append(node.topLevelTargets, topLevelTarget)
+	}
+	return node, !exists
+}
+
+// remove removes the given node from the set, e.g. once replaced by others.
+func (s *fixOrderedSet) remove(node *fixNode) {
+	key := fixKey{
+		toFix: node.toFix,
+		from:  consumerPackage(node.from),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.nodes[key] != node {
+		return
+	}
+	delete(s.nodes, key)
+	var prev *fixNode
+	for n := s.head; n != nil; prev, n = n, n.next {
+		if n != node {
+			continue
+		}
+		if prev == nil {
+			s.head = n.next
+		} else {
+			prev.next = n.next
+		}
+		if s.tail == n {
+			s.tail = prev
+		}
+		s.size--
+		return
+	}
+}
+
+// list returns a snapshot of the nodes in insertion order.
+func (s *fixOrderedSet) list() []*fixNode {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	nodes := make([]*fixNode, 0, s.size)
+	for node := s.head; node != nil; node = node.next {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// isTopLevel returns whether the given top-level target failed because of the
+// issue.
+func (node *fixNode) isTopLevel(target string) bool {
+	for _, t := range node.topLevelTargets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+// isFolded returns whether the given consumer is folded into the issue.
+func (node *fixNode) isFolded(from string) bool {
+	for _, f := range node.folded {
+		if f == from {
+			return true
+		}
+	}
+	return false
+}
+
+// consumerPackage returns the package of the given consumer label, which
+// identifies the consumer in the fixOrderedSet, or the label itself if it
+// can't be parsed.
+func consumerPackage(from string) string {
+	l, err := label.Parse(from)
+	if err != nil {
+		return from
+	}
+	return packageSpec(l)
+}
+
+// fixKey identifies a visibility issue in the fixOrderedSet.
+type fixKey struct {
+	toFix string
+	from  string
 }
 
 type fixNode struct {
 	next  *fixNode
 	toFix string
 	from  string
+	// topLevelTargets are the top-level targets whose analysis failed because
+	// of the issue.
+	topLevelTargets []string
+	// resolution is the kind of resolution which reported the issue, e.g. of
+	// the toolchains, empty for a dependency of a target.
+	resolution string
+	// buildFileHash is the hash of the BUILD file of the target to fix when
+	// the issue was detected, if known.
+	buildFileHash string
+	// folded are the other consumers in the package of from that can't see
+	// the target to fix either, whose issues are fixed along.
+	folded []string
+	// implicitAttr is the implicit attribute of the consumer rule of the kind
+	// ruleKind through which it depends on the target to fix, if the issue
+	// was reported for one.
+	implicitAttr string
+	ruleKind     string
+	// origins are the detected issues the node was derived from, e.g. when
+	// forwarded by an alias or expanded from a test_suite, which its outcome
+	// resolves in the history.
+	origins []fixKey
+}
+
+// edges returns the detected issues the node stands for: its own, those of
+// the consumers folded into it, and its origins.
+func (node *fixNode) edges() []fixKey {
+	edges := []fixKey{{toFix: node.toFix, from: node.from}}
+	for _, from := range node.folded {
+		edges = append(edges, fixKey{toFix: node.toFix, from: from})
+	}
+	return append(edges, node.origins...)
+}
+
+// buildozerCommand is a single buildozer command to be run against a target.
+type buildozerCommand struct {
+	command string
+	target  string
 }
 
 type runner interface {
 	run(args ...string) ([]byte, error)
 }
 
-type buildozer struct{}
+type buildozer struct {
+	// canonicalLabels keeps buildozer from shortening the labels it writes.
+	canonicalLabels bool
+}
+
+// buildozerFlagsMu guards the global flags of the buildozer library. They are
+// only written when they change, so that concurrent runs with the same flags
+// don't race.
+var buildozerFlagsMu sync.Mutex
+
+// setBuildozerFlags sets the global flags of the buildozer library for a run
+// writing canonical labels or not.
+func setBuildozerFlags(canonicalLabels bool) {
+	buildozerFlagsMu.Lock()
+	defer buildozerFlagsMu.Unlock()
+	if edit.ShortenLabelsFlag != !canonicalLabels {
+		edit.ShortenLabelsFlag = !canonicalLabels
+	}
+	if !edit.DeleteWithComments {
+		edit.DeleteWithComments = true
+	}
+}
 
 func (b *buildozer) run(args ...string) ([]byte, error) {
 	var stdout bytes.Buffer
 	var stderr strings.Builder
-	edit.ShortenLabelsFlag = true
-	edit.DeleteWithComments = true
+	setBuildozerFlags(b.canonicalLabels)
 	opts := &edit.Options{
 		OutWriter: &stdout,
 		ErrWriter: &stderr,
 		NumIO:     200,
 	}
-	if ret := edit.Buildozer(opts, args); ret != 0 {
-		return stdout.Bytes(), fmt.Errorf("failed to run buildozer: exit code %d: %s", ret, stderr.String())
+	ret := edit.Buildozer(opts, args)
+	return stdout.Bytes(), buildozerError(ret, stderr.String())
+}
+
+// externalBuildozer runs the buildozer binary at the given path, e.g. to use
+// the version pinned by the repository, instead of the in-process library.
+type externalBuildozer struct {
+	path          string
+	workspaceRoot func() (string, error)
+	// canonicalLabels keeps buildozer from shortening the labels it writes.
+	canonicalLabels bool
+}
+
+func (b *externalBuildozer) run(args ...string) ([]byte, error) {
+	path := b.path
+	if strings.ContainsRune(path, filepath.Separator) && !filepath.IsAbs(path) {
+		workspaceRoot, err := b.workspaceRoot()
+		if err != nil {
+			return nil, fmt.Errorf("failed to run buildozer: %w", err)
+		}
+		path = filepath.Join(workspaceRoot, path)
+	}
+	var stdout bytes.Buffer
+	var stderr strings.Builder
+	// The arguments are passed as is, without a shell, so the commands need
+	// no quoting.
+	flags := []string{"-delete_with_comments"}
+	if !b.canonicalLabels {
+		flags = append(flags, "-shorten_labels")
+	}
+	cmd := exec.Command(path, append(flags, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return stdout.Bytes(), buildozerError(exitErr.ExitCode(), stderr.String())
+	}
+	if err != nil {
+		return stdout.Bytes(), fmt.Errorf("failed to run buildozer: %w", err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// buildozerError maps the exit code of buildozer to an error. The exit code 3
+// means the commands succeeded without modifying any file, e.g. when adding
+// an entry already there.
+func buildozerError(ret int, stderr string) error {
+	switch ret {
+	case 0, 3:
+		return nil
+	case 1:
+		return fmt.Errorf("failed to run buildozer: invalid command: %s", stderr)
+	default:
+		return fmt.Errorf("failed to run buildozer: exit code %d: %s", ret, stderr)
+	}
+}
+
+type bazel struct{}
+
+func (b *bazel) run(args ...string) ([]byte, error) {
+	var stdout bytes.Buffer
+	var stderr strings.Builder
+	cmd := exec.Command("bazel", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return stdout.Bytes(), fmt.Errorf("failed to run bazel: %w: %s", err, stderr.String())
 	}
 	return stdout.Bytes(), nil
 }
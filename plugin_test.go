@@ -0,0 +1,169 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	aspectplugin "aspect.build/cli/pkg/plugin/sdk/v1alpha3/plugin"
+)
+
+// TestEventsDuringPostBuildHook delivers build events while the post-build
+// hook runs, as the CLI may, which the race detector checks the plugin
+// guards against.
+func TestEventsDuringPostBuildHook(t *testing.T) {
+	workspaceDir := t.TempDir()
+	if err := copyDir("testdata/e2e/outoforder/workspace", workspaceDir); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Buildozer resolves the labels against the current directory.
+	if err := os.Chdir(workspaceDir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	plugin := &FixVisibilityPlugin{
+		buildozer:    &buildozer{},
+		bazel:        &scriptedRunner{name: "bazel"},
+		targetsToFix: &fixOrderedSet{nodes: make(map[fixKey]*fixNode)},
+	}
+	if err := plugin.Setup(&aspectplugin.SetupConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	issue := func(toFix string) e2eEvent {
+		return e2eEvent{
+			Target: "//app:app",
+			Aborted: fmt.Sprintf("ERROR: /workspace/app/BUILD.bazel:1:10: in filegroup rule //app:app: target '%s' is not visible "+
+				"from target '//app:app'. Check the visibility declaration of the former target if you think the dependency is legitimate", toFix),
+		}
+	}
+	for _, e := range []e2eEvent{{Started: "build"}, issue("//lib:a")} {
+		if err := plugin.BEPEventCallback(e.buildEvent(workspaceDir)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		events := []e2eEvent{
+			{CmdLine: []string{"bazel", "build", "--color=no", "//app"}},
+			issue("//lib:b"),
+			issue("//lib:a"),
+			{Started: "build"},
+		}
+		for i := 0; i < 100; i++ {
+			if err := plugin.BEPEventCallback(events[i%len(events)].buildEvent(workspaceDir)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	if err := plugin.PostBuildHook(false, &scriptedPromptRunner{}); err != nil {
+		t.Error(err)
+	}
+	wg.Wait()
+}
+
+// fakeBuildozer is a buildozer binary printing its arguments, one per line,
+// and exiting with the code set by the EXIT_CODE environment variable.
+const fakeBuildozer = `#!/bin/sh
+for arg in "$@"; do echo "$arg"; done
+echo "stderr output" >&2
+exit ${EXIT_CODE:-0}
+`
+
+func TestExternalBuildozer(t *testing.T) {
+	workspaceRoot := t.TempDir()
+	path := filepath.Join(workspaceRoot, "tools", "buildozer")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(fakeBuildozer), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name            string
+		path            string
+		canonicalLabels bool
+		exitCode        string
+		output          string
+		err             string
+	}{
+		{"relative path", "tools/buildozer", false, "0", "-delete_with_comments\n-shorten_labels\nadd visibility //app:__pkg__\n//lib:a\n", ""},
+		{"absolute path", path, false, "0", "-delete_with_comments\n-shorten_labels\nadd visibility //app:__pkg__\n//lib:a\n", ""},
+		{"canonical labels", path, true, "0", "-delete_with_comments\nadd visibility //app:__pkg__\n//lib:a\n", ""},
+		{"no changes", path, false, "3", "-delete_with_comments\n-shorten_labels\nadd visibility //app:__pkg__\n//lib:a\n", ""},
+		{"invalid command", path, false, "1", "", "invalid command: stderr output"},
+		{"failure", path, false, "2", "", "exit code 2: stderr output"},
+		{"missing binary", "tools/missing", false, "0", "", "failed to run buildozer"},
+	} {
+		t.Setenv("EXIT_CODE", test.exitCode)
+		b := &externalBuildozer{
+			path:            test.path,
+			workspaceRoot:   func() (string, error) { return workspaceRoot, nil },
+			canonicalLabels: test.canonicalLabels,
+		}
+		output, err := b.run("add visibility //app:__pkg__", "//lib:a")
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: run() failed with %v, want %q", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: run() failed: %v", test.name, err)
+			continue
+		}
+		if string(output) != test.output {
+			t.Errorf("%s: run() = %q, want %q", test.name, output, test.output)
+		}
+	}
+}
+
+func TestHookPromptMode(t *testing.T) {
+	hooks := map[string]string{hookTest: hookAuto, hookRun: hookPrint, hookCquery: hookSkip}
+	for _, test := range []struct {
+		hook        string
+		dryRun      bool
+		interactive bool
+		prompts     string
+		want        bool
+		skip        bool
+		autoApply   bool
+	}{
+		{hookBuild, false, true, promptsCLI, true, false, false},
+		{hookBuild, false, false, promptsCLI, false, false, false},
+		{hookBuild, false, true, promptsNever, false, false, false},
+		{hookBuild, true, true, promptsCLI, false, false, false},
+		{hookTest, false, true, promptsCLI, true, false, true},
+		{hookTest, true, true, promptsCLI, false, false, false},
+		{hookRun, false, true, promptsCLI, false, false, false},
+		{hookCquery, false, true, promptsCLI, false, true, false},
+		{hookCquery, true, true, promptsCLI, false, true, false},
+	} {
+		plugin := &FixVisibilityPlugin{properties: &pluginProperties{Hooks: hooks, Prompts: test.prompts}}
+		interactive, skip := plugin.hookPromptMode(test.hook, test.dryRun, test.interactive)
+		if interactive != test.want || skip != test.skip || plugin.autoApply != test.autoApply {
+			t.Errorf("hookPromptMode(%s, %t, %t) with the %s prompts = %t, %t and auto-applying %t, want %t, %t and auto-applying %t",
+				test.hook, test.dryRun, test.interactive, test.prompts, interactive, skip, plugin.autoApply, test.want, test.skip, test.autoApply)
+		}
+	}
+}
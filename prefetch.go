@@ -0,0 +1,96 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"sync"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// prefetchWorkers bounds the number of visibility attributes read at once.
+const prefetchWorkers = 8
+
+// visibilityCache holds the visibility attributes of the targets to fix, read
+// ahead of preparing the fixes.
+type visibilityCache struct {
+	mu    sync.Mutex
+	attrs map[string]*visibilityAttr
+}
+
+// get returns the cached visibility of the given target, if any. It's nil-safe.
+func (c *visibilityCache) get(target string) (*visibilityAttr, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	attr, ok := c.attrs[target]
+	return attr, ok
+}
+
+func (c *visibilityCache) set(target string, attr *visibilityAttr) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.attrs[target] = attr
+}
+
+// prefetchVisibility reads the visibility attributes of the targets of the
+// given issues concurrently, so that preparing the fixes, which checks them
+// one at a time, doesn't wait on buildozer parsing each BUILD file in turn.
+// The targets buildozer can't be pointed at, and the reads which fail, are
+// left to prepareFix to report. The cache only holds until the fixes start
+// being applied, see dropVisibilityCache.
+func (plugin *FixVisibilityPlugin) prefetchVisibility(nodes []*fixNode) {
+	// The targets are resolved serially, since resolving those of external
+	// repositories fills the plugin state.
+	seen := map[string]bool{}
+	var targets []string
+	for _, node := range nodes {
+		l, err := label.Parse(node.toFix)
+		if err != nil {
+			continue
+		}
+		target, err := plugin.buildozerTarget(l)
+		if err != nil || seen[target] {
+			continue
+		}
+		seen[target] = true
+		targets = append(targets, target)
+	}
+
+	cache := &visibilityCache{attrs: map[string]*visibilityAttr{}}
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < prefetchWorkers && i < len(targets); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range work {
+				if attr, err := plugin.readVisibility(target); err == nil {
+					cache.set(target, attr)
+				}
+			}
+		}()
+	}
+	for _, target := range targets {
+		work <- target
+	}
+	close(work)
+	wg.Wait()
+	plugin.debug.printf("prefetched the visibility of %d targets", len(cache.attrs))
+	plugin.visibilities = cache
+}
+
+// dropVisibilityCache drops the visibility attributes read ahead, which the
+// fixes being applied make stale.
+func (plugin *FixVisibilityPlugin) dropVisibilityCache() {
+	plugin.visibilities = nil
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrefetchVisibility(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "WORKSPACE"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	plugin := &FixVisibilityPlugin{
+		properties:   &pluginProperties{},
+		workspaceDir: root,
+		buildozer: &scriptedRunner{name: "buildozer", outputs: map[string]string{
+			"print visibility //lib:a": "[//app:__pkg__]\n",
+		}},
+	}
+	plugin.prefetchVisibility([]*fixNode{
+		{toFix: "//lib:a", from: "//app:app"},
+		{toFix: "//lib:a", from: "//tool:tool"},
+		{toFix: "//lib:b", from: "//app:app"},
+		{toFix: "not a label:", from: "//app:app"},
+	})
+
+	attr, ok := plugin.visibilities.get("//lib:a")
+	if !ok || !reflect.DeepEqual(attr.entries, []string{"//app:__pkg__"}) {
+		t.Errorf("the prefetched visibility of //lib:a is %+v, %t, want [//app:__pkg__]", attr, ok)
+	}
+	// The reads which fail are left to prepareFix to report.
+	if _, ok := plugin.visibilities.get("//lib:b"); ok {
+		t.Error("the visibility of //lib:b was prefetched, though buildozer failed to read it")
+	}
+	if attr, err := plugin.currentVisibility("//lib:a"); err != nil || attr.value != "[//app:__pkg__]" {
+		t.Errorf("currentVisibility(//lib:a) = %+v, %v, want the prefetched visibility", attr, err)
+	}
+
+	plugin.dropVisibilityCache()
+	if _, ok := plugin.visibilities.get("//lib:a"); ok {
+		t.Error("the visibility of //lib:a is still cached after dropping the cache")
+	}
+}
@@ -0,0 +1,421 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"gopkg.in/yaml.v2"
+)
+
+// The possible values for the test_consumers property.
+const (
+	// testConsumersPackage grants the consumer package access regardless of it
+	// being a test. This is the default.
+	testConsumersPackage = "package"
+	// testConsumersWarn grants the consumer package access, but flags that the
+	// visibility widening is being driven by a test.
+	testConsumersWarn = "warn"
+	// testConsumersPackageGroup grants access to the package_group configured
+	// by test_package_group instead, adding the consumer package to it.
+	testConsumersPackageGroup = "package_group"
+)
+
+// The hooks configurable by the hooks property. The cquery and aquery hooks
+// run after the commands of the same name run through the fix-visibility
+// custom command.
+const (
+	hookBuild  = "build"
+	hookTest   = "test"
+	hookRun    = "run"
+	hookCquery = "cquery"
+	hookAquery = "aquery"
+)
+
+// The possible behaviors of the hooks.
+const (
+	// hookPrompt prompts for the fixes in interactive mode and prints them
+	// otherwise. This is the default.
+	hookPrompt = "prompt"
+	// hookAuto applies the fixes without asking, except for the risk tiers
+	// configured as manual.
+	hookAuto = "auto"
+	// hookPrint prints the fixes without prompting.
+	hookPrint = "print"
+	// hookSkip ignores the visibility issues.
+	hookSkip = "skip"
+)
+
+// pluginProperties holds the properties set under the `properties` key of this
+// plugin entry in the .aspect/cli/plugins.yaml file, e.g.:
+//
+//	properties:
+//	  test_consumers: package_group
+//	  test_package_group: //tests:visibility
+//	  persist_fixes: true
+//	  command_file: visibility-fixes.txt
+//	  allowlist_file: visibility.bzl
+//	  show_dependency_attrs: true
+//	  results_url: https://results.example.com/api/visibility-fixes
+//	  violations_file: visibility-violations.jsonl
+//	  grant: "//{from_dir_depth_2}:__subpackages__"
+//	  codeowners_grant: subpackages
+//	  output: plain
+//	  color: never
+//	  debug_log: true
+//	  buildozer_path: tools/buildozer
+//	  label_style: canonical
+//	  messages_file: .aspect/fix-visibility/messages.yaml
+//	  messages:
+//	    fix_prompt: "Corriger la visibilité ? [o/N/e(xpliquer)]"
+//	    "yes": [o, oui]
+//	  format: table
+//	  review_threshold: 20
+//	  metrics_url: http://pushgateway.example.com:9091
+//	  redact_labels: hash
+//	  deprecated_targets: warn
+//	  changelist_file: .aspect/fix-visibility/changelist.md
+//	  generated_build_files:
+//	    - third_party/generated/**
+//	  skip_untracked_build_files: true
+//	  prompts: never
+//	  audit_log: true
+//	  max_visibility_entries: 15
+//	  history_file: .aspect/fix-visibility/history.jsonl
+//	  history_url: https://visibility.example.com/api/history
+//	  pre_fix_commands:
+//	    - tools/check-build-files.sh
+//	  post_fix_commands:
+//	    - bazel run //:gazelle
+//	  open_editor: true
+//	  batch_size: 50
+//	  batch_by: directory
+//	  refactor_threshold: 3
+//	  strategies:
+//	    - prefix: //examples/...
+//	      strategy: public
+//	    - prefix: //src/...
+//	      strategy: package_group
+//	      package_group: //src:visibility
+//	    - prefix: //services/...
+//	      strategy: default_visibility
+//	      package_macro: my_package
+//	  hooks:
+//	    run: print
+//	    test: skip
+//	  risk_confirmation:
+//	    package: auto
+//	    cross_tree: strong
+type pluginProperties struct {
+	TestConsumers    string `yaml:"test_consumers"`
+	TestPackageGroup string `yaml:"test_package_group"`
+	// PersistFixes hands off the fixes that were neither applied nor declined
+	// to the next invocation, e.g. from `build` to the subsequent `test`.
+	PersistFixes bool `yaml:"persist_fixes"`
+	// CommandFile is the path, relative to the workspace root unless absolute,
+	// of the file where the commands printed for the user are also written in
+	// the `buildozer -f` batch format.
+	CommandFile string `yaml:"command_file"`
+	// AllowlistFile is the name of the .bzl file, in the package of the target
+	// being fixed, where its visibility allowlist is kept instead of its BUILD
+	// rule.
+	AllowlistFile string `yaml:"allowlist_file"`
+	// ShowDependencyAttrs lists, along with each fix, the attributes of the
+	// consumer referencing the target being fixed, found with `bazel query`.
+	ShowDependencyAttrs bool `yaml:"show_dependency_attrs"`
+	// ResultsURL is the URL of the results API the proposed and applied fixes
+	// are POSTed to, along with the invocation ID of the build.
+	ResultsURL string `yaml:"results_url"`
+	// ViolationsFile is the path, relative to the workspace root unless
+	// absolute, of the file where each violation is written as a JSON line as
+	// soon as it's detected.
+	ViolationsFile string `yaml:"violations_file"`
+	// Grant is the template of the visibility entry granted to the consumer,
+	// instead of its package. See expandGrantTemplate for the placeholders.
+	Grant string `yaml:"grant"`
+	// CodeownersGrant grants the root directory of the team owning the
+	// consumer in CODEOWNERS instead, either as subpackages or as package.
+	CodeownersGrant string `yaml:"codeowners_grant"`
+	// Output selects between the styled prompts of the CLI and plain line
+	// prompts, by default depending on the output being a terminal.
+	Output string `yaml:"output"`
+	// Color selects whether the output is styled with colors, by default
+	// honoring NO_COLOR and the --color flag passed to Bazel.
+	Color string `yaml:"color"`
+	// DebugLog writes the diagnostics of the plugin to
+	// .aspect/fix-visibility/debug.log under the workspace root.
+	DebugLog bool `yaml:"debug_log"`
+	// RiskConfirmation sets, by risk tier, the confirmation required to apply
+	// the fixes in interactive mode.
+	RiskConfirmation map[string]string `yaml:"risk_confirmation"`
+	// BuildozerPath is the path of a buildozer binary run instead of the
+	// in-process implementation. A relative path is resolved against the
+	// workspace root, unless it's a bare name looked up in the PATH.
+	BuildozerPath string `yaml:"buildozer_path"`
+	// Hooks sets the behavior of the hook after each command, e.g. so that
+	// prompting after `run` doesn't interfere with the launched binary.
+	Hooks map[string]string `yaml:"hooks"`
+	// LabelStyle selects between shortened and canonical labels in both the
+	// printed commands and the applied edits.
+	LabelStyle string `yaml:"label_style"`
+	// Messages override the wordings of the interactive experience, taking
+	// precedence over those of MessagesFile, the path of a YAML file, relative
+	// to the workspace root unless absolute, with the same keys.
+	Messages     messages `yaml:"messages"`
+	MessagesFile string   `yaml:"messages_file"`
+	// Format selects between printing the commands of each fix as it's handled
+	// and printing a table of the fixes once they are all handled.
+	Format string `yaml:"format"`
+	// ReviewThreshold is the number of fixes from which they are reviewed at
+	// once in interactive mode. Zero disables the review.
+	ReviewThreshold int `yaml:"review_threshold"`
+	// MetricsURL is the URL of a Prometheus Pushgateway the metrics of each
+	// run are pushed to.
+	MetricsURL string `yaml:"metrics_url"`
+	// RedactLabels selects how the labels are redacted in the outputs sent
+	// over the network.
+	RedactLabels string `yaml:"redact_labels"`
+	// DeprecatedTargets selects between suggesting to migrate off the
+	// deprecated targets and offering their grants as for the others.
+	DeprecatedTargets string `yaml:"deprecated_targets"`
+	// ChangelistFile is the path of the Markdown file where the applied fixes
+	// are described.
+	ChangelistFile string `yaml:"changelist_file"`
+	// GeneratedBuildFiles are the patterns of the paths, relative to the
+	// workspace root, of the BUILD files which are generated and mustn't be
+	// edited.
+	GeneratedBuildFiles []string `yaml:"generated_build_files"`
+	// SkipUntrackedBuildFiles skips the BUILD files not tracked by git.
+	SkipUntrackedBuildFiles bool `yaml:"skip_untracked_build_files"`
+	// Strategies select the strategy fixing the targets by path prefix.
+	Strategies []strategyRule `yaml:"strategies"`
+	// Prompts selects when the user is prompted in interactive mode.
+	Prompts string `yaml:"prompts"`
+	// AuditLog records the applied fixes in the audit trail.
+	AuditLog bool `yaml:"audit_log"`
+	// MaxVisibilityEntries is the number of packages past which the visibility
+	// of a target is not grown by the fixes. Zero disables the limit.
+	MaxVisibilityEntries int `yaml:"max_visibility_entries"`
+	// HistoryFile is the path, relative to the workspace root unless absolute,
+	// of the file where the issues detected by each run are appended, which
+	// the trends command reports on.
+	HistoryFile string `yaml:"history_file"`
+	// HistoryURL is the URL the issues detected by each run are POSTed to.
+	HistoryURL string `yaml:"history_url"`
+	// PreFixCommands are run before applying each fix, which isn't applied if
+	// one of them fails, and PostFixCommands after applying all the fixes,
+	// with the files edited as arguments.
+	PreFixCommands  []string `yaml:"pre_fix_commands"`
+	PostFixCommands []string `yaml:"post_fix_commands"`
+	// OpenEditor offers to open the files edited by the fixes in the editor
+	// of the user once they are applied.
+	OpenEditor bool `yaml:"open_editor"`
+	// BatchSize is the number of fixes handled at a time, in batches as set
+	// by BatchBy. Zero disables the batches.
+	BatchSize int    `yaml:"batch_size"`
+	BatchBy   string `yaml:"batch_by"`
+	// RefactorThreshold is the number of runs of the history granting access
+	// to a target from which a restructuring is suggested along with its
+	// next fix. Zero disables the suggestions.
+	RefactorThreshold int `yaml:"refactor_threshold"`
+}
+
+// parsePluginProperties parses and validates the raw YAML properties passed by
+// the CLI to the Setup hook.
+func parsePluginProperties(raw []byte) (*pluginProperties, error) {
+	properties := &pluginProperties{}
+	if err := yaml.Unmarshal(raw, properties); err != nil {
+		return nil, fmt.Errorf("failed to parse properties: %w", err)
+	}
+
+	switch properties.TestConsumers {
+	case "":
+		properties.TestConsumers = testConsumersPackage
+	case testConsumersPackage, testConsumersWarn:
+	case testConsumersPackageGroup:
+		if properties.TestPackageGroup == "" {
+			return nil, fmt.Errorf("test_package_group must be set when test_consumers is %q", testConsumersPackageGroup)
+		}
+		if _, err := label.Parse(properties.TestPackageGroup); err != nil {
+			return nil, fmt.Errorf("invalid test_package_group: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid test_consumers %q: must be one of %q, %q or %q",
+			properties.TestConsumers, testConsumersPackage, testConsumersWarn, testConsumersPackageGroup)
+	}
+
+	if properties.AllowlistFile != "" && !strings.HasSuffix(properties.AllowlistFile, ".bzl") {
+		return nil, fmt.Errorf("invalid allowlist_file %q: must be a .bzl file", properties.AllowlistFile)
+	}
+
+	if properties.Grant != "" {
+		if _, err := parseGrantTemplate(properties.Grant, "a/b/c"); err != nil {
+			return nil, fmt.Errorf("invalid grant %q: %w", properties.Grant, err)
+		}
+	}
+
+	switch properties.CodeownersGrant {
+	case "", codeownersGrantSubpackages, codeownersGrantPackage:
+	default:
+		return nil, fmt.Errorf("invalid codeowners_grant %q: must be %q or %q",
+			properties.CodeownersGrant, codeownersGrantSubpackages, codeownersGrantPackage)
+	}
+
+	switch properties.Output {
+	case "":
+		properties.Output = outputAuto
+	case outputAuto, outputPlain, outputStyled:
+	default:
+		return nil, fmt.Errorf("invalid output %q: must be one of %q, %q or %q",
+			properties.Output, outputAuto, outputPlain, outputStyled)
+	}
+
+	switch properties.Color {
+	case "":
+		properties.Color = colorAuto
+	case colorAuto, colorAlways, colorNever:
+	default:
+		return nil, fmt.Errorf("invalid color %q: must be one of %q, %q or %q",
+			properties.Color, colorAuto, colorAlways, colorNever)
+	}
+
+	for tier, confirmation := range properties.RiskConfirmation {
+		switch tier {
+		case riskPackage, riskTopLevelDir, riskCrossTree:
+		default:
+			return nil, fmt.Errorf("invalid risk_confirmation tier %q: must be one of %q, %q or %q",
+				tier, riskPackage, riskTopLevelDir, riskCrossTree)
+		}
+		switch confirmation {
+		case confirmAuto, confirmPrompt, confirmStrong, confirmManual:
+		default:
+			return nil, fmt.Errorf("invalid risk_confirmation of %s %q: must be one of %q, %q, %q or %q",
+				tier, confirmation, confirmAuto, confirmPrompt, confirmStrong, confirmManual)
+		}
+	}
+
+	for hook, mode := range properties.Hooks {
+		switch hook {
+		case hookBuild, hookTest, hookRun, hookCquery, hookAquery:
+		default:
+			return nil, fmt.Errorf("invalid hooks command %q: must be one of %q, %q, %q, %q or %q",
+				hook, hookBuild, hookTest, hookRun, hookCquery, hookAquery)
+		}
+		switch mode {
+		case hookPrompt, hookAuto, hookPrint, hookSkip:
+		default:
+			return nil, fmt.Errorf("invalid hooks behavior of %s %q: must be one of %q, %q, %q or %q",
+				hook, mode, hookPrompt, hookAuto, hookPrint, hookSkip)
+		}
+	}
+
+	switch properties.LabelStyle {
+	case "":
+		properties.LabelStyle = labelStyleShort
+	case labelStyleShort, labelStyleCanonical:
+	default:
+		return nil, fmt.Errorf("invalid label_style %q: must be %q or %q",
+			properties.LabelStyle, labelStyleShort, labelStyleCanonical)
+	}
+
+	switch properties.Format {
+	case "":
+		properties.Format = formatText
+	case formatText, formatTable:
+	default:
+		return nil, fmt.Errorf("invalid format %q: must be %q or %q",
+			properties.Format, formatText, formatTable)
+	}
+
+	switch properties.RedactLabels {
+	case "":
+		properties.RedactLabels = redactNone
+	case redactNone, redactHash, redactTruncate:
+	default:
+		return nil, fmt.Errorf("invalid redact_labels %q: must be one of %q, %q or %q",
+			properties.RedactLabels, redactNone, redactHash, redactTruncate)
+	}
+
+	switch properties.DeprecatedTargets {
+	case "":
+		properties.DeprecatedTargets = deprecatedSuggest
+	case deprecatedSuggest, deprecatedWarn:
+	default:
+		return nil, fmt.Errorf("invalid deprecated_targets %q: must be %q or %q",
+			properties.DeprecatedTargets, deprecatedSuggest, deprecatedWarn)
+	}
+
+	for _, pattern := range properties.GeneratedBuildFiles {
+		if _, err := filepath.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+			return nil, fmt.Errorf("invalid generated_build_files pattern %q: %w", pattern, err)
+		}
+	}
+
+	switch properties.Prompts {
+	case "":
+		properties.Prompts = promptsAuto
+	case promptsAuto, promptsNever, promptsCLI:
+	default:
+		return nil, fmt.Errorf("invalid prompts %q: must be one of %q, %q or %q",
+			properties.Prompts, promptsAuto, promptsNever, promptsCLI)
+	}
+
+	for i := range properties.Strategies {
+		if err := properties.Strategies[i].validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if properties.MaxVisibilityEntries < 0 {
+		return nil, fmt.Errorf("invalid max_visibility_entries %d: must not be negative", properties.MaxVisibilityEntries)
+	}
+
+	if properties.BatchSize < 0 {
+		return nil, fmt.Errorf("invalid batch_size %d: must not be negative", properties.BatchSize)
+	}
+	switch properties.BatchBy {
+	case "":
+		properties.BatchBy = batchByDirectory
+	case batchByDirectory, batchByCount:
+	default:
+		return nil, fmt.Errorf("invalid batch_by %q: must be one of %q or %q",
+			properties.BatchBy, batchByDirectory, batchByCount)
+	}
+
+	if properties.RefactorThreshold < 0 {
+		return nil, fmt.Errorf("invalid refactor_threshold %d: must not be negative", properties.RefactorThreshold)
+	}
+
+	if properties.ReviewThreshold < 0 {
+		return nil, fmt.Errorf("invalid review_threshold %d: must not be negative", properties.ReviewThreshold)
+	}
+
+	if properties.ResultsURL != "" {
+		if u, err := url.Parse(properties.ResultsURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid results_url %q: must be an http or https URL", properties.ResultsURL)
+		}
+	}
+	if properties.MetricsURL != "" {
+		if u, err := url.Parse(properties.MetricsURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid metrics_url %q: must be an http or https URL", properties.MetricsURL)
+		}
+	}
+	if properties.HistoryURL != "" {
+		if u, err := url.Parse(properties.HistoryURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("invalid history_url %q: must be an http or https URL", properties.HistoryURL)
+		}
+	}
+
+	return properties, nil
+}
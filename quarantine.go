@@ -0,0 +1,55 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// quarantined is a fix blocked by the configuration, listed after the fixes
+// along with the rule which blocked it and the override allowing it, so that
+// the blocked fixes can be followed up on.
+type quarantined struct {
+	ToFix    string `json:"to_fix"`
+	From     string `json:"from"`
+	Rule     string `json:"rule"`
+	Override string `json:"override"`
+}
+
+// quarantine records that the fixes of the given fix, or of the fixes
+// combined into it, are blocked by the given rule of the configuration.
+func (plugin *FixVisibilityPlugin) quarantine(fix *visibilityFix, rule, override string) {
+	for _, f := range fix.constituents() {
+		plugin.quarantineIssue(f.node, rule, override)
+	}
+}
+
+// quarantineIssue records that the fix of the given issue is blocked by the
+// given rule of the configuration.
+func (plugin *FixVisibilityPlugin) quarantineIssue(node *fixNode, rule, override string) {
+	plugin.quarantined = append(plugin.quarantined, quarantined{
+		ToFix:    node.toFix,
+		From:     node.from,
+		Rule:     rule,
+		Override: override,
+	})
+}
+
+// printQuarantine writes the quarantined fixes, if any.
+func (plugin *FixVisibilityPlugin) printQuarantine(out io.Writer) {
+	if len(plugin.quarantined) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "%d fix(es) were blocked by the configuration:\n", len(plugin.quarantined))
+	for _, q := range plugin.quarantined {
+		fmt.Fprintf(out, "  %s, for %s: blocked by %s\n    To allow it, %s\n", q.ToFix, q.From, q.Rule, q.Override)
+	}
+}
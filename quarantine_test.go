@@ -0,0 +1,42 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQuarantine(t *testing.T) {
+	plugin := &FixVisibilityPlugin{}
+	var out strings.Builder
+	plugin.printQuarantine(&out)
+	if out.Len() != 0 {
+		t.Errorf("printQuarantine() printed %q without blocked fixes, want nothing", out.String())
+	}
+
+	app := &visibilityFix{node: &fixNode{toFix: "//lib:a", from: "//app:app"}}
+	tool := &visibilityFix{node: &fixNode{toFix: "//lib:a", from: "//tool:tool"}}
+	plugin.quarantine(&visibilityFix{node: app.node, merged: []*visibilityFix{app, tool}}, "max_visibility_entries: 2", "raise max_visibility_entries")
+	plugin.quarantineIssue(&fixNode{toFix: "//gen:b", from: "//app:app"}, `generated_build_files: "gen/**"`, `remove "gen/**" from generated_build_files`)
+
+	plugin.printQuarantine(&out)
+	want := `3 fix(es) were blocked by the configuration:
+  //lib:a, for //app:app: blocked by max_visibility_entries: 2
+    To allow it, raise max_visibility_entries
+  //lib:a, for //tool:tool: blocked by max_visibility_entries: 2
+    To allow it, raise max_visibility_entries
+  //gen:b, for //app:app: blocked by generated_build_files: "gen/**"
+    To allow it, remove "gen/**" from generated_build_files
+`
+	if out.String() != want {
+		t.Errorf("printQuarantine() printed %q, want %q", out.String(), want)
+	}
+}
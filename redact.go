@@ -0,0 +1,185 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// The possible values for the redact_labels property.
+const (
+	// redactNone keeps the labels as is. This is the default.
+	redactNone = "none"
+	// redactHash replaces the labels by a hash.
+	redactHash = "hash"
+	// redactTruncate keeps only the repository and the top-level directory of
+	// the labels.
+	redactTruncate = "truncate"
+)
+
+// hashLength is the number of hexadecimal digits of the hash of a redacted
+// label.
+const hashLength = 12
+
+// tokenPattern matches the words of a text which may be labels or paths,
+// i.e. anything but the spaces, the quotes and the punctuation of the
+// commands and of the Starlark expressions.
+var tokenPattern = regexp.MustCompile(`[^\s'"()\[\]{},+=]+`)
+
+// redactLabel returns the given label, or package name, redacted as set by
+// the redact_labels property, for the outputs sent over the network.
+func (plugin *FixVisibilityPlugin) redactLabel(l string) string {
+	switch plugin.properties.RedactLabels {
+	case redactHash:
+		sum := sha256.Sum256([]byte(l))
+		return "sha256:" + hex.EncodeToString(sum[:])[:hashLength]
+	case redactTruncate:
+		// The label is split by hand rather than parsed, as the canonical
+		// names of the repositories, e.g. @@rules_go~0.41.0, aren't supported
+		// by the parser.
+		i := strings.Index(l, "//")
+		if i < 0 {
+			if strings.HasPrefix(l, "@") {
+				// The main target of a repository, e.g. @foo for @foo//:foo.
+				return strings.SplitN(l, ":", 2)[0] + "//..."
+			}
+			return "..."
+		}
+		repo, pkg := l[:i], strings.SplitN(l[i+2:], ":", 2)[0]
+		if repo != "" && !strings.HasPrefix(repo, "@") {
+			return "..."
+		}
+		dir := strings.SplitN(pkg, "/", 2)[0]
+		if dir == "" {
+			return repo + "//..."
+		}
+		return repo + "//" + dir + "/..."
+	default:
+		return l
+	}
+}
+
+// redact returns the given text, sent over the network, with the labels and
+// the paths it contains redacted as set by the redact_labels property. The
+// paths, e.g. the BUILD files of the edits or the buildozer targets of local
+// repositories, are redacted as the labels they stand for, so that they hash
+// alike on every machine; those outside of the workspace and of its local
+// repositories are redacted whole.
+func (plugin *FixVisibilityPlugin) redact(text string) string {
+	if plugin.properties.RedactLabels == redactNone {
+		return text
+	}
+	return tokenPattern.ReplaceAllStringFunc(text, plugin.redactToken)
+}
+
+// redactToken redacts a single word of a text, if it's a label or a path.
+func (plugin *FixVisibilityPlugin) redactToken(token string) string {
+	switch {
+	case strings.HasPrefix(token, "@") || strings.HasPrefix(token, "//"):
+		return plugin.redactLabel(token)
+	case strings.HasPrefix(token, ":") && len(token) > 1:
+		// A label relative to the package of the command, which the
+		// truncated form has nothing to keep of.
+		if plugin.properties.RedactLabels == redactHash {
+			return plugin.redactLabel(token)
+		}
+		return ":..."
+	case strings.Contains(token, "/"):
+		return plugin.redactPath(token)
+	default:
+		return token
+	}
+}
+
+// redactPath redacts the given path, relative to the workspace root unless
+// absolute, optionally followed by the name of a target as in the buildozer
+// targets, e.g. /path/to/repo/pkg:name.
+func (plugin *FixVisibilityPlugin) redactPath(p string) string {
+	dir, name := p, ""
+	if i := strings.LastIndex(p, ":"); i > strings.LastIndex(p, "/") {
+		dir, name = p[:i], p[i+1:]
+	}
+	repo, rel, ok := plugin.repositoryPath(dir)
+	if !ok {
+		if plugin.properties.RedactLabels == redactHash {
+			return plugin.redactLabel(p)
+		}
+		return "..."
+	}
+	pkg := rel
+	if name == "" {
+		// A file, in the package of its directory.
+		pkg, name = path.Dir(rel), path.Base(rel)
+		if pkg == "." {
+			pkg = ""
+		}
+	}
+	return plugin.redactLabel(label.New(repo, pkg, name).String())
+}
+
+// repositoryPath returns the repository the given path belongs to, the main
+// one being empty, along with the path relative to the root of the
+// repository. It returns false if the path is outside of the workspace and of
+// its local repositories.
+func (plugin *FixVisibilityPlugin) repositoryPath(p string) (string, string, bool) {
+	workspaceRoot, err := plugin.workspaceRoot()
+	if !filepath.IsAbs(p) {
+		if err != nil {
+			return "", "", false
+		}
+		p = filepath.Join(workspaceRoot, p)
+	}
+	// The local repositories may lie in the workspace, the deepest root
+	// containing the path is the one it belongs to.
+	repo, rel, found := "", "", false
+	depth := -1
+	roots := map[string]string{}
+	for name, root := range plugin.localRepositories {
+		roots[root] = name
+	}
+	if err == nil {
+		if _, exists := roots[workspaceRoot]; !exists {
+			roots[workspaceRoot] = ""
+		}
+	}
+	for root, name := range roots {
+		r, err := filepath.Rel(root, p)
+		if err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if d := strings.Count(filepath.Clean(root), string(filepath.Separator)); d > depth {
+			repo, rel, found, depth = name, filepath.ToSlash(r), true, d
+		}
+	}
+	if rel == "." {
+		rel = ""
+	}
+	return repo, rel, found
+}
+
+// redactRepository returns the given name of the workspace redacted as set
+// by the redact_labels property, the truncated form keeping nothing of it.
+func (plugin *FixVisibilityPlugin) redactRepository(name string) string {
+	switch plugin.properties.RedactLabels {
+	case redactHash:
+		return plugin.redactLabel(name)
+	case redactTruncate:
+		return "..."
+	default:
+		return name
+	}
+}
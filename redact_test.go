@@ -0,0 +1,150 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// hashed returns the given label redacted in hash mode.
+func hashed(l string) string {
+	sum := sha256.Sum256([]byte(l))
+	return "sha256:" + hex.EncodeToString(sum[:])[:hashLength]
+}
+
+// newRedactingPlugin returns a plugin redacting as set by the given mode, for
+// a build which ran in /ws, having a local repository outside the workspace
+// and another one inside.
+func newRedactingPlugin(mode string) *FixVisibilityPlugin {
+	return &FixVisibilityPlugin{
+		properties:   &pluginProperties{RedactLabels: mode},
+		workspaceDir: "/ws",
+		localRepositories: localRepositoryPaths{
+			"outside":  "/src/outside",
+			"vendored": "/ws/third_party/vendored",
+		},
+	}
+}
+
+func TestRedact(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		hash     string
+		truncate string
+	}{
+		{"label", "//secret/project:lib", hashed("//secret/project:lib"), "//secret/..."},
+		{"root package label", "//:lib", hashed("//:lib"), "//..."},
+		{"package name", "//secret/project", hashed("//secret/project"), "//secret/..."},
+		{"package visibility", "//secret/project:__pkg__", hashed("//secret/project:__pkg__"), "//secret/..."},
+		{"main repository label", "@//secret:lib", hashed("@//secret:lib"), "@//secret/..."},
+		{"external label", "@repo//secret/project:lib", hashed("@repo//secret/project:lib"), "@repo//secret/..."},
+		{"canonical label", "@@rules_go~0.41.0//go/private:lib", hashed("@@rules_go~0.41.0//go/private:lib"), "@@rules_go~0.41.0//go/..."},
+		{"repository main target", "@repo", hashed("@repo"), "@repo//..."},
+		{"relative label", ":lib", hashed(":lib"), ":..."},
+		{"workspace target path", "/ws/secret/project:lib", hashed("//secret/project:lib"), "//secret/..."},
+		{"nested workspace target path", "/ws/nested/secret:lib", hashed("//nested/secret:lib"), "//nested/..."},
+		{"local repository target path", "/src/outside/secret:lib", hashed("@outside//secret:lib"), "@outside//secret/..."},
+		{"vendored repository target path", "/ws/third_party/vendored/secret:lib", hashed("@vendored//secret:lib"), "@vendored//secret/..."},
+		{"BUILD file path", "/ws/secret/project/BUILD.bazel", hashed("//secret/project:BUILD.bazel"), "//secret/..."},
+		{"root BUILD file path", "/ws/BUILD.bazel", hashed("//:BUILD.bazel"), "//..."},
+		{"relative path", "secret/project/BUILD.bazel", hashed("//secret/project:BUILD.bazel"), "//secret/..."},
+		{"unknown path", "/home/user/secret/BUILD.bazel", hashed("/home/user/secret/BUILD.bazel"), "..."},
+		{"plain text", "pre_fix_commands", "pre_fix_commands", "pre_fix_commands"},
+		{
+			"buildozer command",
+			"buildozer 'add visibility //app:__pkg__' /ws/secret:lib",
+			"buildozer 'add visibility " + hashed("//app:__pkg__") + "' " + hashed("//secret:lib"),
+			"buildozer 'add visibility //app/...' //secret/...",
+		},
+		{
+			"relative buildozer command",
+			"buildozer 'set visibility :lib_visibility' //secret:lib",
+			"buildozer 'set visibility " + hashed(":lib_visibility") + "' " + hashed("//secret:lib"),
+			"buildozer 'set visibility :...' //secret/...",
+		},
+		{
+			"allowlist edit",
+			"# add //app:__pkg__, //tools:__pkg__ to LIB_VISIBILITY in /ws/secret/visibility.bzl",
+			"# add " + hashed("//app:__pkg__") + ", " + hashed("//tools:__pkg__") + " to LIB_VISIBILITY in " + hashed("//secret:visibility.bzl"),
+			"# add //app/..., //tools/... to LIB_VISIBILITY in //secret/...",
+		},
+		{
+			"visibility rewrite",
+			`# set the visibility of //secret:lib in /ws/secret/BUILD.bazel to:` + "\n" + `# ["//app:__pkg__"] + select({"//conditions:default": []})`,
+			`# set the visibility of ` + hashed("//secret:lib") + ` in ` + hashed("//secret:BUILD.bazel") + ` to:` + "\n" +
+				`# ["` + hashed("//app:__pkg__") + `"] + select({"` + hashed("//conditions:default") + `": []})`,
+			`# set the visibility of //secret/... in //secret/... to:` + "\n" + `# ["//app/..."] + select({"//conditions/...": []})`,
+		},
+		{
+			"generated rule",
+			`generated_build_files: "secret/gen/**"`,
+			`generated_build_files: "` + hashed("//secret/gen:**") + `"`,
+			`generated_build_files: "//secret/..."`,
+		},
+		{
+			"generated override",
+			`fix the generator of secret/BUILD.bazel, or remove "secret/**" from generated_build_files`,
+			`fix the generator of ` + hashed("//secret:BUILD.bazel") + `, or remove "` + hashed("//secret:**") + `" from generated_build_files`,
+			`fix the generator of //secret/..., or remove "//secret/..." from generated_build_files`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := newRedactingPlugin(redactNone).redact(tt.text); got != tt.text {
+				t.Errorf("redact(%q) = %q in none mode, want it unchanged", tt.text, got)
+			}
+			if got := newRedactingPlugin(redactHash).redact(tt.text); got != tt.hash {
+				t.Errorf("redact(%q) = %q in hash mode, want %q", tt.text, got, tt.hash)
+			}
+			if got := newRedactingPlugin(redactTruncate).redact(tt.text); got != tt.truncate {
+				t.Errorf("redact(%q) = %q in truncate mode, want %q", tt.text, got, tt.truncate)
+			}
+		})
+	}
+}
+
+func TestRedactRepository(t *testing.T) {
+	tests := []struct {
+		mode string
+		want string
+	}{
+		{redactNone, "secret"},
+		{redactHash, hashed("secret")},
+		{redactTruncate, "..."},
+	}
+	for _, tt := range tests {
+		if got := newRedactingPlugin(tt.mode).redactRepository("secret"); got != tt.want {
+			t.Errorf("redactRepository(%q) = %q in %s mode, want %q", "secret", got, tt.mode, tt.want)
+		}
+	}
+}
+
+func TestResultsRedaction(t *testing.T) {
+	plugin := newRedactingPlugin(redactTruncate)
+	results := &buildResults{redact: plugin.redact}
+	results.addQuarantined([]quarantined{{
+		ToFix:    "/ws/secret:lib",
+		From:     "@repo//secret/app:bin",
+		Rule:     `generated_build_files: "secret/**"`,
+		Override: "fix the generator of secret/BUILD.bazel, or remove it",
+	}})
+	want := quarantined{
+		ToFix:    "//secret/...",
+		From:     "@repo//secret/...",
+		Rule:     `generated_build_files: "//secret/..."`,
+		Override: "fix the generator of //secret/..., or remove it",
+	}
+	if got := results.Quarantined[0]; got != want {
+		t.Errorf("addQuarantined() = %+v, want %+v", got, want)
+	}
+}
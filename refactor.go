@@ -0,0 +1,107 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// grantRecurrence is how often the history granted access to a target.
+type grantRecurrence struct {
+	// runs is the number of runs which applied a grant on the target.
+	runs int
+	// consumers are the consumers the grants were applied for.
+	consumers map[string]bool
+}
+
+// loadGrants reads the history file at the given path, returning the
+// recurrence of the applied grants by target being fixed. A missing history
+// file has no grants.
+func loadGrants(path string) (map[string]*grantRecurrence, error) {
+	grants := map[string]*grantRecurrence{}
+	err := readHistory(path, func(record *historyRecord) {
+		granted := map[string]bool{}
+		for _, issue := range record.Issues {
+			if issue.Status != fixStatusNames[fixApplied] {
+				continue
+			}
+			grant, ok := grants[issue.ToFix]
+			if !ok {
+				grant = &grantRecurrence{consumers: map[string]bool{}}
+				grants[issue.ToFix] = grant
+			}
+			if !granted[issue.ToFix] {
+				granted[issue.ToFix] = true
+				grant.runs++
+			}
+			grant.consumers[issue.From] = true
+		}
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return grants, nil
+}
+
+// suggestRefactor prints, when the target being fixed was granted access to
+// by at least refactor_threshold runs of the history, the restructurings
+// ending the stream of grants, scaffolded from the consumers granted so far.
+func (plugin *FixVisibilityPlugin) suggestRefactor(fix *visibilityFix) {
+	threshold := plugin.properties.RefactorThreshold
+	grant, ok := plugin.grants[fix.node.toFix]
+	if threshold == 0 || !ok || grant.runs < threshold {
+		return
+	}
+	l, err := label.Parse(fix.node.toFix)
+	if err != nil {
+		return
+	}
+
+	consumers := map[string]bool{}
+	for from := range grant.consumers {
+		consumers[from] = true
+	}
+	for _, f := range append([]*visibilityFix{fix}, fix.merged...) {
+		consumers[f.node.from] = true
+		for _, from := range f.node.folded {
+			consumers[from] = true
+		}
+	}
+	packages := map[string]bool{}
+	for from := range consumers {
+		packages[consumerPackage(from)] = true
+	}
+	var sorted []string
+	for pkg := range packages {
+		sorted = append(sorted, pkg)
+	}
+	sort.Strings(sorted)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Access to %s was granted by %d previous runs, to %d consumers.\n", fix.node.toFix, grant.runs, len(consumers))
+	fmt.Fprintf(&b, "Rather than one more visibility entry, consider restructuring it in %s:\n", packageSpec(l))
+	fmt.Fprintf(&b, "  - a package_group of its consumers, granted once in place of their entries:\n\n")
+	fmt.Fprintf(&b, "    package_group(\n        name = %q,\n        packages = [\n", l.Name+"_consumers")
+	for _, pkg := range sorted {
+		fmt.Fprintf(&b, "            %q,\n", pkg)
+	}
+	fmt.Fprintf(&b, "        ],\n    )\n\n")
+	fmt.Fprintf(&b, "  - a public facade target exposing the API meant for its consumers, keeping the target itself private:\n\n")
+	fmt.Fprintf(&b, "    alias(\n        name = %q,\n        actual = %q,\n        visibility = [%q],\n    )\n\n",
+		l.Name+"_api", ":"+l.Name, publicVisibility)
+	fmt.Fprint(os.Stdout, b.String())
+}
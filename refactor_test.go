@@ -0,0 +1,83 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadGrants(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "history.jsonl")
+	if grants, err := loadGrants(path); err != nil || len(grants) != 0 {
+		t.Errorf("loadGrants() of a missing history = %v, %v, want no grants", grants, err)
+	}
+
+	history := `{"issues":[{"to_fix":"//lib:a","from":"//app:app","status":"applied"},{"to_fix":"//lib:a","from":"//app:other","status":"applied"},{"to_fix":"//lib:b","from":"//app:app","status":"declined"}]}
+{"issues":[{"to_fix":"//lib:a","from":"//tool:tool","status":"applied"}]}
+`
+	if err := os.WriteFile(path, []byte(history), 0644); err != nil {
+		t.Fatal(err)
+	}
+	grants, err := loadGrants(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]*grantRecurrence{
+		"//lib:a": {runs: 2, consumers: map[string]bool{"//app:app": true, "//app:other": true, "//tool:tool": true}},
+	}
+	if !reflect.DeepEqual(grants, want) {
+		t.Errorf("loadGrants() = %+v, want %+v", grants, want)
+	}
+}
+
+func TestSuggestRefactor(t *testing.T) {
+	grants := map[string]*grantRecurrence{
+		"//lib:a": {runs: 2, consumers: map[string]bool{"//app:app": true, "//tool:tool": true}},
+	}
+	fix := &visibilityFix{node: &fixNode{toFix: "//lib:a", from: "//web:site", folded: []string{"//web:other"}}}
+	for _, test := range []struct {
+		threshold int
+		printed   string
+	}{
+		{0, ""},
+		{3, ""},
+		{2, `Access to //lib:a was granted by 2 previous runs, to 4 consumers.
+Rather than one more visibility entry, consider restructuring it in //lib:
+  - a package_group of its consumers, granted once in place of their entries:
+
+    package_group(
+        name = "a_consumers",
+        packages = [
+            "//app",
+            "//tool",
+            "//web",
+        ],
+    )
+
+  - a public facade target exposing the API meant for its consumers, keeping the target itself private:
+
+    alias(
+        name = "a_api",
+        actual = ":a",
+        visibility = ["//visibility:public"],
+    )
+
+`},
+	} {
+		plugin := &FixVisibilityPlugin{properties: &pluginProperties{RefactorThreshold: test.threshold}, grants: grants}
+		if printed := captureStdout(t, func() { plugin.suggestRefactor(fix) }); printed != test.printed {
+			t.Errorf("suggestRefactor() with a threshold of %d printed %q, want %q", test.threshold, printed, test.printed)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/buildtools/build"
+)
+
+// moduleFile holds the declarations of a MODULE.bazel file that determine the
+// repository mapping of the module, i.e. the names under which other modules
+// are visible from its repository.
+type moduleFile struct {
+	// name is the name of the module.
+	name string
+	// deps maps the module names of the bazel_dep declarations to their
+	// apparent repository names.
+	deps map[string]string
+}
+
+// loadModuleFile parses the MODULE.bazel file in the given repository
+// directory. It returns nil if there's none.
+func loadModuleFile(dir string) (*moduleFile, error) {
+	path := filepath.Join(dir, "MODULE.bazel")
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the repository mapping: %w", err)
+	}
+	file, err := build.ParseModule(path, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the repository mapping: %w", err)
+	}
+	module := &moduleFile{deps: make(map[string]string)}
+	for _, rule := range file.Rules("module") {
+		module.name = rule.AttrString("name")
+	}
+	for _, rule := range file.Rules("bazel_dep") {
+		name := rule.AttrString("name")
+		if name == "" {
+			continue
+		}
+		apparent := rule.AttrString("repo_name")
+		if apparent == "" {
+			apparent = name
+		}
+		module.deps[name] = apparent
+	}
+	return module, nil
+}
+
+// moduleName returns the name of the module visible under the given apparent
+// repository name.
+func (module *moduleFile) moduleName(apparent string) string {
+	for name, a := range module.deps {
+		if a == apparent {
+			return name
+		}
+	}
+	return apparent
+}
+
+// apparentName returns the apparent name the repository of the given module
+// is visible under, if it's a dependency of the module.
+func (module *moduleFile) apparentName(name string) (string, bool) {
+	if module == nil {
+		return "", false
+	}
+	apparent, ok := module.deps[name]
+	return apparent, ok
+}
+
+// apparentLabel returns the given label with its canonical repository name,
+// e.g. @@rules_foo~ or @@rules_foo+ as Bazel reports them with Bzlmod,
+// replaced by the apparent name the main repository sees the module under.
+// The labels of the other repositories are returned as is.
+func (plugin *FixVisibilityPlugin) apparentLabel(l string) string {
+	if !strings.HasPrefix(l, "@@") {
+		return l
+	}
+	canonical, rest, ok := strings.Cut(l[2:], "//")
+	if !ok {
+		return l
+	}
+	// The canonical name of a module's repository is its name followed by ~
+	// and, before Bazel 7, its version, or by + since Bazel 8. Those of the
+	// repositories of module extensions go on with more separators.
+	sep := strings.IndexAny(canonical, "~+")
+	if sep <= 0 || strings.ContainsAny(canonical[sep+1:], "~+") {
+		return l
+	}
+	if plugin.rootModule == nil {
+		plugin.rootModule = &moduleFile{}
+		if workspaceRoot, err := plugin.workspaceRoot(); err == nil {
+			if module, err := loadModuleFile(workspaceRoot); err == nil && module != nil {
+				plugin.rootModule = module
+			}
+		}
+	}
+	apparent, ok := plugin.rootModule.apparentName(canonical[:sep])
+	if !ok {
+		return l
+	}
+	return "@" + apparent + "//" + rest
+}
+
+// grantRepo returns the repository name the grant of visibility to a package
+// of the consumer repository must use in the BUILD files of the repository of
+// the target being fixed. Both repository names are apparent names as seen
+// from the main repository, but the consumer repository may be visible under
+// a different name from the repository of the target being fixed, as
+// determined by their MODULE.bazel files. An unfixableError is returned when
+// it's not visible at all.
+func (plugin *FixVisibilityPlugin) grantRepo(consumerRepo, toFixRepo string) (string, error) {
+	switch {
+	case consumerRepo == toFixRepo:
+		return consumerRepo, nil
+	case consumerRepo == "" || consumerRepo == "@":
+		// The main repository is visible as @ from every repository.
+		if toFixRepo == "" || toFixRepo == "@" {
+			return consumerRepo, nil
+		}
+		return "@", nil
+	case toFixRepo == "" || toFixRepo == "@":
+		return consumerRepo, nil
+	}
+
+	workspaceRoot, err := plugin.workspaceRoot()
+	if err != nil {
+		return "", err
+	}
+	rootModule, err := loadModuleFile(workspaceRoot)
+	if err != nil {
+		return "", err
+	}
+	// Without Bzlmod, repository names are the same from every repository.
+	if rootModule == nil {
+		return consumerRepo, nil
+	}
+	toFixModule, err := loadModuleFile(plugin.localRepositories[toFixRepo])
+	if err != nil {
+		return "", err
+	}
+	if toFixModule == nil {
+		return consumerRepo, nil
+	}
+
+	consumerModule := rootModule.moduleName(consumerRepo)
+	if consumerModule == toFixModule.name {
+		return "", nil
+	}
+	apparent, ok := toFixModule.deps[consumerModule]
+	if !ok {
+		return "", &unfixableError{reason: fmt.Sprintf("the @%s repository is not visible from the @%s repository", consumerRepo, toFixRepo)}
+	}
+	return apparent, nil
+}
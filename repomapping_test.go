@@ -0,0 +1,69 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGrantRepo(t *testing.T) {
+	workspaceRoot := writeModuleFile(t, `bazel_dep(name = "rules_foo", version = "1.0", repo_name = "foo")
+bazel_dep(name = "rules_bar", version = "1.0")
+bazel_dep(name = "rules_baz", version = "1.0")
+`)
+	fooDir := filepath.Join(workspaceRoot, "third_party/rules_foo")
+	if err := os.MkdirAll(fooDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	module := `module(name = "rules_foo")
+
+bazel_dep(name = "rules_bar", version = "1.0", repo_name = "bar")
+`
+	if err := os.WriteFile(filepath.Join(fooDir, "MODULE.bazel"), []byte(module), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plugin := &FixVisibilityPlugin{
+		workspaceDir:      workspaceRoot,
+		localRepositories: localRepositoryPaths{"foo": fooDir},
+	}
+	for _, test := range []struct {
+		consumerRepo, toFixRepo string
+		grantRepo               string
+		unfixable               bool
+	}{
+		{"", "", "", false},
+		{"foo", "foo", "foo", false},
+		{"", "foo", "@", false},
+		{"@", "foo", "@", false},
+		{"foo", "", "foo", false},
+		{"rules_bar", "foo", "bar", false},
+		{"rules_baz", "foo", "", true},
+		{"rules_bar", "rules_baz", "rules_bar", false},
+	} {
+		grantRepo, err := plugin.grantRepo(test.consumerRepo, test.toFixRepo)
+		if test.unfixable {
+			var unfixable *unfixableError
+			if !errors.As(err, &unfixable) {
+				t.Errorf("grantRepo(%q, %q) = %q, %v, want an unfixable error", test.consumerRepo, test.toFixRepo, grantRepo, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("grantRepo(%q, %q) failed: %v", test.consumerRepo, test.toFixRepo, err)
+			continue
+		}
+		if grantRepo != test.grantRepo {
+			t.Errorf("grantRepo(%q, %q) = %q, want %q", test.consumerRepo, test.toFixRepo, grantRepo, test.grantRepo)
+		}
+	}
+}
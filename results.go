@@ -0,0 +1,111 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// resultsTimeout bounds the time spent publishing the results, so that an
+// unavailable results API doesn't hold the terminal.
+const resultsTimeout = 10 * time.Second
+
+// fixStatusNames are the names of the fix statuses in the published results.
+var fixStatusNames = map[fixStatus]string{
+	fixPrinted:  "proposed",
+	fixApplied:  "applied",
+	fixDeclined: "declined",
+	fixObsolete: "obsolete",
+}
+
+// buildResults are the fix results of an invocation, published to the results
+// API configured by the results_url property.
+type buildResults struct {
+	// InvocationID is the ID of the build the fixes were detected in, as
+	// reported by the BuildStarted event, which the results API associates the
+	// fixes with.
+	InvocationID string      `json:"invocation_id"`
+	Fixes        []fixResult `json:"fixes"`
+	// Quarantined are the fixes blocked by the configuration.
+	Quarantined []quarantined `json:"quarantined,omitempty"`
+	// redact redacts the labels and the paths sent to the results API, as set
+	// by the redact_labels property.
+	redact func(string) string
+}
+
+// fixResult is the outcome of a single fix.
+type fixResult struct {
+	ToFix    string `json:"to_fix"`
+	From     string `json:"from"`
+	Grant    string `json:"grant"`
+	Status   string `json:"status"`
+	Severity string `json:"severity"`
+	// Score is the severity as a number, higher being more severe.
+	Score    int      `json:"score"`
+	Commands []string `json:"commands"`
+	// Unblocks are the top-level targets whose analysis only failed because
+	// of the issue.
+	Unblocks []string `json:"unblocks,omitempty"`
+}
+
+// add adds the outcome of the given fix to the results, along with the
+// top-level targets it unblocks.
+func (results *buildResults) add(fix *visibilityFix, status fixStatus, unblocks []string) {
+	result := fixResult{
+		ToFix:    results.redact(fix.node.toFix),
+		From:     results.redact(fix.node.from),
+		Grant:    results.redact(fix.grant.String()),
+		Status:   fixStatusNames[status],
+		Severity: severityNames[fix.severity],
+		Score:    fix.severity,
+	}
+	for _, t := range unblocks {
+		result.Unblocks = append(result.Unblocks, results.redact(t))
+	}
+	for _, e := range fix.fileEdits {
+		result.Commands = append(result.Commands, results.redact(commentLines(e.String())))
+	}
+	for _, c := range fix.commands {
+		result.Commands = append(result.Commands, results.redact(fmt.Sprintf("buildozer '%s' %s", c.command, c.target)))
+	}
+	results.Fixes = append(results.Fixes, result)
+}
+
+// addQuarantined adds the given fixes blocked by the configuration to the
+// results.
+func (results *buildResults) addQuarantined(blocked []quarantined) {
+	for _, q := range blocked {
+		q.ToFix, q.From = results.redact(q.ToFix), results.redact(q.From)
+		q.Rule, q.Override = results.redact(q.Rule), results.redact(q.Override)
+		results.Quarantined = append(results.Quarantined, q)
+	}
+}
+
+// publish POSTs the results as JSON to the given URL.
+func (results *buildResults) publish(url string) error {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return fmt.Errorf("failed to publish results: %w", err)
+	}
+	client := &http.Client{Timeout: resultsTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to publish results: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to publish results: %s responded %s", url, resp.Status)
+	}
+	return nil
+}
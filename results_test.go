@@ -0,0 +1,73 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+func TestPublishResults(t *testing.T) {
+	var published buildResults
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&published); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	results := &buildResults{InvocationID: "1234", redact: func(s string) string { return s }}
+	results.add(&visibilityFix{
+		node:     &fixNode{toFix: "//lib:a", from: "//app:app"},
+		grant:    label.New("", "app", "__pkg__"),
+		severity: severityCrossTeam,
+		commands: []buildozerCommand{{command: "add visibility //app:__pkg__", target: "//lib:a"}},
+	}, fixApplied, []string{"//app:app"})
+	if err := results.publish(server.URL); err != nil {
+		t.Fatal(err)
+	}
+	want := buildResults{
+		InvocationID: "1234",
+		Fixes: []fixResult{{
+			ToFix:    "//lib:a",
+			From:     "//app:app",
+			Grant:    "//app:__pkg__",
+			Status:   "applied",
+			Severity: "cross-team",
+			Score:    severityCrossTeam,
+			Commands: []string{"buildozer 'add visibility //app:__pkg__' //lib:a"},
+			Unblocks: []string{"//app:app"},
+		}},
+	}
+	if !reflect.DeepEqual(published, want) {
+		t.Errorf("published %+v, want %+v", published, want)
+	}
+}
+
+func TestPublishResultsFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	results := &buildResults{InvocationID: "1234", redact: func(s string) string { return s }}
+	if err := results.publish(server.URL); err == nil {
+		t.Errorf("publish() succeeded, want the failure of the results API")
+	}
+}
@@ -0,0 +1,279 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"aspect.build/cli/pkg/ioutils"
+	"github.com/manifoldco/promptui"
+)
+
+// reviewHelp describes the commands of the review screen.
+const reviewHelp = `Commands:
+  <N>...     toggle the fixes with the given numbers, e.g. "1 3 5-8"
+  a          select all the listed fixes
+  n          deselect all the listed fixes
+  /<text>    list only the fixes whose target or consumer contains the text, "/" lists all
+  d <N>      show the edits of the fix with the given number
+  q          skip all the fixes
+  <empty>    apply the selected fixes`
+
+// reviewScreen is the state of the review of a set of fixes.
+type reviewScreen struct {
+	fixes    []*visibilityFix
+	selected map[*visibilityFix]bool
+	// manual are the fixes that can't be selected, since their risk tier
+	// requires applying them manually.
+	manual map[*visibilityFix]bool
+	filter string
+}
+
+// reviewFixes lets the user review the given fixes at once, instead of being
+// asked about each of them in turn, marking them as reviewed and the selected
+// ones as accepted. The review is full-screen, with the diff of the edits of
+// the highlighted fix, on terminals large enough for it, and driven by line
+// commands otherwise. The reviewed fixes are still subject to the
+// confirmation of their risk tier and to the deprecation of their target.
+func (plugin *FixVisibilityPlugin) reviewFixes(fixes []*visibilityFix, promptRunner ioutils.PromptRunner) {
+	screen := &reviewScreen{
+		fixes:    fixes,
+		selected: make(map[*visibilityFix]bool),
+		manual:   make(map[*visibilityFix]bool),
+	}
+	for _, fix := range fixes {
+		if plugin.confirmation(fix.tier) == confirmManual {
+			screen.manual[fix] = true
+		}
+	}
+
+	if plugin.openTerminal != nil && !plugin.plainOutput() {
+		term, err := plugin.openTerminal()
+		if err == nil {
+			review := &fullScreenReview{reviewScreen: screen, plugin: plugin, term: term, diffs: make(map[*visibilityFix][]string)}
+			applied := review.run()
+			term.close()
+			if !applied {
+				screen.selected = make(map[*visibilityFix]bool)
+			}
+			fmt.Fprintf(os.Stdout, "%d of %d fixes selected in the review.\n", len(screen.selectedFixes()), len(fixes))
+			plugin.markReviewed(screen)
+			return
+		}
+		plugin.debug.printf("reviewing the fixes with line commands: %v", err)
+	}
+
+	reviewPrompt := plugin.prompt(promptui.Prompt{
+		Label:    "Review command, ? for help, empty to apply the selected fixes",
+		Validate: func(input string) error { return screen.command(input, false) },
+	})
+
+	fmt.Fprintf(os.Stdout, "%d visibility fixes to review.\n", len(fixes))
+	screen.print()
+	for {
+		input, err := promptRunner.Run(reviewPrompt)
+		// Aborting the prompt skips all the fixes, like answering NO to each of
+		// them.
+		if err != nil {
+			screen.selected = make(map[*visibilityFix]bool)
+			break
+		}
+		input = strings.TrimSpace(input)
+		if input == "" {
+			break
+		}
+		if input == "q" {
+			screen.selected = make(map[*visibilityFix]bool)
+			break
+		}
+		if err := screen.command(input, true); err != nil {
+			fmt.Fprintf(os.Stdout, "%v\n", err)
+		}
+	}
+
+	plugin.markReviewed(screen)
+}
+
+// markReviewed marks the fixes of the review as reviewed, and the selected
+// ones as accepted.
+func (plugin *FixVisibilityPlugin) markReviewed(screen *reviewScreen) {
+	for _, fix := range screen.fixes {
+		fix.reviewed = true
+		fix.accepted = screen.selected[fix]
+	}
+}
+
+// command validates the given review command, also performing it when
+// perform is set.
+func (s *reviewScreen) command(input string, perform bool) error {
+	input = strings.TrimSpace(input)
+	fields := strings.Fields(input)
+	switch {
+	case input == "" || input == "q":
+		return nil
+	case input == "?":
+		if perform {
+			fmt.Fprintf(os.Stdout, "%s\n", reviewHelp)
+		}
+		return nil
+	case input == "a" || input == "n":
+		if perform {
+			s.selectListed(input == "a")
+			s.print()
+		}
+		return nil
+	case strings.HasPrefix(input, "/"):
+		if perform {
+			s.filter = strings.TrimSpace(strings.TrimPrefix(input, "/"))
+			s.print()
+		}
+		return nil
+	case fields[0] == "d":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: d <N>")
+		}
+		i, err := s.index(fields[1])
+		if err != nil {
+			return err
+		}
+		if perform {
+			s.printEdits(s.fixes[i])
+		}
+		return nil
+	}
+
+	var indices []int
+	for _, field := range fields {
+		from, to, isRange := strings.Cut(field, "-")
+		if !isRange {
+			to = from
+		}
+		first, err := s.index(from)
+		if err != nil {
+			return err
+		}
+		last, err := s.index(to)
+		if err != nil {
+			return err
+		}
+		for i := first; i <= last; i++ {
+			indices = append(indices, i)
+		}
+	}
+	if perform {
+		for _, i := range indices {
+			if message := s.toggle(s.fixes[i]); message != "" {
+				fmt.Fprintf(os.Stdout, "%s\n", message)
+			}
+		}
+		s.print()
+	}
+	return nil
+}
+
+// toggle toggles the selection of the given fix, unless it must be applied
+// manually, in which case it returns the message telling so.
+func (s *reviewScreen) toggle(fix *visibilityFix) string {
+	if s.manual[fix] {
+		return fmt.Sprintf("The %s fix of %s must be applied manually", fix.tier, fix.node.toFix)
+	}
+	s.selected[fix] = !s.selected[fix]
+	return ""
+}
+
+// selectListed selects, or deselects, all the listed fixes that can be.
+func (s *reviewScreen) selectListed(selected bool) {
+	for _, fix := range s.listed() {
+		if !s.manual[fix] {
+			s.selected[fix] = selected
+		}
+	}
+}
+
+// index returns the index of the fix with the given number.
+func (s *reviewScreen) index(number string) (int, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 || n > len(s.fixes) {
+		return 0, fmt.Errorf("invalid fix number %q: must be between 1 and %d", number, len(s.fixes))
+	}
+	return n - 1, nil
+}
+
+// listed returns the fixes matching the filter.
+func (s *reviewScreen) listed() []*visibilityFix {
+	var listed []*visibilityFix
+	for _, fix := range s.fixes {
+		if s.filter == "" || strings.Contains(fix.node.toFix, s.filter) || strings.Contains(fix.consumersString(), s.filter) {
+			listed = append(listed, fix)
+		}
+	}
+	return listed
+}
+
+// numbers returns the numbers of the fixes, by which the commands refer to
+// them.
+func (s *reviewScreen) numbers() map[*visibilityFix]int {
+	numbers := make(map[*visibilityFix]int, len(s.fixes))
+	for i, fix := range s.fixes {
+		numbers[fix] = i + 1
+	}
+	return numbers
+}
+
+// row returns the line listing the given fix with the given number, along
+// with whether it's selected.
+func (s *reviewScreen) row(fix *visibilityFix, number int) string {
+	mark := "[ ]"
+	switch {
+	case s.manual[fix]:
+		mark = "[-]"
+	case s.selected[fix]:
+		mark = "[x]"
+	}
+	return fmt.Sprintf("%s %3d. %s from %s (%s, %s)",
+		mark, number, fix.node.toFix, fix.consumersString(), fix.strategy, severityNames[fix.severity])
+}
+
+// print lists the fixes matching the filter, along with whether they are
+// selected.
+func (s *reviewScreen) print() {
+	numbers := s.numbers()
+	for _, fix := range s.listed() {
+		fmt.Fprintf(os.Stdout, "%s\n", s.row(fix, numbers[fix]))
+	}
+	if s.filter != "" {
+		fmt.Fprintf(os.Stdout, "Listing the fixes matching %q.\n", s.filter)
+	}
+	fmt.Fprintf(os.Stdout, "%d of %d fixes selected.\n", len(s.selectedFixes()), len(s.fixes))
+}
+
+// selectedFixes returns the selected fixes.
+func (s *reviewScreen) selectedFixes() []*visibilityFix {
+	var selected []*visibilityFix
+	for _, fix := range s.fixes {
+		if s.selected[fix] {
+			selected = append(selected, fix)
+		}
+	}
+	return selected
+}
+
+// printEdits prints the edits the fix would perform.
+func (s *reviewScreen) printEdits(fix *visibilityFix) {
+	for _, e := range fix.fileEdits {
+		fmt.Fprintf(os.Stdout, "%s\n", commentLines(e.String()))
+	}
+	for _, c := range append(append([]buildozerCommand{}, fix.commands...), fix.cleanupCommands...) {
+		fmt.Fprintf(os.Stdout, "buildozer '%s' %s\n", c.command, c.target)
+	}
+}
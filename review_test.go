@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReviewCommand(t *testing.T) {
+	var fixes []*visibilityFix
+	for _, toFix := range []string{"//lib:a", "//lib:b", "//tools:c", "//tools:d", "//lib:e"} {
+		fixes = append(fixes, &visibilityFix{node: &fixNode{toFix: toFix, from: "//app:app"}, tier: riskCrossTree})
+	}
+	for _, test := range []struct {
+		commands []string
+		selected []int
+		invalid  string
+	}{
+		{[]string{"1 3"}, []int{1, 3}, ""},
+		{[]string{"2-4"}, []int{2, 3}, ""},
+		{[]string{"1-3", "2"}, []int{1, 3}, ""},
+		{[]string{"a"}, []int{1, 2, 3, 5}, ""},
+		{[]string{"a", "n"}, nil, ""},
+		{[]string{"/tools", "a", "/"}, []int{3}, ""},
+		{[]string{"/lib", "a", "/ ", "2"}, []int{1, 5}, ""},
+		{[]string{"4"}, nil, ""},
+		{[]string{"d 2", "?", "q"}, nil, ""},
+		{nil, nil, "0"},
+		{nil, nil, "6"},
+		{nil, nil, "1-x"},
+		{nil, nil, "d"},
+		{nil, nil, "d 9"},
+	} {
+		screen := &reviewScreen{
+			fixes:    fixes,
+			selected: make(map[*visibilityFix]bool),
+			manual:   map[*visibilityFix]bool{fixes[3]: true},
+		}
+		if test.invalid != "" {
+			if err := screen.command(test.invalid, false); err == nil {
+				t.Errorf("command(%q) succeeded, want an error", test.invalid)
+			}
+			continue
+		}
+		captureStdout(t, func() {
+			for _, command := range test.commands {
+				if err := screen.command(command, false); err != nil {
+					t.Errorf("command(%q) failed: %v", command, err)
+				}
+				screen.command(command, true)
+			}
+		})
+		var selected []int
+		for i, fix := range fixes {
+			if screen.selected[fix] {
+				selected = append(selected, i+1)
+			}
+		}
+		if !reflect.DeepEqual(selected, test.selected) {
+			t.Errorf("commands %q selected the fixes %v, want %v", test.commands, selected, test.selected)
+		}
+	}
+}
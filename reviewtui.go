@@ -0,0 +1,573 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/bazelbuild/buildtools/edit"
+)
+
+// reviewMinRows and reviewMinCols are the size of the smallest terminal the
+// full-screen review fits in. The review of smaller terminals is driven by
+// line commands instead.
+const (
+	reviewMinRows = 16
+	reviewMinCols = 72
+)
+
+// diffContext is the number of unchanged lines shown around the changes in
+// the diff pane.
+const diffContext = 3
+
+// The keys of the full-screen review, as sent by the terminal in raw mode.
+const (
+	keyUp        = "\x1b[A"
+	keyDown      = "\x1b[B"
+	keyPageUp    = "\x1b[5~"
+	keyPageDown  = "\x1b[6~"
+	keyEnter     = "\r"
+	keyEscape    = "\x1b"
+	keyBackspace = "\x7f"
+	keyCtrlC     = "\x03"
+)
+
+// reviewKeys is the help line of the full-screen review.
+const reviewKeys = "↑↓ move  space toggle  a/n all/none  / filter  J/K scroll edits  enter apply  q skip all"
+
+// reviewTerminal is the controlling terminal, in raw mode and switched to the
+// alternate screen for the duration of the full-screen review.
+type reviewTerminal struct {
+	tty   *os.File
+	rows  int
+	cols  int
+	saved string
+}
+
+// readKeys returns the next keys pressed, as the bytes the terminal sends for
+// each of them: an escape sequence or a character.
+func (t *reviewTerminal) readKeys() ([]string, error) {
+	buf := make([]byte, 64)
+	n, err := t.tty.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+	return splitKeys(string(buf[:n])), nil
+}
+
+// splitKeys splits the given input of the terminal into keys.
+func splitKeys(input string) []string {
+	var keys []string
+	for input != "" {
+		size := 0
+		if strings.HasPrefix(input, keyEscape+"[") {
+			// A control sequence ends with a byte in the @ to ~ range.
+			size = strings.IndexFunc(input[2:], func(r rune) bool { return r >= '@' && r <= '~' }) + 3
+			if size < 3 {
+				size = len(input)
+			}
+		} else {
+			_, size = utf8.DecodeRuneInString(input)
+		}
+		keys = append(keys, input[:size])
+		input = input[size:]
+	}
+	return keys
+}
+
+// fullScreenReview is the state of the full-screen review, on top of the
+// state of the review screen shared with the line commands.
+type fullScreenReview struct {
+	*reviewScreen
+	plugin *FixVisibilityPlugin
+	term   *reviewTerminal
+	// cursor is the position of the highlighted fix among the listed ones,
+	// and top the position of the first one shown.
+	cursor int
+	top    int
+	// scroll is the first line shown of the diff pane.
+	scroll int
+	// filtering is set while the filter is typed, with the text typed so
+	// far in input.
+	filtering bool
+	input     string
+	status    string
+	diffs     map[*visibilityFix][]string
+}
+
+// run lets the user review the fixes until they apply the selected ones, in
+// which case it returns true, or skip all of them.
+func (r *fullScreenReview) run() bool {
+	for {
+		r.draw()
+		keys, err := r.term.readKeys()
+		if err != nil {
+			return false
+		}
+		r.status = ""
+		for _, key := range keys {
+			if done, applied := r.press(key); done {
+				return applied
+			}
+		}
+	}
+}
+
+// press handles the given key, returning whether the review is done, and if
+// so whether the selected fixes are applied.
+func (r *fullScreenReview) press(key string) (done, applied bool) {
+	if r.filtering {
+		r.typeFilter(key)
+		return false, false
+	}
+	listed := r.listed()
+	switch key {
+	case keyUp, "k":
+		r.move(-1)
+	case keyDown, "j":
+		r.move(1)
+	case keyPageUp:
+		r.move(-r.listRows())
+	case keyPageDown:
+		r.move(r.listRows())
+	case "K":
+		r.scroll = maxInt(r.scroll-r.diffRows()/2, 0)
+	case "J":
+		r.scroll += r.diffRows() / 2
+	case " ", "x":
+		if r.cursor < len(listed) {
+			r.status = r.toggle(listed[r.cursor])
+		}
+	case "a", "n":
+		r.selectListed(key == "a")
+	case "/":
+		r.filtering, r.input = true, r.filter
+	case keyEnter:
+		return true, true
+	case "q", keyEscape, keyCtrlC:
+		return true, false
+	}
+	return false, false
+}
+
+// typeFilter handles the given key while the filter is typed.
+func (r *fullScreenReview) typeFilter(key string) {
+	switch key {
+	case keyEnter:
+		r.filtering, r.filter = false, r.input
+		r.cursor, r.top, r.scroll = 0, 0, 0
+	case keyEscape, keyCtrlC:
+		r.filtering = false
+	case keyBackspace, "\b":
+		if r.input != "" {
+			_, size := utf8.DecodeLastRuneInString(r.input)
+			r.input = r.input[:len(r.input)-size]
+		}
+	default:
+		if !strings.HasPrefix(key, keyEscape) && utf8.ValidString(key) && key >= " " {
+			r.input += key
+		}
+	}
+}
+
+// move moves the cursor by the given number of fixes.
+func (r *fullScreenReview) move(delta int) {
+	listed := r.listed()
+	r.cursor += delta
+	if r.cursor >= len(listed) {
+		r.cursor = len(listed) - 1
+	}
+	if r.cursor < 0 {
+		r.cursor = 0
+	}
+	if r.cursor < r.top {
+		r.top = r.cursor
+	}
+	if r.cursor >= r.top+r.listRows() {
+		r.top = r.cursor - r.listRows() + 1
+	}
+	r.scroll = 0
+}
+
+// listRows and diffRows are the heights of the list pane and the diff pane,
+// the rest of the screen being the header, the separator and the help line.
+func (r *fullScreenReview) listRows() int {
+	return (r.term.rows - 3) / 2
+}
+
+func (r *fullScreenReview) diffRows() int {
+	return r.term.rows - 3 - r.listRows()
+}
+
+// The styles of the full-screen review, only used with colors.
+const (
+	styleBold    = "\x1b[1m"
+	styleInverse = "\x1b[7m"
+	styleAdded   = "\x1b[32m"
+	styleRemoved = "\x1b[31m"
+	styleHunk    = "\x1b[36m"
+)
+
+// draw renders the screen: the list of the fixes, and the diff of the edits
+// of the highlighted one. Without colors, the highlighted fix is pointed at
+// instead.
+func (r *fullScreenReview) draw() {
+	colors := r.plugin.colorEnabled()
+	style := func(style string) string {
+		if !colors {
+			return ""
+		}
+		return style
+	}
+
+	var b strings.Builder
+	b.WriteString("\x1b[H\x1b[2J")
+	header := fmt.Sprintf("Review of the visibility fixes: %d of %d selected", len(r.selectedFixes()), len(r.fixes))
+	if r.filter != "" {
+		header += fmt.Sprintf(", listing those matching %q", r.filter)
+	}
+	r.line(&b, header, style(styleBold))
+
+	numbers := r.numbers()
+	listed := r.listed()
+	for i := r.top; i < r.top+r.listRows(); i++ {
+		if i >= len(listed) {
+			r.line(&b, "", "")
+			continue
+		}
+		row := r.row(listed[i], numbers[listed[i]])
+		rowStyle := ""
+		switch {
+		case i == r.cursor && colors:
+			rowStyle = styleInverse
+		case i == r.cursor:
+			row = "> " + row
+		case !colors:
+			row = "  " + row
+		}
+		r.line(&b, row, rowStyle)
+	}
+
+	var diff []string
+	separator := "── no fix matches the filter"
+	if r.cursor < len(listed) {
+		fix := listed[r.cursor]
+		separator = fmt.Sprintf("── edits of %s", fix.node.toFix)
+		diff = r.diff(fix)
+	}
+	r.line(&b, separator+" "+strings.Repeat("─", maxInt(r.term.cols-utf8.RuneCountInString(separator)-1, 0)), style(styleBold))
+	if r.scroll > maxInt(len(diff)-r.diffRows(), 0) {
+		r.scroll = maxInt(len(diff)-r.diffRows(), 0)
+	}
+	for i := r.scroll; i < r.scroll+r.diffRows(); i++ {
+		if i >= len(diff) {
+			r.line(&b, "", "")
+			continue
+		}
+		lineStyle := ""
+		switch {
+		case strings.HasPrefix(diff[i], "+"):
+			lineStyle = style(styleAdded)
+		case strings.HasPrefix(diff[i], "-"):
+			lineStyle = style(styleRemoved)
+		case strings.HasPrefix(diff[i], "@@"):
+			lineStyle = style(styleHunk)
+		}
+		r.line(&b, diff[i], lineStyle)
+	}
+
+	footer := reviewKeys
+	switch {
+	case r.filtering:
+		footer = "Filter by target or consumer, enter to apply, escape to cancel: " + r.input
+	case r.status != "":
+		footer = r.status
+	}
+	footer = truncate(footer, r.term.cols)
+	if colors {
+		footer = styleInverse + footer + "\x1b[0m"
+	}
+	b.WriteString(footer)
+	fmt.Fprint(r.term.tty, b.String())
+}
+
+// line renders a line of the screen, truncated to its width, in the given
+// style. In raw mode, the lines end with a carriage return too.
+func (r *fullScreenReview) line(b *strings.Builder, text, style string) {
+	text = truncate(strings.ReplaceAll(text, "\t", "    "), r.term.cols)
+	if style != "" {
+		text = style + text + "\x1b[0m"
+	}
+	b.WriteString(text + "\r\n")
+}
+
+// diff returns the diff of the edits of the given fix, computed once.
+func (r *fullScreenReview) diff(fix *visibilityFix) []string {
+	if diff, ok := r.diffs[fix]; ok {
+		return diff
+	}
+	diff, err := r.plugin.previewFix(fix)
+	if err != nil {
+		diff = []string{fmt.Sprintf("Cannot preview the edits: %v", err)}
+	}
+	if len(fix.cleanupCommands) > 0 {
+		diff = append(diff, "", "The removal of the redundant entries is offered after applying the fix:")
+		for _, c := range fix.cleanupCommands {
+			diff = append(diff, fmt.Sprintf("buildozer '%s' %s", c.command, c.target))
+		}
+	}
+	r.diffs[fix] = diff
+	return diff
+}
+
+// truncate returns the given text cut to the given number of columns.
+func truncate(text string, cols int) string {
+	if utf8.RuneCountInString(text) <= cols {
+		return text
+	}
+	runes := []rune(text)
+	return string(runes[:cols-1]) + "…"
+}
+
+// maxInt returns the greater of the given integers.
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// previewFix returns the diff of the files the fix edits, from their current
+// content. The buildozer commands are previewed with the buildozer library,
+// even when the buildozer_path property is set.
+func (plugin *FixVisibilityPlugin) previewFix(fix *visibilityFix) ([]string, error) {
+	var paths []string
+	original := map[string][]byte{}
+	updated := map[string][]byte{}
+	read := func(path string) ([]byte, error) {
+		if data, ok := updated[path]; ok {
+			return data, nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		paths = append(paths, path)
+		original[path], updated[path] = data, data
+		return data, nil
+	}
+
+	// The commands are run first, from the files on disk, then the file
+	// edits are applied on top of their result.
+	byFile := map[string][]buildozerCommand{}
+	var files []string
+	for _, c := range fix.commands {
+		path, _, _ := edit.InterpretLabelForWorkspaceLocation("", c.target)
+		if _, ok := byFile[path]; !ok {
+			files = append(files, path)
+		}
+		byFile[path] = append(byFile[path], c)
+	}
+	canonicalLabels := plugin.properties.LabelStyle == labelStyleCanonical
+	for _, path := range files {
+		if _, err := read(path); err != nil {
+			return nil, err
+		}
+		data, err := previewCommands(canonicalLabels, byFile[path])
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			updated[path] = data
+		}
+	}
+	for _, e := range fix.fileEdits {
+		switch e := e.(type) {
+		case *visibilityRewrite:
+			data, err := read(e.path)
+			if err != nil {
+				return nil, err
+			}
+			if updated[e.path], err = e.rewriteContent(data); err != nil {
+				return nil, err
+			}
+		case *allowlistEdit:
+			if _, err := read(e.path); err != nil {
+				return nil, err
+			}
+			data, err := e.updated()
+			if err != nil {
+				return nil, err
+			}
+			updated[e.path] = data
+		default:
+			paths = append(paths, "")
+			updated[""] = append(updated[""], []byte(e.String()+"\n")...)
+		}
+	}
+
+	workspaceRoot, _ := plugin.workspaceRoot()
+	var diff []string
+	for _, path := range paths {
+		if path == "" {
+			diff = append(diff, strings.Split(strings.TrimSuffix(string(updated[""]), "\n"), "\n")...)
+			continue
+		}
+		name := path
+		if rel, err := filepath.Rel(workspaceRoot, path); workspaceRoot != "" && err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+		diff = append(diff, unifiedDiff(name, original[path], updated[path])...)
+	}
+	return diff, nil
+}
+
+// previewCommands returns the content of the BUILD file edited by the given
+// commands, as the buildozer library would write it, or nil if they don't
+// change it.
+func previewCommands(canonicalLabels bool, commands []buildozerCommand) ([]byte, error) {
+	f, err := os.CreateTemp("", "fix-visibility-preview-*.txt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to preview the buildozer commands: %w", err)
+	}
+	defer os.Remove(f.Name())
+	for _, c := range commands {
+		fmt.Fprintf(f, "%s|%s\n", c.command, c.target)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to preview the buildozer commands: %w", err)
+	}
+
+	var stdout bytes.Buffer
+	var stderr strings.Builder
+	setBuildozerFlags(canonicalLabels)
+	ret := edit.Buildozer(&edit.Options{
+		Stdout:        true,
+		CommandsFiles: []string{f.Name()},
+		OutWriter:     &stdout,
+		ErrWriter:     &stderr,
+		NumIO:         200,
+	}, nil)
+	if err := buildozerError(ret, stderr.String()); err != nil {
+		return nil, err
+	}
+	if ret == 3 {
+		return nil, nil
+	}
+	return stdout.Bytes(), nil
+}
+
+// unifiedDiff returns the lines of the unified diff of the given contents of
+// the file at the given path.
+func unifiedDiff(path string, a, b []byte) []string {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+	before, after := splitLines(a), splitLines(b)
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// before[i:] and after[j:].
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = maxInt(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// ops are the lines of the diff, each prefixed with ' ', '-' or '+',
+	// along with their line numbers in both contents.
+	type op struct {
+		text string
+		i, j int
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			ops = append(ops, op{" " + before[i], i, j})
+			i++
+			j++
+		case i < len(before) && (j == len(after) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{"-" + before[i], i, j})
+			i++
+		default:
+			ops = append(ops, op{"+" + after[j], i, j})
+			j++
+		}
+	}
+
+	diff := []string{"--- " + path, "+++ " + path}
+	for start := 0; start < len(ops); {
+		if ops[start].text[0] == ' ' {
+			start++
+			continue
+		}
+		// A hunk spans the changes closer than twice the context.
+		first := maxInt(start-diffContext, 0)
+		end := start
+		for k := start; k < len(ops); k++ {
+			if ops[k].text[0] != ' ' {
+				end = k + 1
+			} else if k-end >= 2*diffContext {
+				break
+			}
+		}
+		last := end + diffContext
+		if last > len(ops) {
+			last = len(ops)
+		}
+		removed, added := 0, 0
+		for _, o := range ops[first:last] {
+			if o.text[0] != '+' {
+				removed++
+			}
+			if o.text[0] != '-' {
+				added++
+			}
+		}
+		diff = append(diff, fmt.Sprintf("@@ -%s +%s @@", hunkRange(ops[first].i, removed), hunkRange(ops[first].j, added)))
+		for _, o := range ops[first:last] {
+			diff = append(diff, o.text)
+		}
+		start = last
+	}
+	return diff
+}
+
+// hunkRange formats the range of lines of a hunk starting at the given
+// 0-based line.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return strconv.Itoa(start) + ",0"
+	}
+	return strconv.Itoa(start+1) + "," + strconv.Itoa(count)
+}
+
+// splitLines returns the lines of the given content.
+func splitLines(data []byte) []string {
+	if len(data) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+}
@@ -0,0 +1,25 @@
+//go:build !unix
+
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import "fmt"
+
+// openReviewTerminal fails, since the full-screen review relies on stty to
+// drive the terminal. The review is driven by line commands instead.
+func openReviewTerminal() (*reviewTerminal, error) {
+	return nil, fmt.Errorf("the full-screen review requires a Unix terminal")
+}
+
+// close closes the terminal.
+func (t *reviewTerminal) close() {
+	t.tty.Close()
+}
@@ -0,0 +1,118 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitKeys(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		keys  []string
+	}{
+		{"", nil},
+		{"j", []string{"j"}},
+		{"jk ", []string{"j", "k", " "}},
+		{"\x1b[A\x1b[B", []string{keyUp, keyDown}},
+		{"\x1b[5~x\x1b[6~", []string{keyPageUp, "x", keyPageDown}},
+		{"\x1b", []string{keyEscape}},
+		{"\x1b[", []string{"\x1b["}},
+		{"\x1b[12", []string{"\x1b[12"}},
+		{"é/", []string{"é", "/"}},
+		{"\r\x03", []string{keyEnter, keyCtrlC}},
+	} {
+		if keys := splitKeys(test.input); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("splitKeys(%q) = %q, want %q", test.input, keys, test.keys)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		cols int
+		want string
+	}{
+		{"", 4, ""},
+		{"abcd", 4, "abcd"},
+		{"abcde", 4, "abc…"},
+		{"── edits", 4, "── …"},
+		{"ab", 1, "…"},
+	} {
+		if got := truncate(test.text, test.cols); got != test.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", test.text, test.cols, got, test.want)
+		}
+	}
+}
+
+func TestHunkRange(t *testing.T) {
+	for _, test := range []struct {
+		start, count int
+		want         string
+	}{
+		{0, 0, "0,0"},
+		{0, 1, "1,1"},
+		{4, 3, "5,3"},
+		{4, 0, "4,0"},
+	} {
+		if got := hunkRange(test.start, test.count); got != test.want {
+			t.Errorf("hunkRange(%d, %d) = %q, want %q", test.start, test.count, got, test.want)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	lines := func(n int) string {
+		var b strings.Builder
+		for i := 1; i <= n; i++ {
+			b.WriteString(strings.Repeat("x", i) + "\n")
+		}
+		return b.String()
+	}
+	for _, test := range []struct {
+		name string
+		a, b string
+		diff []string
+	}{
+		{"unchanged", "a\nb\n", "a\nb\n", nil},
+		{"created", "", "a\n", []string{"--- BUILD", "+++ BUILD", "@@ -0,0 +1,1 @@", "+a"}},
+		{"deleted", "a\n", "", []string{"--- BUILD", "+++ BUILD", "@@ -1,1 +0,0 @@", "-a"}},
+		{"changed", "a\nb\nc\n", "a\nB\nc\n", []string{"--- BUILD", "+++ BUILD", "@@ -1,3 +1,3 @@", " a", "-b", "+B", " c"}},
+		{
+			"context",
+			lines(10),
+			strings.Replace(lines(10), "xxxxx\n", "xxxxx\ny\n", 1),
+			[]string{"--- BUILD", "+++ BUILD", "@@ -3,6 +3,7 @@", " xxx", " xxxx", " xxxxx", "+y", " xxxxxx", " xxxxxxx", " xxxxxxxx"},
+		},
+		{
+			"hunks",
+			lines(12),
+			strings.Replace(strings.Replace(lines(12), "x\n", "y\n", 1), "xxxxxxxxxxxx\n", "z\n", 1),
+			[]string{
+				"--- BUILD", "+++ BUILD",
+				"@@ -1,4 +1,4 @@", "-x", "+y", " xx", " xxx", " xxxx",
+				"@@ -9,4 +9,4 @@", " xxxxxxxxx", " xxxxxxxxxx", " xxxxxxxxxxx", "-xxxxxxxxxxxx", "+z",
+			},
+		},
+		{
+			"close changes",
+			lines(6),
+			strings.Replace(strings.Replace(lines(6), "x\n", "y\n", 1), "xxxxxx\n", "z\n", 1),
+			[]string{"--- BUILD", "+++ BUILD", "@@ -1,6 +1,6 @@", "-x", "+y", " xx", " xxx", " xxxx", " xxxxx", "-xxxxxx", "+z"},
+		},
+	} {
+		if diff := unifiedDiff("BUILD", []byte(test.a), []byte(test.b)); !reflect.DeepEqual(diff, test.diff) {
+			t.Errorf("%s: unifiedDiff() = %q, want %q", test.name, diff, test.diff)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+//go:build unix
+
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// ttyPath is the controlling terminal of the plugin. The standard streams of
+// the plugin are piped through the CLI, so the full-screen review draws on
+// the terminal directly.
+const ttyPath = "/dev/tty"
+
+// openReviewTerminal opens the controlling terminal for the full-screen
+// review. It fails when there's none, e.g. in CI, or when it's too small, in
+// which case the review is driven by line commands.
+func openReviewTerminal() (*reviewTerminal, error) {
+	tty, err := os.OpenFile(ttyPath, os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the terminal: %w", err)
+	}
+	t := &reviewTerminal{tty: tty}
+	size, err := t.stty("size")
+	if err == nil {
+		_, err = fmt.Sscan(size, &t.rows, &t.cols)
+	}
+	if err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("failed to get the size of the terminal: %w", err)
+	}
+	if t.rows < reviewMinRows || t.cols < reviewMinCols {
+		tty.Close()
+		return nil, fmt.Errorf("the terminal is %dx%d, smaller than the %dx%d of the full-screen review", t.cols, t.rows, reviewMinCols, reviewMinRows)
+	}
+	if t.saved, err = t.stty("-g"); err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("failed to get the terminal settings: %w", err)
+	}
+	if _, err := t.stty("raw", "-echo"); err != nil {
+		tty.Close()
+		return nil, fmt.Errorf("failed to set the terminal in raw mode: %w", err)
+	}
+	// The alternate screen keeps the output of the build intact.
+	fmt.Fprint(tty, "\x1b[?1049h\x1b[?25l")
+	return t, nil
+}
+
+// stty runs stty on the terminal with the given arguments, returning its
+// output.
+func (t *reviewTerminal) stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = t.tty
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s: %w: %s", strings.Join(args, " "), err, stderr.String())
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// close restores the screen and the settings of the terminal.
+func (t *reviewTerminal) close() {
+	fmt.Fprint(t.tty, "\x1b[?25h\x1b[?1049l")
+	t.stty(t.saved)
+	t.tty.Close()
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"aspect.build/cli/pkg/ioutils"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/manifoldco/promptui"
+)
+
+// The risk tiers of the fixes, by how far the grant crosses the tree.
+const (
+	// riskPackage is a grant to the package of the target being fixed, or
+	// its subpackages.
+	riskPackage = "package"
+	// riskTopLevelDir is a grant within the top-level directory of the target
+	// being fixed.
+	riskTopLevelDir = "top_level_dir"
+	// riskCrossTree is any other grant, including across repositories.
+	riskCrossTree = "cross_tree"
+)
+
+// The possible confirmations required to apply a fix in interactive mode,
+// set by tier in the risk_confirmation property.
+const (
+	// confirmAuto applies the fix without asking.
+	confirmAuto = "auto"
+	// confirmPrompt asks the regular y/N question. This is the default.
+	confirmPrompt = "prompt"
+	// confirmStrong asks the user to type the name of the target being fixed.
+	confirmStrong = "strong"
+	// confirmManual never applies the fix, printing the commands instead.
+	confirmManual = "manual"
+)
+
+// riskTierOf returns the risk tier of granting the given entry on the given
+// target.
+func riskTierOf(grant, toFix label.Label) string {
+	if isExternal(grant) != isExternal(toFix) || (isExternal(grant) && grant.Repo != toFix.Repo) {
+		return riskCrossTree
+	}
+	if grant.Pkg == toFix.Pkg {
+		return riskPackage
+	}
+	grantDir := strings.SplitN(grant.Pkg, "/", 2)[0]
+	toFixDir := strings.SplitN(toFix.Pkg, "/", 2)[0]
+	if grantDir != "" && grantDir == toFixDir {
+		return riskTopLevelDir
+	}
+	return riskCrossTree
+}
+
+// confirmation returns the confirmation required to apply fixes of the given
+// risk tier. When the hook applies the fixes without asking, only the tiers
+// configured as manual are still not applied.
+func (plugin *FixVisibilityPlugin) confirmation(tier string) string {
+	c, ok := plugin.properties.RiskConfirmation[tier]
+	if !ok {
+		c = confirmPrompt
+	}
+	if plugin.autoApply && c != confirmManual {
+		return confirmAuto
+	}
+	return c
+}
+
+// confirmStrongly asks the user to type the name of the target being fixed to
+// apply the fix.
+func (plugin *FixVisibilityPlugin) confirmStrongly(fix *visibilityFix, promptRunner ioutils.PromptRunner) bool {
+	toFixLabel, err := label.Parse(fix.node.toFix)
+	if err != nil {
+		return false
+	}
+	fmt.Fprintf(os.Stdout, "The %s fix grants %s access to %s.\n", fix.tier, fix.grantsString(), fix.node.toFix)
+	strongPrompt := plugin.prompt(promptui.Prompt{
+		Label: fmt.Sprintf("Type %q to auto-fix the visibility attribute, or leave empty to skip", toFixLabel.Name),
+		Validate: func(input string) error {
+			if input = strings.TrimSpace(input); input != "" && input != toFixLabel.Name {
+				return fmt.Errorf("type %q or leave empty", toFixLabel.Name)
+			}
+			return nil
+		},
+	})
+	answer, err := promptRunner.Run(strongPrompt)
+	return err == nil && strings.TrimSpace(answer) == toFixLabel.Name
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+func TestRiskTierOf(t *testing.T) {
+	for _, test := range []struct {
+		grant, toFix string
+		tier         string
+	}{
+		{"//lib:__pkg__", "//lib:a", riskPackage},
+		{"//lib:__subpackages__", "//lib:a", riskPackage},
+		{"//lib/sub:__pkg__", "//lib:a", riskTopLevelDir},
+		{"//lib:__pkg__", "//lib/deep/sub:a", riskTopLevelDir},
+		{"//app:__pkg__", "//lib:a", riskCrossTree},
+		{"//:__pkg__", "//lib:a", riskCrossTree},
+		{"//:__subpackages__", "//:a", riskPackage},
+		{"@other//lib:__pkg__", "//lib:a", riskCrossTree},
+		{"//lib:__pkg__", "@other//lib:a", riskCrossTree},
+		{"@other//lib/sub:__pkg__", "@other//lib:a", riskTopLevelDir},
+		{"@third//lib:__pkg__", "@other//lib:a", riskCrossTree},
+	} {
+		grant, err := label.Parse(test.grant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		toFix, err := label.Parse(test.toFix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tier := riskTierOf(grant, toFix); tier != test.tier {
+			t.Errorf("riskTierOf(%s, %s) = %s, want %s", test.grant, test.toFix, tier, test.tier)
+		}
+	}
+}
+
+func TestConfirmation(t *testing.T) {
+	properties := &pluginProperties{RiskConfirmation: map[string]string{
+		riskTopLevelDir: confirmStrong,
+		riskCrossTree:   confirmManual,
+	}}
+	for _, test := range []struct {
+		tier         string
+		autoApply    bool
+		confirmation string
+	}{
+		{riskPackage, false, confirmPrompt},
+		{riskTopLevelDir, false, confirmStrong},
+		{riskCrossTree, false, confirmManual},
+		{riskPackage, true, confirmAuto},
+		{riskTopLevelDir, true, confirmAuto},
+		{riskCrossTree, true, confirmManual},
+	} {
+		plugin := &FixVisibilityPlugin{properties: properties, autoApply: test.autoApply}
+		if confirmation := plugin.confirmation(test.tier); confirmation != test.confirmation {
+			t.Errorf("confirmation(%s) = %s with autoApply %t, want %s", test.tier, confirmation, test.autoApply, test.confirmation)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"sort"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// The severities of the fixes, from the least to the most severe.
+const (
+	// severitySameTeam is a grant within the team owning the target being
+	// fixed.
+	severitySameTeam = iota + 1
+	// severityCrossTeam is a grant to another team.
+	severityCrossTeam
+	// severityPublic is a grant as wide as public visibility.
+	severityPublic
+)
+
+// severityNames are the names of the severities in the output.
+var severityNames = map[int]string{
+	severitySameTeam:  "same-team",
+	severityCrossTeam: "cross-team",
+	severityPublic:    "public",
+}
+
+// severityOf returns the severity of granting the given entry on the given
+// target. Teams are delimited by the CODEOWNERS directory rules when there
+// are any, otherwise by the top-level directories.
+func (plugin *FixVisibilityPlugin) severityOf(grant, toFix label.Label) (int, error) {
+	if isPublic(grant) || (grant.Name == "__subpackages__" && grant.Pkg == "") {
+		return severityPublic, nil
+	}
+	if riskTierOf(grant, toFix) == riskCrossTree {
+		return severityCrossTeam, nil
+	}
+	if !isExternal(toFix) {
+		if plugin.codeowners == nil {
+			workspaceRoot, err := plugin.workspaceRoot()
+			if err != nil {
+				return 0, err
+			}
+			if plugin.codeowners, err = loadCodeowners(workspaceRoot); err != nil {
+				return 0, err
+			}
+		}
+		grantTeam, grantOwned := plugin.codeowners.teamRoot(grant.Pkg)
+		toFixTeam, toFixOwned := plugin.codeowners.teamRoot(toFix.Pkg)
+		if (grantOwned || toFixOwned) && grantTeam != toFixTeam {
+			return severityCrossTeam, nil
+		}
+	}
+	return severitySameTeam, nil
+}
+
+// sortBySeverity sorts the fixes from the most to the least severe, keeping
+// the order of the fixes of the same severity.
+func sortBySeverity(fixes []*visibilityFix) {
+	sort.SliceStable(fixes, func(i, j int) bool {
+		return fixes[i].severity > fixes[j].severity
+	})
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// pluginDir is the directory, relative to the workspace root, where the plugin
+// keeps its files.
+const pluginDir = ".aspect/fix-visibility"
+
+// stateFilename is the name of the file in pluginDir where the fixes still
+// pending at the end of an invocation are handed off to the next one.
+const stateFilename = "state.json"
+
+// pluginFilePath returns the path of the given file in the plugin directory
+// under the workspace root.
+func pluginFilePath(workspaceRoot, filename string) string {
+	return filepath.Join(workspaceRoot, pluginDir, filename)
+}
+
+// pluginState is the state persisted across invocations when the
+// persist_fixes property is set.
+type pluginState struct {
+	Pending []pendingFix `json:"pending"`
+
+	// index maps the issues tracked by the invocation to their position in
+	// Pending, settled tells which of them were fixed, declined or found
+	// unfixable, and printed which of them had a fix printed, which keeps
+	// them pending.
+	index   map[fixKey]int
+	settled map[int]bool
+	printed map[int]bool
+}
+
+// pendingFix is a visibility issue that was detected, but neither applied nor
+// declined by the user.
+type pendingFix struct {
+	ToFix string `json:"to_fix"`
+	From  string `json:"from"`
+}
+
+// loadState reads the persisted state. A missing state file results in an
+// empty state.
+func loadState(workspaceRoot string) (*pluginState, error) {
+	path := pluginFilePath(workspaceRoot, stateFilename)
+	state := &pluginState{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	return state, nil
+}
+
+// loadPendingFixes adds the fixes handed off by the previous invocation to
+// the ones collected by this one, returning the state tracking them all.
+func (plugin *FixVisibilityPlugin) loadPendingFixes(workspaceRoot string) (*pluginState, error) {
+	state, err := loadState(workspaceRoot)
+	if err != nil {
+		return nil, err
+	}
+	for _, pending := range state.Pending {
+		plugin.targetsToFix.insert(pending.ToFix, pending.From, "")
+	}
+	state.track(plugin.targetsToFix.list())
+	return state, nil
+}
+
+// track replaces the pending fixes by the issues of the given nodes,
+// including the consumers folded into them, all pending until they're
+// resolved. The issues left unresolved when the invocation ends, e.g. because
+// it failed or the hook was skipped, are then handed off.
+func (state *pluginState) track(nodes []*fixNode) {
+	state.Pending = nil
+	state.index = map[fixKey]int{}
+	state.settled = map[int]bool{}
+	state.printed = map[int]bool{}
+	for _, node := range nodes {
+		for _, key := range node.edges() {
+			if _, ok := state.index[key]; !ok {
+				state.index[key] = len(state.Pending)
+				state.Pending = append(state.Pending, pendingFix{ToFix: key.toFix, From: key.from})
+			}
+		}
+	}
+}
+
+// resolve records the given outcome, as named in the history, of the given
+// node for the issues it stands for, as done by historyRecord.resolve. An
+// issue stays pending when the fix of any of the nodes derived from it was
+// printed rather than resolved.
+func (state *pluginState) resolve(node *fixNode, status string) {
+	if state == nil {
+		return
+	}
+	for _, key := range node.edges() {
+		i, ok := state.index[key]
+		if !ok {
+			continue
+		}
+		if status == fixStatusNames[fixPrinted] {
+			state.printed[i] = true
+		} else {
+			state.settled[i] = true
+		}
+	}
+}
+
+// save writes the state, removing the state file when there's nothing left
+// to hand off.
+func (state *pluginState) save(workspaceRoot string) error {
+	path := pluginFilePath(workspaceRoot, stateFilename)
+	pending := state.Pending[:0:0]
+	for i, p := range state.Pending {
+		if state.printed[i] || !state.settled[i] {
+			pending = append(pending, p)
+		}
+	}
+	state = &pluginState{Pending: pending}
+	if len(state.Pending) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to save state: %w", err)
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// The names of the strategies selectable by the strategies property.
+const (
+	// strategyConsumer grants the consumer access, as set by the grant and
+	// codeowners_grant properties. This is the default.
+	strategyConsumer = "consumer"
+	// strategyPublic makes the target being fixed public.
+	strategyPublic = "public"
+	// strategyPackageGroup grants a package_group access, adding the consumer
+	// to it.
+	strategyPackageGroup = "package_group"
+	// strategyDefaultVisibility grants the consumer access through the default
+	// visibility of the package, for the targets without a visibility of
+	// their own.
+	strategyDefaultVisibility = "default_visibility"
+)
+
+// issue is a visibility issue to fix: the consumer depending on the target
+// being fixed, which is not visible from it.
+type issue struct {
+	toFix    label.Label
+	consumer label.Label
+}
+
+// strategyEdits are the edits computed by a strategy to fix an issue.
+type strategyEdits struct {
+	// grant is the visibility entry added to the target being fixed.
+	grant label.Label
+	// policy describes the policy which produced the grant.
+	policy string
+	// commands are the buildozer commands performed along with adding the
+	// grant, e.g. adding the consumer to the package_group being granted.
+	commands []buildozerCommand
+	// defaultTarget is the buildozer target, e.g. the package() call, whose
+	// defaultAttr holds the default visibility of the package, which the
+	// grant is added to instead when the target being fixed has no
+	// visibility of its own. defaultPrefix is the prefix of the rule of the
+	// strategies property which selected the strategy.
+	defaultTarget string
+	defaultAttr   string
+	defaultPrefix string
+}
+
+// strategy computes the edits fixing visibility issues. Adding the grant to the
+// target being fixed is left to the caller, since it depends on how the
+// visibility attribute of the target is written.
+type strategy interface {
+	// name returns the name of the strategy.
+	name() string
+	// computeEdits returns the edits fixing the given issue. It can be
+	// called more than once for the same issue, e.g. to sort the fixes,
+	// so it mustn't have side effects.
+	computeEdits(v issue) (*strategyEdits, error)
+}
+
+// strategyRule selects the strategy fixing the targets under a path prefix,
+// as configured by the strategies property.
+type strategyRule struct {
+	// Prefix is a package, e.g. //src/lib, or a package and its
+	// subpackages, e.g. //src/...
+	Prefix string `yaml:"prefix"`
+	// Strategy is the name of the strategy.
+	Strategy string `yaml:"strategy"`
+	// PackageGroup is the label of the package_group granted by the
+	// package_group strategy.
+	PackageGroup string `yaml:"package_group"`
+	// PackageMacro is the name of the macro wrapping package() in the BUILD
+	// files, for the default_visibility strategy, and PackageMacroAttribute
+	// the attribute of the macro holding the default visibility, by default
+	// default_visibility.
+	PackageMacro          string `yaml:"package_macro"`
+	PackageMacroAttribute string `yaml:"package_macro_attribute"`
+}
+
+// parseStrategyPrefix parses the prefix of a strategies rule: an optional
+// repository, e.g. @repo, followed by // and a package, optionally followed by
+// /... to include its subpackages, the main repository being the empty one.
+func parseStrategyPrefix(prefix string) (repo, pkg string, recursive bool, err error) {
+	repo, pkg, ok := strings.Cut(prefix, "//")
+	if !ok || (repo != "" && !strings.HasPrefix(repo, "@")) {
+		return "", "", false, fmt.Errorf("must start with // or @<repo>//")
+	}
+	repo = strings.TrimPrefix(repo, "@")
+	if pkg == "..." {
+		return repo, "", true, nil
+	}
+	if dir := strings.TrimSuffix(pkg, "/..."); dir != pkg {
+		pkg, recursive = dir, true
+	}
+	if pkg != "" {
+		for _, component := range strings.Split(pkg, "/") {
+			if component == "" || component == "." || strings.Contains(component, "..") || strings.Contains(component, ":") {
+				return "", "", false, fmt.Errorf("invalid package %q", pkg)
+			}
+		}
+	}
+	if _, err := label.Parse(fmt.Sprintf("@%s//%s:all", repo, pkg)); err != nil {
+		return "", "", false, err
+	}
+	return repo, pkg, recursive, nil
+}
+
+// validate checks that the rule is well-formed.
+func (r *strategyRule) validate() error {
+	if _, _, _, err := parseStrategyPrefix(r.Prefix); err != nil {
+		return fmt.Errorf("invalid strategies prefix %q: %v: must be a package, e.g. //src/lib, or a package and its subpackages, e.g. //src/...", r.Prefix, err)
+	}
+	switch r.Strategy {
+	case strategyConsumer, strategyPublic:
+	case strategyDefaultVisibility:
+		if r.PackageMacro == "" && r.PackageMacroAttribute != "" {
+			return fmt.Errorf("the package_macro_attribute of %s requires a package_macro", r.Prefix)
+		}
+	case strategyPackageGroup:
+		if r.PackageGroup == "" {
+			return fmt.Errorf("the %s strategy of %s requires a package_group", r.Strategy, r.Prefix)
+		}
+		if _, err := label.Parse(r.PackageGroup); err != nil {
+			return fmt.Errorf("invalid package_group %q of %s: %w", r.PackageGroup, r.Prefix, err)
+		}
+	default:
+		return fmt.Errorf("invalid strategy %q of %s: must be one of %q, %q, %q or %q",
+			r.Strategy, r.Prefix, strategyConsumer, strategyPublic, strategyPackageGroup, strategyDefaultVisibility)
+	}
+	return nil
+}
+
+// matches returns whether the rule applies to the given target.
+func (r *strategyRule) matches(l label.Label) bool {
+	// The prefix was validated during Setup.
+	repo, pkg, recursive, _ := parseStrategyPrefix(r.Prefix)
+	if repo != strings.TrimPrefix(l.Repo, "@") {
+		return false
+	}
+	if recursive {
+		return pkg == "" || l.Pkg == pkg || strings.HasPrefix(l.Pkg, pkg+"/")
+	}
+	return l.Pkg == pkg
+}
+
+// strategyFor returns the strategy fixing the given target: that of the first
+// rule of the strategies property matching it, or the consumer strategy.
+func (plugin *FixVisibilityPlugin) strategyFor(toFix label.Label) strategy {
+	for _, r := range plugin.properties.Strategies {
+		if !r.matches(toFix) {
+			continue
+		}
+		switch r.Strategy {
+		case strategyPublic:
+			return &publicStrategy{prefix: r.Prefix}
+		case strategyPackageGroup:
+			// The package_group was validated during Setup.
+			group, _ := label.Parse(r.PackageGroup)
+			return &packageGroupStrategy{
+				plugin: plugin,
+				group:  group,
+				policy: fmt.Sprintf("the %s strategy of %s", strategyPackageGroup, r.Prefix),
+			}
+		case strategyDefaultVisibility:
+			attr := r.PackageMacroAttribute
+			if attr == "" {
+				attr = defaultVisibilityAttr
+			}
+			return &defaultVisibilityStrategy{plugin: plugin, prefix: r.Prefix, macro: r.PackageMacro, attr: attr}
+		case strategyConsumer:
+			return &consumerStrategy{plugin: plugin}
+		default:
+			panic(fmt.Sprintf("unknown strategy %q of %s, which validate rejects", r.Strategy, r.Prefix))
+		}
+	}
+	return &consumerStrategy{plugin: plugin}
+}
+
+// consumerStrategy grants the consumer access.
+type consumerStrategy struct {
+	plugin *FixVisibilityPlugin
+}
+
+func (s *consumerStrategy) name() string {
+	return strategyConsumer
+}
+
+func (s *consumerStrategy) computeEdits(v issue) (*strategyEdits, error) {
+	grant, policy, err := s.plugin.grantLabel(v.consumer)
+	if err != nil {
+		return nil, err
+	}
+	return &strategyEdits{grant: grant, policy: policy}, nil
+}
+
+// publicStrategy makes the target being fixed public.
+type publicStrategy struct {
+	prefix string
+}
+
+func (s *publicStrategy) name() string {
+	return strategyPublic
+}
+
+func (s *publicStrategy) computeEdits(v issue) (*strategyEdits, error) {
+	// The visibility constant was parsed successfully by label.Parse.
+	grant, _ := label.Parse(publicVisibility)
+	return &strategyEdits{
+		grant:  grant,
+		policy: fmt.Sprintf("the %s strategy of %s", strategyPublic, s.prefix),
+	}, nil
+}
+
+// packageGroupStrategy grants a package_group access, adding the package
+// granted to the consumer to its packages.
+type packageGroupStrategy struct {
+	plugin *FixVisibilityPlugin
+	group  label.Label
+	policy string
+}
+
+func (s *packageGroupStrategy) name() string {
+	return strategyPackageGroup
+}
+
+func (s *packageGroupStrategy) computeEdits(v issue) (*strategyEdits, error) {
+	consumerGrant, _, err := s.plugin.grantLabel(v.consumer)
+	if err != nil {
+		return nil, err
+	}
+	return &strategyEdits{
+		grant:  s.group,
+		policy: s.policy,
+		commands: []buildozerCommand{{
+			command: fmt.Sprintf("add packages %s", packageSpec(consumerGrant)),
+			target:  s.group.String(),
+		}},
+	}, nil
+}
+
+// isPublic returns whether the given visibility entry is public visibility.
+func isPublic(l label.Label) bool {
+	return l.Repo == "" && l.Pkg == "visibility" && l.Name == "public"
+}
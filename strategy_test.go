@@ -0,0 +1,63 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+func TestStrategyRulePrefix(t *testing.T) {
+	for _, test := range []struct {
+		prefix  string
+		valid   bool
+		matches []string
+		misses  []string
+	}{
+		{prefix: "//...", valid: true, matches: []string{"//:x", "//src/lib:x"}, misses: []string{"@repo//src:x"}},
+		{prefix: "//src/lib", valid: true, matches: []string{"//src/lib:x", "@//src/lib:x"}, misses: []string{"//src/lib/sub:x", "//src:x"}},
+		{prefix: "//src/...", valid: true, matches: []string{"//src:x", "//src/lib:x"}, misses: []string{"//srcs:x", "//:x"}},
+		{prefix: "//", valid: true, matches: []string{"//:x"}, misses: []string{"//src:x"}},
+		{prefix: "@repo//...", valid: true, matches: []string{"@repo//src:x"}, misses: []string{"//src:x", "@other//src:x"}},
+		{prefix: "@repo//src", valid: true, matches: []string{"@repo//src:x"}, misses: []string{"//src:x"}},
+		{prefix: "src//lib"},
+		{prefix: "//src/...x"},
+		{prefix: "//src/"},
+		{prefix: "//src//lib"},
+		{prefix: "//src/../lib"},
+		{prefix: "src/lib"},
+		{prefix: "//src:lib"},
+	} {
+		r := strategyRule{Prefix: test.prefix, Strategy: strategyConsumer}
+		if err := r.validate(); (err == nil) != test.valid {
+			t.Errorf("validate() of %q = %v, want valid: %t", test.prefix, err, test.valid)
+			continue
+		}
+		for _, l := range test.matches {
+			if !r.matches(mustParse(t, l)) {
+				t.Errorf("%q doesn't match %s, want a match", test.prefix, l)
+			}
+		}
+		for _, l := range test.misses {
+			if r.matches(mustParse(t, l)) {
+				t.Errorf("%q matches %s, want no match", test.prefix, l)
+			}
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) label.Label {
+	l, err := label.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
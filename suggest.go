@@ -0,0 +1,138 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxSuggestions is the maximum number of labels suggested for a string that
+// could not be parsed as a label.
+const maxSuggestions = 3
+
+// unparsedIssue is a visibility issue whose description matched, but from
+// which a valid label could not be extracted, e.g. because the description was
+// truncated or wrapped.
+type unparsedIssue struct {
+	description string
+	captured    string
+	err         error
+}
+
+// reportUnparsedIssues prints the raw description of the visibility issues
+// whose labels could not be extracted, along with the closest labels existing
+// in the workspace.
+func (plugin *FixVisibilityPlugin) reportUnparsedIssues() {
+	if len(plugin.unparsedIssues) == 0 {
+		return
+	}
+
+	output, err := plugin.bazel.run("query", "--keep_going", "--output=label", "//...:*")
+	if err != nil {
+		fmt.Fprintf(os.Stdout, "Could not query the workspace labels to suggest fixes: %v\n", err)
+	}
+	var labels []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			labels = append(labels, line)
+		}
+	}
+
+	for _, issue := range plugin.unparsedIssues {
+		fmt.Fprintf(os.Stdout, "Could not extract the labels from the visibility error (%v):\n%s\n", issue.err, issue.description)
+		if suggestions := closestLabels(issue.captured, labels, maxSuggestions); len(suggestions) > 0 {
+			fmt.Fprintf(os.Stdout, "Did you mean:\n")
+			for _, suggestion := range suggestions {
+				fmt.Fprintf(os.Stdout, "  %s\n", suggestion)
+			}
+		}
+	}
+}
+
+// closestLabels returns up to n labels closest to the given string, which is
+// expected to be a truncated or wrapped label. Labels of which the string is a
+// prefix rank first, followed by the ones within a small edit distance.
+func closestLabels(s string, labels []string, n int) []string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return nil
+	}
+
+	maxDistance := len(s) / 3
+	if maxDistance < 3 {
+		maxDistance = 3
+	}
+	type scored struct {
+		label    string
+		distance int
+	}
+	var candidates []scored
+	for _, l := range labels {
+		distance := 0
+		if !strings.HasPrefix(l, s) {
+			distance = levenshtein(s, l)
+		}
+		if distance <= maxDistance {
+			candidates = append(candidates, scored{l, distance})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].distance != candidates[j].distance {
+			return candidates[i].distance < candidates[j].distance
+		}
+		return len(candidates[i].label) < len(candidates[j].label)
+	})
+
+	var closest []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		closest = append(closest, candidates[i].label)
+	}
+	return closest
+}
+
+// levenshtein returns the edit distance between the strings a and b.
+func levenshtein(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClosestLabels(t *testing.T) {
+	labels := []string{"//lib:a", "//lib:abc", "//lib:abcdef", "//app:app", "//tools/gen:generator"}
+	for _, test := range []struct {
+		captured string
+		n        int
+		closest  []string
+	}{
+		{"", 3, nil},
+		{"//lib:ab", 3, []string{"//lib:abc", "//lib:abcdef", "//lib:a"}},
+		{"//lib:ab", 1, []string{"//lib:abc"}},
+		{"//lib:\n  abc", 3, []string{"//lib:abc", "//lib:abcdef", "//lib:a"}},
+		{"//app:ap", 3, []string{"//app:app"}},
+		{"//tools/gen:generatr", 3, []string{"//tools/gen:generator"}},
+		{"//unrelated:target", 3, nil},
+	} {
+		if closest := closestLabels(test.captured, labels, test.n); !reflect.DeepEqual(closest, test.closest) {
+			t.Errorf("closestLabels(%q, %d) = %q, want %q", test.captured, test.n, closest, test.closest)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	for _, test := range []struct {
+		a, b     string
+		distance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"abc", "abd", 1},
+		{"kitten", "sitting", 3},
+		{"//lib:a", "//lib:ab", 1},
+	} {
+		if distance := levenshtein(test.a, test.b); distance != test.distance {
+			t.Errorf("levenshtein(%q, %q) = %d, want %d", test.a, test.b, distance, test.distance)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// expandSuites replaces the issues whose consumer is a test_suite by the
+// issues of the tests of the suite depending on the target to fix, since the
+// packages of those tests are the ones needing the visibility rather than the
+// package of the suite. The tests of the same package are then a single
+// issue, see fixOrderedSet.insert.
+func (plugin *FixVisibilityPlugin) expandSuites(nodes []*fixNode) ([]*fixNode, error) {
+	expanded := nodes[:0:0]
+	for _, node := range nodes {
+		members, err := plugin.suiteMembers(node)
+		if err != nil {
+			return nil, err
+		}
+		if len(members) == 0 {
+			expanded = append(expanded, node)
+			continue
+		}
+		fmt.Fprintf(os.Stdout, "%s is a test_suite, its tests depending on %s are granted access instead: %s\n",
+			node.from, node.toFix, strings.Join(members, ", "))
+		// The issue of the suite is replaced by those of its tests, which
+		// may be in the same package. The consumers folded into it remain
+		// an issue of their own.
+		plugin.targetsToFix.remove(node)
+		suite := append([]fixKey{{toFix: node.toFix, from: node.from}}, node.origins...)
+		for _, member := range members {
+			if memberNode, isNew := plugin.replaceSuite(node, member, suite); isNew {
+				expanded = append(expanded, memberNode)
+			}
+		}
+		for _, from := range node.folded {
+			if foldedNode, isNew := plugin.replaceSuite(node, from, nil); isNew {
+				expanded = append(expanded, foldedNode)
+			}
+		}
+	}
+	return expanded, nil
+}
+
+// replaceSuite inserts the issue of the given consumer replacing the given
+// issue of a test_suite, along with the detected issues it derives from. It
+// returns whether the issue is new.
+func (plugin *FixVisibilityPlugin) replaceSuite(suite *fixNode, from string, origins []fixKey) (*fixNode, bool) {
+	node, isNew := plugin.targetsToFix.insert(suite.toFix, from, "")
+	node.origins = append(node.origins, origins...)
+	for _, t := range suite.topLevelTargets {
+		if !node.isTopLevel(t) {
+			node.topLevelTargets = append(node.topLevelTargets, t)
+		}
+	}
+	if isNew {
+		node.resolution = suite.resolution
+		node.buildFileHash = suite.buildFileHash
+	}
+	return node, isNew
+}
+
+// suiteMembers returns the tests of the test_suite consumer of the given issue
+// which depend directly on the target to fix, as found by `bazel query`. It
+// returns nil when the consumer is not a test_suite.
+func (plugin *FixVisibilityPlugin) suiteMembers(node *fixNode) ([]string, error) {
+	consumer, err := label.Parse(node.from)
+	if err != nil {
+		return nil, nil
+	}
+	target, err := plugin.buildozerTarget(consumer)
+	var unfixable *unfixableError
+	if errors.As(err, &unfixable) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	output, err := plugin.buildozer.run("print kind", target)
+	if err != nil || strings.TrimSpace(string(output)) != "test_suite" {
+		return nil, nil
+	}
+
+	query := fmt.Sprintf("rdeps(tests(%s), %s, 1) except %s", node.from, node.toFix, node.toFix)
+	if output, err = plugin.bazel.run("query", "--output=label", query); err != nil {
+		plugin.warnings.warnf("Could not find the tests of the test_suite %s: %v\n", node.from, err)
+		return nil, nil
+	}
+	var members []string
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if line = strings.TrimSpace(line); line != "" && line != node.from {
+			members = append(members, line)
+		}
+	}
+	return members, nil
+}
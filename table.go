@@ -0,0 +1,76 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// The possible values for the format property.
+const (
+	// formatText prints the commands of each fix as it's handled. This is the
+	// default.
+	formatText = "text"
+	// formatTable prints a table of the fixes once they are all handled,
+	// followed by the commands of all the fixes to perform manually.
+	formatTable = "table"
+)
+
+// fixTable accumulates the rows of the table of the fixes, and the commands
+// printed after it.
+type fixTable struct {
+	rows     [][]string
+	commands bytes.Buffer
+}
+
+// add adds a row for the fix with the given status.
+func (t *fixTable) add(workspaceRoot string, fix *visibilityFix, status fixStatus) {
+	buildFile := relativeBuildFile(workspaceRoot, fix.target)
+	t.rows = append(t.rows, []string{fix.node.toFix, fix.node.from, fix.strategy, fixEditKind(fix), fixStatusNames[status], buildFile})
+}
+
+// render writes the table, then the commands.
+func (t *fixTable) render(out io.Writer) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tCONSUMER\tSTRATEGY\tEDIT\tSTATUS\tBUILD FILE")
+	for _, row := range t.rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+	out.Write(t.commands.Bytes())
+}
+
+// fixEditKind returns how the fix edits the visibility of the target being
+// fixed.
+func fixEditKind(fix *visibilityFix) string {
+	for _, e := range fix.fileEdits {
+		if _, ok := e.(*allowlistEdit); ok {
+			return "allowlist"
+		}
+	}
+	if len(fix.fileEdits) > 0 {
+		return "rewrite"
+	}
+	return "buildozer"
+}
+
+// commandOutput returns where the commands to perform manually are printed:
+// the table, when the format property is table, otherwise the terminal.
+func (plugin *FixVisibilityPlugin) commandOutput() io.Writer {
+	if plugin.table != nil {
+		return &plugin.table.commands
+	}
+	return os.Stdout
+}
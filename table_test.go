@@ -0,0 +1,58 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFixTable(t *testing.T) {
+	workspaceRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(workspaceRoot, "lib"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workspaceRoot, "lib", "BUILD.bazel"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	table := &fixTable{}
+	table.add(workspaceRoot, &visibilityFix{
+		node:     &fixNode{toFix: "//lib:a", from: "//app:app"},
+		target:   filepath.Join(workspaceRoot, "lib") + ":a",
+		strategy: strategyConsumer,
+	}, fixApplied)
+	table.add(workspaceRoot, &visibilityFix{
+		node:      &fixNode{toFix: "//lib:select", from: "//tools/gen:generator"},
+		target:    filepath.Join(workspaceRoot, "lib") + ":select",
+		strategy:  strategyConsumer,
+		fileEdits: []fileEdit{&visibilityRewrite{}},
+	}, fixPrinted)
+	table.add(workspaceRoot, &visibilityFix{
+		node:      &fixNode{toFix: "//lib:list", from: "//app:app"},
+		target:    filepath.Join(workspaceRoot, "lib") + ":list",
+		strategy:  strategyPublic,
+		fileEdits: []fileEdit{&allowlistEdit{}},
+	}, fixDeclined)
+	table.commands.WriteString("buildozer 'add visibility //tools/gen:__pkg__' //lib:select\n")
+
+	var out strings.Builder
+	table.render(&out)
+	want := `TARGET        CONSUMER               STRATEGY  EDIT       STATUS    BUILD FILE
+//lib:a       //app:app              consumer  buildozer  applied   lib/BUILD.bazel
+//lib:select  //tools/gen:generator  consumer  rewrite    proposed  lib/BUILD.bazel
+//lib:list    //app:app              public    allowlist  declined  lib/BUILD.bazel
+buildozer 'add visibility //tools/gen:__pkg__' //lib:select
+`
+	if out.String() != want {
+		t.Errorf("render() =\n%s\nwant:\n%s", out.String(), want)
+	}
+}
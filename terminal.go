@@ -0,0 +1,163 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+)
+
+// The possible values for the output property.
+const (
+	// outputAuto uses plain output when the output of the CLI is not a
+	// terminal. This is the default.
+	outputAuto = "auto"
+	// outputPlain always uses plain output.
+	outputPlain = "plain"
+	// outputStyled always uses the styled prompts of the CLI.
+	outputStyled = "styled"
+)
+
+// The possible values for the prompts property.
+const (
+	// promptsAuto prompts when the CLI runs in interactive mode, unless a CI
+	// environment is detected. This is the default.
+	promptsAuto = "auto"
+	// promptsNever never prompts, as in non-interactive mode.
+	promptsNever = "never"
+	// promptsCLI prompts whenever the CLI runs in interactive mode.
+	promptsCLI = "cli"
+)
+
+// ciVariables are the environment variables set by the common CI systems.
+var ciVariables = []string{
+	"CI",
+	"BUILD_ID",
+	"BUILDKITE",
+	"CIRCLECI",
+	"GITHUB_ACTIONS",
+	"GITLAB_CI",
+	"JENKINS_URL",
+	"TEAMCITY_VERSION",
+	"TF_BUILD",
+}
+
+// suppressPrompts returns whether the prompts must be suppressed even though
+// the CLI runs in interactive mode, as set by the prompts property, along with
+// the reason.
+func (plugin *FixVisibilityPlugin) suppressPrompts() (bool, string) {
+	switch plugin.properties.Prompts {
+	case promptsNever:
+		return true, "the prompts property is never"
+	case promptsCLI:
+		return false, ""
+	default:
+		for _, name := range ciVariables {
+			if value := os.Getenv(name); value != "" && value != "false" && value != "0" {
+				return true, fmt.Sprintf("running in CI, as %s is set", name)
+			}
+		}
+		return false, ""
+	}
+}
+
+// plainOutput returns whether the prompts must be plain lines instead of the
+// styled prompts of the CLI, whose ANSI escape sequences garble the output
+// when it's not a terminal.
+func (plugin *FixVisibilityPlugin) plainOutput() bool {
+	switch plugin.properties.Output {
+	case outputPlain:
+		return true
+	case outputStyled:
+		return false
+	default:
+		return !outputIsTerminal()
+	}
+}
+
+// outputIsTerminal returns whether the output of the CLI, which the output of
+// the plugin is forwarded to, is a terminal. The plugin runs as a child
+// process of the CLI with its standard output piped, so it's the standard
+// output of the parent process that is checked, on systems exposing it in
+// /proc. Elsewhere, only a dumb or missing TERM is detected.
+func outputIsTerminal() bool {
+	if term := os.Getenv("TERM"); term == "" || term == "dumb" {
+		return false
+	}
+	return parentIsTerminal(1, true)
+}
+
+// inputIsTerminal returns whether the standard input of the CLI, which the
+// plugin shares to read the answers of the prompts, is a terminal. A pipe or
+// /dev/null would block or end the prompts. Where /proc is not available, the
+// standard input of the plugin is checked instead.
+func inputIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return parentIsTerminal(0, err == nil && info.Mode()&os.ModeCharDevice != 0)
+}
+
+// parentIsTerminal returns whether the given file descriptor of the parent
+// process is a terminal, or the given fallback when that can't be told.
+func parentIsTerminal(fd int, fallback bool) bool {
+	target, err := os.Readlink(fmt.Sprintf("/proc/%d/fd/%d", os.Getppid(), fd))
+	if err != nil {
+		return fallback
+	}
+	return strings.HasPrefix(target, "/dev/pts/") || strings.HasPrefix(target, "/dev/tty")
+}
+
+// linePromptRunner is a PromptRunner printing the prompts as plain lines and
+// reading the answers line by line.
+type linePromptRunner struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// newLinePromptRunner returns a linePromptRunner reading the answers from the
+// given reader. The plugin shares its standard input with the CLI.
+func newLinePromptRunner(in io.Reader, out io.Writer) *linePromptRunner {
+	return &linePromptRunner{in: bufio.NewReader(in), out: out}
+}
+
+// Run satisfies the PromptRunner interface. Confirm prompts return
+// promptui.ErrAbort unless answered with yes, like the styled prompts.
+func (r *linePromptRunner) Run(prompt promptui.Prompt) (string, error) {
+	label := fmt.Sprint(prompt.Label)
+	if prompt.IsConfirm {
+		label += " [y/N]"
+	}
+	for {
+		fmt.Fprintf(r.out, "%s: ", label)
+		answer, err := r.in.ReadString('\n')
+		if err != nil && (err != io.EOF || answer == "") {
+			fmt.Fprintln(r.out)
+			return "", promptui.ErrEOF
+		}
+		answer = strings.TrimSpace(answer)
+		if prompt.IsConfirm {
+			if a := strings.ToLower(answer); a == "y" || a == "yes" {
+				return answer, nil
+			}
+			return answer, promptui.ErrAbort
+		}
+		if prompt.Validate != nil {
+			if err := prompt.Validate(answer); err != nil {
+				fmt.Fprintf(r.out, "%v\n", err)
+				continue
+			}
+		}
+		return answer, nil
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/manifoldco/promptui"
+)
+
+func TestLinePromptRunner(t *testing.T) {
+	validate := func(answer string) error {
+		if answer != "a" && answer != "b" {
+			return fmt.Errorf("answer a or b")
+		}
+		return nil
+	}
+	for _, test := range []struct {
+		name    string
+		prompt  promptui.Prompt
+		input   string
+		answer  string
+		err     error
+		printed string
+	}{
+		{"confirmed", promptui.Prompt{Label: "Fix", IsConfirm: true}, "yes\n", "yes", nil, "Fix [y/N]: "},
+		{"confirmed in capitals", promptui.Prompt{Label: "Fix", IsConfirm: true}, " Y \n", "Y", nil, "Fix [y/N]: "},
+		{"declined", promptui.Prompt{Label: "Fix", IsConfirm: true}, "n\n", "n", promptui.ErrAbort, "Fix [y/N]: "},
+		{"declined by default", promptui.Prompt{Label: "Fix", IsConfirm: true}, "\n", "", promptui.ErrAbort, "Fix [y/N]: "},
+		{"unterminated line", promptui.Prompt{Label: "Fix", IsConfirm: true}, "y", "y", nil, "Fix [y/N]: "},
+		{"end of input", promptui.Prompt{Label: "Fix", IsConfirm: true}, "", "", promptui.ErrEOF, "Fix [y/N]: \n"},
+		{"validated", promptui.Prompt{Label: "Pick", Validate: validate}, "c\nb\n", "b", nil, "Pick: answer a or b\nPick: "},
+		{"never valid", promptui.Prompt{Label: "Pick", Validate: validate}, "c\n", "", promptui.ErrEOF, "Pick: answer a or b\nPick: \n"},
+	} {
+		var out strings.Builder
+		answer, err := newLinePromptRunner(strings.NewReader(test.input), &out).Run(test.prompt)
+		if answer != test.answer || !errors.Is(err, test.err) {
+			t.Errorf("%s: Run() = %q, %v, want %q, %v", test.name, answer, err, test.answer, test.err)
+		}
+		if out.String() != test.printed {
+			t.Errorf("%s: Run() printed %q, want %q", test.name, out.String(), test.printed)
+		}
+	}
+}
+
+func TestPlainOutput(t *testing.T) {
+	for _, test := range []struct {
+		output string
+		term   string
+		plain  bool
+	}{
+		{outputPlain, "xterm", true},
+		{outputStyled, "dumb", false},
+		{outputAuto, "dumb", true},
+		{outputAuto, "", true},
+	} {
+		t.Setenv("TERM", test.term)
+		plugin := &FixVisibilityPlugin{properties: &pluginProperties{Output: test.output}}
+		if plain := plugin.plainOutput(); plain != test.plain {
+			t.Errorf("plainOutput() = %t with the %s output and TERM=%q, want %t", plain, test.output, test.term, test.plain)
+		}
+	}
+}
+
+func TestSuppressPrompts(t *testing.T) {
+	for _, name := range ciVariables {
+		t.Setenv(name, "")
+	}
+	for _, test := range []struct {
+		prompts  string
+		env      map[string]string
+		suppress bool
+	}{
+		{promptsAuto, nil, false},
+		{promptsAuto, map[string]string{"CI": "true"}, true},
+		{promptsAuto, map[string]string{"CI": "false"}, false},
+		{promptsAuto, map[string]string{"CI": "0", "GITHUB_ACTIONS": "true"}, true},
+		{promptsCLI, map[string]string{"CI": "true"}, false},
+		{promptsNever, nil, true},
+	} {
+		for name, value := range test.env {
+			t.Setenv(name, value)
+		}
+		plugin := &FixVisibilityPlugin{properties: &pluginProperties{Prompts: test.prompts}}
+		if suppress, reason := plugin.suppressPrompts(); suppress != test.suppress || (reason != "") != test.suppress {
+			t.Errorf("suppressPrompts() = %t, %q with the %s prompts and %v, want %t", suppress, reason, test.prompts, test.env, test.suppress)
+		}
+		for name := range test.env {
+			t.Setenv(name, "")
+		}
+	}
+}
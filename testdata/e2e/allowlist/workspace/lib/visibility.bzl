@@ -0,0 +1,3 @@
+A_VISIBILITY = []
+
+B_VISIBILITY = []
@@ -0,0 +1,4 @@
+A_VISIBILITY = [
+    "//tools:__pkg__",
+    "//tools:lint",
+]
@@ -0,0 +1 @@
+LIB_VISIBILITY = ["//visibility:private"]
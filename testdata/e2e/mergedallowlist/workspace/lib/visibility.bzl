@@ -0,0 +1 @@
+A_VISIBILITY = []
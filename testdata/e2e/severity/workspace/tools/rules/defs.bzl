@@ -0,0 +1,7 @@
+def _impl(ctx):
+    pass
+
+my_rule = rule(
+    implementation = _impl,
+    attrs = {"_tool": attr.label(default = "//tools:tool")},
+)
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// The kinds of resolution a visibility issue can be reported by, besides the
+// dependencies of the targets.
+const (
+	// resolutionToolchain is the resolution of the registered toolchains.
+	resolutionToolchain = "toolchain"
+	// resolutionPlatform is the resolution of the platforms and their
+	// constraints.
+	resolutionPlatform = "platform"
+)
+
+// resolutionOf returns the kind of resolution the given error description
+// reports a visibility issue for, empty for a dependency of a target.
+func resolutionOf(description string) string {
+	lower := strings.ToLower(description)
+	switch {
+	case strings.Contains(lower, "resolving toolchains") ||
+		strings.Contains(lower, "registered toolchain") ||
+		strings.Contains(lower, "toolchain rule"):
+		return resolutionToolchain
+	case strings.Contains(lower, "platform rule") ||
+		strings.Contains(lower, "constraint_value") ||
+		strings.Contains(lower, "target platform") ||
+		strings.Contains(lower, "execution platform"):
+		return resolutionPlatform
+	default:
+		return ""
+	}
+}
+
+// toolchainWrapper returns the label of the toolchain() rule wrapping the
+// given toolchain implementation in its package, whose visibility is the one
+// to fix for an issue reported by the toolchain resolution, rather than that
+// of the implementation. It returns the empty string if there's no such rule,
+// or if the consumer is the wrapper itself, in which case the implementation
+// must be visible from it.
+func (plugin *FixVisibilityPlugin) toolchainWrapper(impl, consumer label.Label) (string, error) {
+	selector := impl
+	selector.Name = "%toolchain"
+	target, err := plugin.buildozerTarget(selector)
+	if err != nil {
+		return "", err
+	}
+	// Buildozer fails when the package has no toolchain() rule.
+	output, err := plugin.buildozer.run("print label toolchain", target)
+	if err != nil {
+		return "", nil
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		wrapper, err := label.Parse(fields[0])
+		if err != nil {
+			continue
+		}
+		value := fields[1]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		wrapped, err := label.Parse(value)
+		if err != nil {
+			continue
+		}
+		wrapper = wrapper.Abs(impl.Repo, impl.Pkg)
+		if wrapped.Abs(impl.Repo, impl.Pkg).Equal(impl) && !wrapper.Equal(consumer) {
+			return plugin.formatLabel(wrapper), nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// violationQueueSize bounds the number of violations queued for writing,
+// past which the build events wait for the violations file to catch up.
+const violationQueueSize = 1024
+
+// violation is the JSON line written to the violations file for each
+// visibility issue, as soon as it's detected.
+type violation struct {
+	InvocationID   string `json:"invocation_id,omitempty"`
+	ToFix          string `json:"to_fix"`
+	From           string `json:"from"`
+	TopLevelTarget string `json:"top_level_target,omitempty"`
+}
+
+// violationStream writes the detected violations to the violations file, one
+// JSON object per line, from a queue drained by a writer of its own, so that
+// a slow file system holds the build events only once the queue is full.
+type violationStream struct {
+	file    *os.File
+	encoder *json.Encoder
+	queue   chan violation
+	// done receives the first error of the writer, if any, once the queue is
+	// drained.
+	done chan error
+	lag  *eventLag
+}
+
+// openViolationStream creates, or truncates, the violations file at the given
+// path, relative to the workspace root unless absolute.
+func openViolationStream(workspaceRoot, path string, lag *eventLag) (*violationStream, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(workspaceRoot, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to open the violations file: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the violations file: %w", err)
+	}
+	s := &violationStream{
+		file:    file,
+		encoder: json.NewEncoder(file),
+		queue:   make(chan violation, violationQueueSize),
+		done:    make(chan error, 1),
+		lag:     lag,
+	}
+	go s.drain()
+	return s, nil
+}
+
+// drain writes the queued violations, each as a single line. The file is not
+// buffered, so the line is readable by the consumers of the file once
+// written. After a failed write, the remaining violations are dropped.
+func (s *violationStream) drain() {
+	var err error
+	for v := range s.queue {
+		if err == nil {
+			if encodeErr := s.encoder.Encode(v); encodeErr != nil {
+				err = fmt.Errorf("failed to write the violations file: %w", encodeErr)
+			}
+		}
+	}
+	s.done <- err
+}
+
+// write queues the violation for writing, waiting for room in the queue when
+// it's full.
+func (s *violationStream) write(v violation) {
+	select {
+	case s.queue <- v:
+		s.lag.observeQueue(len(s.queue), 0)
+	default:
+		start := time.Now()
+		s.queue <- v
+		s.lag.observeQueue(len(s.queue), time.Since(start))
+	}
+}
+
+// close writes the queued violations, then closes the violations file. It
+// returns the error of the first failed write, if any.
+func (s *violationStream) close() error {
+	close(s.queue)
+	if err := <-s.done; err != nil {
+		s.file.Close()
+		return err
+	}
+	if err := s.file.Close(); err != nil {
+		return fmt.Errorf("failed to close the violations file: %w", err)
+	}
+	return nil
+}
+
+// streamViolation writes the violation to the violations file configured by
+// the violations_file property, opening it on the first violation. It must be
+// called with plugin.mu held.
+func (plugin *FixVisibilityPlugin) streamViolation(toFix, from, topLevelTarget string) error {
+	if plugin.properties.ViolationsFile == "" {
+		return nil
+	}
+	if plugin.violations == nil {
+		workspaceRoot, err := plugin.workspaceRoot()
+		if err != nil {
+			return fmt.Errorf("failed to open the violations file: %w", err)
+		}
+		if plugin.violations, err = openViolationStream(workspaceRoot, plugin.properties.ViolationsFile, &plugin.lag); err != nil {
+			return err
+		}
+	}
+	plugin.violations.write(violation{
+		InvocationID:   plugin.invocationID,
+		ToFix:          plugin.redact(toFix),
+		From:           plugin.redact(from),
+		TopLevelTarget: plugin.redact(topLevelTarget),
+	})
+	return nil
+}
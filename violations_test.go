@@ -0,0 +1,59 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestViolationStream(t *testing.T) {
+	root := t.TempDir()
+	var lag eventLag
+	s, err := openViolationStream(root, filepath.Join("out", "violations.jsonl"), &lag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// More violations than the queue holds, for the writes to wait on it.
+	n := 2 * violationQueueSize
+	for i := 0; i < n; i++ {
+		s.write(violation{ToFix: fmt.Sprintf("//lib:%d", i), From: "//app:app"})
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(root, "out", "violations.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	i := 0
+	for ; scanner.Scan(); i++ {
+		var v violation
+		if err := json.Unmarshal(scanner.Bytes(), &v); err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("//lib:%d", i); v.ToFix != want {
+			t.Fatalf("violation %d is of %s, want %s", i, v.ToFix, want)
+		}
+	}
+	if i != n {
+		t.Errorf("%d violations were written, want %d", i, n)
+	}
+	if stats := lag.stats(); stats.queuePeak == 0 || stats.queuePeak > violationQueueSize {
+		t.Errorf("the queue peaked at %d violations, want between 1 and %d", stats.queuePeak, violationQueueSize)
+	}
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/buildtools/build"
+	"github.com/bazelbuild/buildtools/edit"
+)
+
+const (
+	privateVisibility = "//visibility:private"
+	publicVisibility  = "//visibility:public"
+)
+
+// parseVisibilityList parses the output of `buildozer 'print visibility'` into
+// the list of entries of the visibility attribute. It also returns whether the
+// attribute is a literal list of strings, which a missing attribute is assumed
+// to be, since buildozer creates it when adding the first entry.
+func parseVisibilityList(output []byte) ([]string, bool) {
+	value := strings.TrimSpace(string(output))
+	if value == "" || value == "(missing)" {
+		return nil, true
+	}
+	if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+		return nil, false
+	}
+	entries := strings.Trim(value, "[]")
+	if strings.ContainsAny(entries, "[]()+\"'") {
+		return nil, false
+	}
+	return strings.Fields(entries), true
+}
+
+// redundantVisibility returns the entries of the existing visibility list of
+// the target being fixed that are made obsolete by adding the grant, as well
+// as the entries that are repeated in the list. Relative entries are resolved
+// against the package of the target being fixed.
+func redundantVisibility(existing []string, grant label.Label, toFix label.Label) []string {
+	var redundant []string
+	seen := make(map[label.Label]struct{}, len(existing))
+	for _, entry := range existing {
+		entryLabel, err := label.Parse(entry)
+		if err != nil {
+			continue
+		}
+		entryLabel = entryLabel.Abs(toFix.Repo, toFix.Pkg)
+		if _, duplicated := seen[entryLabel]; duplicated {
+			redundant = append(redundant, entry)
+			continue
+		}
+		seen[entryLabel] = struct{}{}
+		if !entryLabel.Equal(grant) && visibilityCovers(grant, entryLabel) {
+			redundant = append(redundant, entry)
+		}
+	}
+	return redundant
+}
+
+// alreadyGranted returns whether the existing visibility list of the target
+// being fixed already grants every package granted by the given entry, e.g.
+// because the issue was fixed since it was detected.
+func alreadyGranted(existing []string, grant label.Label, toFix label.Label) bool {
+	for _, entry := range existing {
+		if entry == publicVisibility {
+			return true
+		}
+		entryLabel, err := label.Parse(entry)
+		if err != nil {
+			continue
+		}
+		entryLabel = entryLabel.Abs(toFix.Repo, toFix.Pkg)
+		if entryLabel.Equal(grant) || visibilityCovers(entryLabel, grant) {
+			return true
+		}
+	}
+	return false
+}
+
+// visibilityCovers returns whether every package granted by the visibility
+// entry b is also granted by the visibility entry a.
+func visibilityCovers(a, b label.Label) bool {
+	if isPublic(a) {
+		return b.String() != privateVisibility
+	}
+	if a.Repo != b.Repo {
+		return false
+	}
+	switch a.Name {
+	case "__subpackages__":
+		if b.Name != "__pkg__" && b.Name != "__subpackages__" {
+			return false
+		}
+		return a.Pkg == "" || b.Pkg == a.Pkg || strings.HasPrefix(b.Pkg, a.Pkg+"/")
+	case "__pkg__":
+		return b.Name == "__pkg__" && b.Pkg == a.Pkg
+	default:
+		return false
+	}
+}
+
+// fileEdit is an edit that buildozer can't perform, applied by the plugin
+// directly to a file.
+type fileEdit interface {
+	// apply performs the edit.
+	apply() error
+	// String describes the edit for the user to perform it manually.
+	String() string
+	// resultingEntries returns the entries of the visibility once the edit
+	// is applied, e.g. those of all the branches of a select().
+	resultingEntries() []string
+	// merge returns the edit combining the given one with this one, for the
+	// fixes of the same target, or nil when they edit different files or
+	// attributes.
+	merge(other fileEdit) (fileEdit, error)
+}
+
+// visibilityRewrite edits, through its syntax tree, the visibility attribute
+// of a target in its BUILD file, e.g. when it is not a literal list, such as a
+// select() or a list concatenation.
+type visibilityRewrite struct {
+	toFix string
+	path  string
+	name  string
+	// rewrite returns the new value of the visibility attribute from its
+	// current one. It's applied to the content of the BUILD file when the
+	// edit is applied, so that the edits made since it was prepared, e.g. by
+	// the previous fixes, are kept.
+	rewrite func(build.Expr) build.Expr
+	// expr is the new value of the attribute, as of the content of the BUILD
+	// file when the edit was prepared, for the description of the edit.
+	expr build.Expr
+}
+
+// loadVisibilityRewrite prepares the rewrite of the visibility attribute of
+// the given target in its BUILD file.
+func loadVisibilityRewrite(toFix string, target string, rewrite func(build.Expr) build.Expr) (*visibilityRewrite, error) {
+	path, _, name := edit.InterpretLabelForWorkspaceLocation("", target)
+	return newVisibilityRewrite(toFix, path, name, rewrite)
+}
+
+// newVisibilityRewrite prepares the rewrite of the visibility attribute of
+// the rule of the given name in the BUILD file at the given path.
+func newVisibilityRewrite(toFix, path, name string, rewrite func(build.Expr) build.Expr) (*visibilityRewrite, error) {
+	_, rule, err := loadRule(path, name)
+	if err != nil {
+		return nil, err
+	}
+	return &visibilityRewrite{
+		toFix:   toFix,
+		path:    path,
+		name:    name,
+		rewrite: rewrite,
+		expr:    rewrite(rule.Attr("visibility")),
+	}, nil
+}
+
+// loadRule parses the BUILD file at the given path, returning it along with
+// its rule of the given name.
+func loadRule(path, name string) (*build.File, *build.Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read BUILD file: %w", err)
+	}
+	return parseRule(path, data, name)
+}
+
+// parseRule parses the given content of the BUILD file at the given path,
+// returning it along with its rule of the given name.
+func parseRule(path string, data []byte, name string) (*build.File, *build.Rule, error) {
+	file, err := build.ParseBuild(path, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse BUILD file: %w", err)
+	}
+	for _, r := range file.Rules("") {
+		if r.Name() == name {
+			return file, r, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("rule %q not found in %s", name, path)
+}
+
+// appendVisibilityEntry returns the visibility expression with the entry
+// added. The entry is appended to the first literal list operand of a
+// concatenation, replacing //visibility:private in it, or, when there's no
+// such list (e.g. a bare select()), a new list with the entry is concatenated
+// to the expression so that it applies to every branch.
+func appendVisibilityEntry(expr build.Expr, entry string) build.Expr {
+	value := &build.StringExpr{Value: entry}
+	if expr == nil {
+		return &build.ListExpr{List: []build.Expr{value}}
+	}
+	if list := findLiteralList(expr); list != nil {
+		filtered := list.List[:0]
+		for _, e := range list.List {
+			if str, ok := e.(*build.StringExpr); ok && str.Value == privateVisibility {
+				continue
+			}
+			filtered = append(filtered, e)
+		}
+		list.List = append(filtered, value)
+		return expr
+	}
+	return &build.BinaryExpr{
+		X:  expr,
+		Op: "+",
+		Y:  &build.ListExpr{List: []build.Expr{value}},
+	}
+}
+
+// findLiteralList returns the first literal list found in the operands of a
+// list concatenation, or nil if there's none.
+func findLiteralList(expr build.Expr) *build.ListExpr {
+	switch e := expr.(type) {
+	case *build.ListExpr:
+		return e
+	case *build.BinaryExpr:
+		if e.Op != "+" {
+			return nil
+		}
+		if list := findLiteralList(e.X); list != nil {
+			return list
+		}
+		return findLiteralList(e.Y)
+	default:
+		return nil
+	}
+}
+
+// String satisfies the fileEdit interface.
+func (r *visibilityRewrite) String() string {
+	return fmt.Sprintf("set the visibility of %s in %s to:\n%s", r.toFix, r.path, build.FormatString(r.expr))
+}
+
+// resultingEntries satisfies the fileEdit interface.
+func (r *visibilityRewrite) resultingEntries() []string {
+	return stringValues(r.expr)
+}
+
+// stringValues returns the values of the strings of the given expression.
+func stringValues(expr build.Expr) []string {
+	var values []string
+	build.Walk(expr, func(e build.Expr, _ []build.Expr) {
+		if str, ok := e.(*build.StringExpr); ok {
+			values = append(values, str.Value)
+		}
+	})
+	return values
+}
+
+// merge satisfies the fileEdit interface. The rewrites of the same attribute
+// are composed.
+func (r *visibilityRewrite) merge(other fileEdit) (fileEdit, error) {
+	o, ok := other.(*visibilityRewrite)
+	if !ok || o.path != r.path || o.name != r.name {
+		return nil, nil
+	}
+	return newVisibilityRewrite(r.toFix, r.path, r.name, func(expr build.Expr) build.Expr {
+		return o.rewrite(r.rewrite(expr))
+	})
+}
+
+// apply satisfies the fileEdit interface. It writes the BUILD file with the
+// new value of the visibility attribute, rewritten from its current content.
+func (r *visibilityRewrite) apply() error {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to read BUILD file: %w", err)
+	}
+	if data, err = r.rewriteContent(data); err != nil {
+		return err
+	}
+	if err := os.WriteFile(r.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write BUILD file: %w", err)
+	}
+	return nil
+}
+
+// rewriteContent returns the given content of the BUILD file with the new
+// value of the visibility attribute.
+func (r *visibilityRewrite) rewriteContent(data []byte) ([]byte, error) {
+	file, rule, err := parseRule(r.path, data, r.name)
+	if err != nil {
+		return nil, err
+	}
+	rule.SetAttr("visibility", r.rewrite(rule.Attr("visibility")))
+	return build.Format(file), nil
+}
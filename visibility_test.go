@@ -0,0 +1,88 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+func TestParseVisibilityList(t *testing.T) {
+	for _, test := range []struct {
+		output  string
+		entries []string
+		literal bool
+	}{
+		{"", nil, true},
+		{"(missing)\n", nil, true},
+		{"[//visibility:private]\n", []string{"//visibility:private"}, true},
+		{"[//app:__pkg__ :__pkg__]\n", []string{"//app:__pkg__", ":__pkg__"}, true},
+		{"[]", []string{}, true},
+		{"select({\"//conditions:default\": []})", nil, false},
+		{"[//app:__pkg__] + OTHERS", nil, false},
+		{"A_VISIBILITY", nil, false},
+	} {
+		entries, literal := parseVisibilityList([]byte(test.output))
+		if !reflect.DeepEqual(entries, test.entries) || literal != test.literal {
+			t.Errorf("parseVisibilityList(%q) = %q, %t, want %q, %t", test.output, entries, literal, test.entries, test.literal)
+		}
+	}
+}
+
+func TestRedundantVisibility(t *testing.T) {
+	for _, test := range []struct {
+		existing  []string
+		grant     string
+		redundant []string
+	}{
+		{nil, "//app:__pkg__", nil},
+		{[]string{"//app:__pkg__"}, "//app:__pkg__", nil},
+		{[]string{"//app/a:__pkg__", "//app/b:__subpackages__", "//other:__pkg__"}, "//app:__subpackages__", []string{"//app/a:__pkg__", "//app/b:__subpackages__"}},
+		{[]string{"//app:__pkg__", "//app:__pkg__"}, "//other:__pkg__", []string{"//app:__pkg__"}},
+		{[]string{":__pkg__", "//lib:__pkg__"}, "//other:__pkg__", []string{"//lib:__pkg__"}},
+		{[]string{"//app:__pkg__", "//visibility:private"}, "//visibility:public", []string{"//app:__pkg__"}},
+		{[]string{"//app:group"}, "//visibility:public", []string{"//app:group"}},
+	} {
+		grant, err := label.Parse(test.grant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if redundant := redundantVisibility(test.existing, grant, label.New("", "lib", "lib")); !reflect.DeepEqual(redundant, test.redundant) {
+			t.Errorf("redundantVisibility(%q, %s) = %q, want %q", test.existing, test.grant, redundant, test.redundant)
+		}
+	}
+}
+
+func TestAlreadyGranted(t *testing.T) {
+	for _, test := range []struct {
+		existing []string
+		grant    string
+		granted  bool
+	}{
+		{nil, "//app:__pkg__", false},
+		{[]string{"//visibility:private"}, "//app:__pkg__", false},
+		{[]string{"//app:__pkg__"}, "//app:__pkg__", true},
+		{[]string{"//app:__subpackages__"}, "//app/sub:__pkg__", true},
+		{[]string{"//app:__pkg__"}, "//app/sub:__pkg__", false},
+		{[]string{"//visibility:public"}, "//app:__pkg__", true},
+		{[]string{":__subpackages__"}, "//lib/sub:__pkg__", true},
+		{[]string{"//tests:visibility"}, "//tests:visibility", true},
+	} {
+		grant, err := label.Parse(test.grant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if granted := alreadyGranted(test.existing, grant, label.New("", "lib", "lib")); granted != test.granted {
+			t.Errorf("alreadyGranted(%q, %s) = %t, want %t", test.existing, test.grant, granted, test.granted)
+		}
+	}
+}
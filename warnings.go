@@ -0,0 +1,84 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// maxWarnings is the number of distinct warnings of a kind printed before the
+// next ones are only counted.
+const maxWarnings = 5
+
+// warningLog prints warnings, deduplicating the identical ones and limiting
+// the number printed of each kind, so that a pathological build doesn't bury
+// the useful output. It is safe for concurrent use.
+type warningLog struct {
+	mu sync.Mutex
+	// seen are the warnings that occurred so far.
+	seen map[string]bool
+	// kinds are the kinds of warnings, by format, in the order they first
+	// occurred.
+	kinds []*warningKind
+}
+
+// warningKind counts the occurrences of the warnings sharing a format.
+type warningKind struct {
+	format string
+	// first is the first warning of the kind.
+	first      string
+	printed    int
+	suppressed int
+}
+
+// warnf prints the warning, unless it was already printed or too many
+// warnings of its kind were, in which case it's only counted.
+func (w *warningLog) warnf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.seen == nil {
+		w.seen = make(map[string]bool)
+	}
+	var kind *warningKind
+	for _, k := range w.kinds {
+		if k.format == format {
+			kind = k
+		}
+	}
+	if kind == nil {
+		kind = &warningKind{format: format, first: message}
+		w.kinds = append(w.kinds, kind)
+	}
+	if w.seen[message] || kind.printed >= maxWarnings {
+		kind.suppressed++
+		return
+	}
+	w.seen[message] = true
+	kind.printed++
+	fmt.Fprint(os.Stdout, message)
+}
+
+// flush reports the total number of occurrences of the kinds of warnings not
+// all printed, once, and resets the log.
+func (w *warningLog) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, kind := range w.kinds {
+		if kind.suppressed > 0 {
+			fmt.Fprintf(os.Stdout, "%d more warnings like the following were suppressed, %d in total:\n%s",
+				kind.suppressed, kind.printed+kind.suppressed, kind.first)
+		}
+	}
+	w.seen = nil
+	w.kinds = nil
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what the given function prints to the standard
+// output.
+func captureStdout(t *testing.T, f func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	f()
+	w.Close()
+	return <-output
+}
+
+func TestWarningLog(t *testing.T) {
+	var w warningLog
+	printed := captureStdout(t, func() {
+		for i := 0; i < maxWarnings+2; i++ {
+			w.warnf("Cannot fix //lib:%d\n", i)
+		}
+		w.warnf("Cannot read %s\n", "BUILD")
+		w.warnf("Cannot read %s\n", "BUILD")
+	})
+	var want strings.Builder
+	for i := 0; i < maxWarnings; i++ {
+		fmt.Fprintf(&want, "Cannot fix //lib:%d\n", i)
+	}
+	want.WriteString("Cannot read BUILD\n")
+	if printed != want.String() {
+		t.Errorf("warnf() printed:\n%s\nwant:\n%s", printed, want.String())
+	}
+
+	flushed := captureStdout(t, w.flush)
+	want.Reset()
+	want.WriteString("2 more warnings like the following were suppressed, 7 in total:\nCannot fix //lib:0\n")
+	want.WriteString("1 more warnings like the following were suppressed, 2 in total:\nCannot read BUILD\n")
+	if flushed != want.String() {
+		t.Errorf("flush() printed:\n%s\nwant:\n%s", flushed, want.String())
+	}
+
+	// The log starts afresh once flushed.
+	if printed := captureStdout(t, func() { w.warnf("Cannot read %s\n", "BUILD") }); printed != "Cannot read BUILD\n" {
+		t.Errorf("warnf() printed %q after flush(), want the warning", printed)
+	}
+	if flushed := captureStdout(t, w.flush); flushed != "" {
+		t.Errorf("flush() printed %q, want nothing", flushed)
+	}
+}
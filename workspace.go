@@ -0,0 +1,75 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/buildtools/edit"
+	"github.com/bazelbuild/buildtools/wspace"
+)
+
+// workspaceBoundaryFiles are the files marking the root of a workspace.
+var workspaceBoundaryFiles = []string{"MODULE.bazel", "WORKSPACE", "WORKSPACE.bazel"}
+
+// unfixableError reports a visibility issue that the plugin can't fix, with
+// the reason to be presented to the user. When the fix is blocked by the
+// configuration, rule names the property which blocked it and override tells
+// how to allow it.
+type unfixableError struct {
+	reason   string
+	rule     string
+	override string
+}
+
+func (err *unfixableError) Error() string {
+	return err.reason
+}
+
+// workspaceRoot returns the root of the workspace the build ran in, as
+// reported by the build events, falling back to the workspace found from the
+// current directory.
+func (plugin *FixVisibilityPlugin) workspaceRoot() (string, error) {
+	if plugin.workspaceDir != "" {
+		return plugin.workspaceDir, nil
+	}
+	workspaceRoot, _ := wspace.FindWorkspaceRoot("")
+	if workspaceRoot == "" {
+		return "", fmt.Errorf("failed to find the workspace root")
+	}
+	return workspaceRoot, nil
+}
+
+// nestedWorkspace returns the root of the workspace nested in the given
+// workspace root that contains the given package, if any.
+func nestedWorkspace(workspaceRoot, pkg string) string {
+	nested := ""
+	for dir := pkg; dir != "." && dir != "/" && dir != ""; dir = filepath.Dir(dir) {
+		for _, filename := range workspaceBoundaryFiles {
+			if _, err := os.Stat(filepath.Join(workspaceRoot, dir, filename)); err == nil {
+				nested = filepath.Join(workspaceRoot, dir)
+			}
+		}
+	}
+	return nested
+}
+
+// relativeBuildFile returns the path of the BUILD file of the given buildozer
+// target, relative to the workspace root when under it.
+func relativeBuildFile(workspaceRoot, target string) string {
+	buildFile, _, _ := edit.InterpretLabelForWorkspaceLocation("", target)
+	if rel, err := filepath.Rel(workspaceRoot, buildFile); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return buildFile
+}
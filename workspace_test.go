@@ -0,0 +1,54 @@
+/*
+ * Copyright 2022 Aspect Build Systems, Inc. All rights reserved.
+ *
+ * Licensed under the aspect.build Community License (the "License");
+ * you may not use this file except in compliance with the License.
+ * Full License text is in the LICENSE file included in the root of this repository
+ * and at https://aspect.build/communitylicense
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNestedWorkspace(t *testing.T) {
+	workspaceRoot := t.TempDir()
+	for _, path := range []string{
+		"WORKSPACE",
+		"lib/BUILD.bazel",
+		"third_party/dep/MODULE.bazel",
+		"third_party/dep/src/BUILD.bazel",
+		"examples/WORKSPACE.bazel",
+		"examples/nested/WORKSPACE",
+	} {
+		path = filepath.Join(workspaceRoot, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		pkg    string
+		nested string
+	}{
+		{"", ""},
+		{"lib", ""},
+		{"third_party/dep", "third_party/dep"},
+		{"third_party/dep/src", "third_party/dep"},
+		{"examples/nested/sub", "examples"},
+	} {
+		nested := nestedWorkspace(workspaceRoot, test.pkg)
+		if test.nested != "" {
+			test.nested = filepath.Join(workspaceRoot, test.nested)
+		}
+		if nested != test.nested {
+			t.Errorf("nestedWorkspace(%q) = %q, want %q", test.pkg, nested, test.nested)
+		}
+	}
+}